@@ -10,41 +10,114 @@ import (
 // features.
 type Aggregator struct {
 	lock *sync.Mutex
-	// never delete from this map. it's keyed by size.
+	// keyed by size. Nothing is deleted from this map unless eviction is
+	// enabled with SetMaxSessions(), in which case the keys above an evicted
+	// session shift down to fill the gap.
 	session       map[int]*eidc32proxy.Session
+	maxSessions   *int                            // 0 (the default) disables eviction
+	ended         func(*eidc32proxy.Session) bool // Reports whether a session may be evicted
 	saLock        *sync.Mutex
 	sessionAlerts map[chan int]struct{}
+	evictAlerts   map[chan int]struct{}
 }
 
 // NewAggregator creates a new session/message aggregator. Pass it a channel
 // that supplies new sessions from the server as they're created.
 func NewAggregator(newSessChan chan *eidc32proxy.Session) Aggregator {
-	a := Aggregator{
+	a := newAggregator()
+	go a.handleSessions(newSessChan)
+	return a
+}
+
+func newAggregator() Aggregator {
+	return Aggregator{
 		lock:          &sync.Mutex{},
 		session:       make(map[int]*eidc32proxy.Session),
+		maxSessions:   new(int),
+		ended:         sessionEnded,
 		saLock:        &sync.Mutex{},
 		sessionAlerts: make(map[chan int]struct{}),
+		evictAlerts:   make(map[chan int]struct{}),
 	}
-	go a.handleSessions(newSessChan)
-	return a
+}
+
+// sessionEnded returns true for sessions which are over. Placeholders left by
+// AddGarbage() count as ended.
+func sessionEnded(s *eidc32proxy.Session) bool {
+	return s == nil || s.Context().Err() != nil
 }
 
 func (o *Aggregator) handleSessions(newSessChan chan *eidc32proxy.Session) {
 	for newSession := range newSessChan {
-		// Add the session to the aggregator's map[int]Session
-		o.lock.Lock()
-		i := o.size()
-		o.session[i] = newSession
-		o.lock.Unlock()
+		o.add(newSession)
+	}
+}
+
+// add makes room for a new session (if eviction is enabled) and adds it.
+func (o *Aggregator) add(newSession *eidc32proxy.Session) {
+	for {
+		evicted := o.evictOne()
+		if evicted < 0 {
+			break
+		}
 
-		// Update subscribers about the new Session
+		// Update subscribers about the evicted Session
 		o.saLock.Lock()
-		for c := range o.sessionAlerts {
-			c <- i
+		for c := range o.evictAlerts {
+			c <- evicted
 		}
 		o.saLock.Unlock()
+	}
+
+	// Add the session to the aggregator's map[int]Session
+	o.lock.Lock()
+	i := o.size()
+	o.session[i] = newSession
+	o.lock.Unlock()
 
+	// Update subscribers about the new Session
+	o.saLock.Lock()
+	for c := range o.sessionAlerts {
+		c <- i
 	}
+	o.saLock.Unlock()
+}
+
+// evictOne removes the oldest ended session if the aggregator is at its
+// limit, shifting newer sessions down to fill the gap. It returns the index
+// of the evicted session, or -1 if nothing was evicted.
+func (o *Aggregator) evictOne() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	size := o.size()
+	if *o.maxSessions <= 0 || size < *o.maxSessions {
+		return -1
+	}
+
+	for i := 0; i < size; i++ {
+		if !o.ended(o.session[i]) {
+			continue
+		}
+		for j := i; j < size-1; j++ {
+			o.session[j] = o.session[j+1]
+		}
+		delete(o.session, size-1)
+		return i
+	}
+	return -1
+}
+
+// SetMaxSessions limits the number of sessions the aggregator holds onto.
+// When a new session arrives with the aggregator at the limit, the oldest
+// ended session is evicted and the index of every session after it drops by
+// one (see SubscribeToEvictions()). Sessions which haven't ended are never
+// evicted, so the limit is exceeded if they're all still running. Zero (the
+// default) keeps every session forever, so indexes never change.
+func (o Aggregator) SetMaxSessions(n int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	*o.maxSessions = n
 }
 
 // Size returns the number of sessions known to the aggregator
@@ -102,6 +175,26 @@ func (o *Aggregator) SubscribeToSessionAlerts() (chan int, func()) {
 	}
 }
 
+// SubscribeToEvictions returns a channel on which subscribers learn the
+// aggregator index (int) of sessions evicted to make room for new ones (see
+// SetMaxSessions()), and a function which ends the subscription. Sessions
+// with higher indexes have moved down by one by the time the index arrives.
+// Callers must take care to call the function which ends the subscription.
+func (o *Aggregator) SubscribeToEvictions() (chan int, func()) {
+	c := make(chan int)
+
+	o.saLock.Lock()
+	o.evictAlerts[c] = struct{}{}
+	o.saLock.Unlock()
+
+	return c, func() {
+		o.saLock.Lock()
+		defer o.saLock.Unlock()
+		delete(o.evictAlerts, c)
+		close(c)
+	}
+}
+
 type SessionErr struct {
 	ID  int
 	Err error
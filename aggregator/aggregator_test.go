@@ -0,0 +1,78 @@
+package aggregator
+
+import (
+	"github.com/chrismarget/eidc32proxy"
+	"testing"
+)
+
+func TestAggregator_Eviction(t *testing.T) {
+	a := newAggregator()
+	ended := make(map[*eidc32proxy.Session]bool)
+	a.ended = func(s *eidc32proxy.Session) bool { return ended[s] }
+
+	evictions, stop := a.SubscribeToEvictions()
+	evicted := make(chan []int)
+	go func() {
+		var result []int
+		for i := range evictions {
+			result = append(result, i)
+		}
+		evicted <- result
+	}()
+
+	var sessions []*eidc32proxy.Session
+	for i := 0; i < 5; i++ {
+		s := &eidc32proxy.Session{}
+		sessions = append(sessions, s)
+		a.add(s)
+	}
+
+	// no limit: nothing is evicted, even ended sessions
+	ended[sessions[1]] = true
+	ended[sessions[3]] = true
+	s5 := &eidc32proxy.Session{}
+	a.add(s5)
+	if a.Size() != 6 {
+		t.Fatalf("expected 6 sessions, got %d", a.Size())
+	}
+
+	// at the limit: the oldest ended session makes room
+	a.SetMaxSessions(6)
+	s6 := &eidc32proxy.Session{}
+	a.add(s6)
+	expected := []*eidc32proxy.Session{sessions[0], sessions[2], sessions[3], sessions[4], s5, s6}
+	if a.Size() != len(expected) {
+		t.Fatalf("expected %d sessions, got %d", len(expected), a.Size())
+	}
+	for i, s := range expected {
+		if a.GetSession(i) != s {
+			t.Fatalf("unexpected session at index %d", i)
+		}
+	}
+
+	// lowering the limit evicts all ended sessions, then overflows
+	a.SetMaxSessions(2)
+	s7 := &eidc32proxy.Session{}
+	a.add(s7)
+	expected = []*eidc32proxy.Session{sessions[0], sessions[2], sessions[4], s5, s6, s7}
+	if a.Size() != len(expected) {
+		t.Fatalf("expected %d sessions, got %d", len(expected), a.Size())
+	}
+	for i, s := range expected {
+		if a.GetSession(i) != s {
+			t.Fatalf("unexpected session at index %d", i)
+		}
+	}
+
+	stop()
+	result := <-evicted
+	expectedEvictions := []int{1, 2}
+	if len(result) != len(expectedEvictions) {
+		t.Fatalf("expected evictions %v, got %v", expectedEvictions, result)
+	}
+	for i := range expectedEvictions {
+		if result[i] != expectedEvictions[i] {
+			t.Fatalf("expected evictions %v, got %v", expectedEvictions, result)
+		}
+	}
+}
@@ -1,6 +1,106 @@
 package eidc32proxy
 
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Wiegand formats understood by DecodeWiegand()
+const (
+	WiegandH10301 = "H10301" // 26-bit: even parity, 8-bit site code, 16-bit card code, odd parity
+	WiegandH10304 = "H10304" // 37-bit: even parity, 16-bit site code, 19-bit card code, odd parity
+)
+
 type Card struct {
 	SiteCode int
 	CardCode int
 }
+
+// ParseCard parses a card in the "sitecode:cardcode" form produced by
+// Card.String().
+func ParseCard(in string) (Card, error) {
+	cardInfo := strings.Split(in, ":")
+	if len(cardInfo) != 2 {
+		return Card{}, fmt.Errorf("%s is not a valid card spec", in)
+	}
+	siteCode, err := strconv.Atoi(cardInfo[0])
+	if err != nil {
+		return Card{}, err
+	}
+	cardCode, err := strconv.Atoi(cardInfo[1])
+	if err != nil {
+		return Card{}, err
+	}
+	return Card{SiteCode: siteCode, CardCode: cardCode}, nil
+}
+
+// String returns the card in "sitecode:cardcode" form.
+func (o Card) String() string {
+	return strconv.Itoa(o.SiteCode) + ":" + strconv.Itoa(o.CardCode)
+}
+
+// wiegandFormat describes a Wiegand format with a leading even parity bit
+// and a trailing odd parity bit, each covering (roughly) half of the data.
+type wiegandFormat struct {
+	siteBits   uint // width of the site code field
+	cardBits   uint // width of the card code field
+	evenParity uint // number of data bits (from the left) covered by the leading even parity bit
+	oddParity  uint // number of data bits (from the right) covered by the trailing odd parity bit
+}
+
+func (o wiegandFormat) len() uint {
+	return o.siteBits + o.cardBits + 2
+}
+
+var (
+	h10301 = wiegandFormat{siteBits: 8, cardBits: 16, evenParity: 12, oddParity: 12}
+	h10304 = wiegandFormat{siteBits: 16, cardBits: 19, evenParity: 18, oddParity: 18}
+
+	// wiegandFormats maps format names, including the "short" and "long"
+	// values found in ConnectedRequest.CardFormat, to Wiegand formats.
+	wiegandFormats = map[string]wiegandFormat{
+		"h10301": h10301,
+		"26":     h10301,
+		"short":  h10301,
+		"h10304": h10304,
+		"37":     h10304,
+		"long":   h10304,
+	}
+)
+
+// DecodeWiegand extracts the site and card codes from raw Wiegand data. The
+// first bit received is the most significant bit of wiegand. Format is one of
+// the Wiegand* constants, a bit length ("26" or "37"), or an Infinias card
+// format name ("short" or "long", see ConnectedRequest.CardFormat). Parity
+// is checked.
+func DecodeWiegand(format string, wiegand uint64) (Card, error) {
+	f, ok := wiegandFormats[strings.ToLower(format)]
+	if !ok {
+		return Card{}, fmt.Errorf("unknown wiegand format '%s'", format)
+	}
+
+	if wiegand>>f.len() != 0 {
+		return Card{}, fmt.Errorf("wiegand data 0x%x is too long for %d-bit format %s", wiegand, f.len(), format)
+	}
+
+	dataBits := f.siteBits + f.cardBits
+	data := (wiegand >> 1) & (1<<dataBits - 1)
+	evenBit := wiegand >> (dataBits + 1) & 1
+	oddBit := wiegand & 1
+
+	evenData := data >> (dataBits - f.evenParity)
+	if (bits.OnesCount64(evenData)+int(evenBit))%2 != 0 {
+		return Card{}, fmt.Errorf("wiegand data 0x%x fails even parity check", wiegand)
+	}
+	oddData := data & (1<<f.oddParity - 1)
+	if (bits.OnesCount64(oddData)+int(oddBit))%2 != 1 {
+		return Card{}, fmt.Errorf("wiegand data 0x%x fails odd parity check", wiegand)
+	}
+
+	return Card{
+		SiteCode: int(data >> f.cardBits),
+		CardCode: int(data & (1<<f.cardBits - 1)),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package eidc32proxy
+
+import (
+	"testing"
+)
+
+func TestDecodeWiegand(t *testing.T) {
+	testData := []struct {
+		format   string
+		wiegand  uint64
+		expected Card
+	}{
+		{format: WiegandH10301, wiegand: 0x2020002, expected: Card{SiteCode: 1, CardCode: 1}},
+		{format: "26", wiegand: 0x246073, expected: Card{SiteCode: 18, CardCode: 12345}},
+		{format: "short", wiegand: 0x1ffffff, expected: Card{SiteCode: 255, CardCode: 65535}},
+		{format: WiegandH10304, wiegand: 0x1000100002, expected: Card{SiteCode: 1, CardCode: 1}},
+		{format: "37", wiegand: 0x3e8f4241, expected: Card{SiteCode: 1000, CardCode: 500000}},
+		{format: "LONG", wiegand: 0xfffffffff, expected: Card{SiteCode: 65535, CardCode: 524287}},
+	}
+
+	for _, td := range testData {
+		result, err := DecodeWiegand(td.format, td.wiegand)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != td.expected {
+			t.Fatalf("%s 0x%x: expected %+v, got %+v", td.format, td.wiegand, td.expected, result)
+		}
+	}
+}
+
+func TestDecodeWiegandErrors(t *testing.T) {
+	testData := []struct {
+		format  string
+		wiegand uint64
+	}{
+		{format: "H10302", wiegand: 0x2020002},         // unknown format
+		{format: WiegandH10301, wiegand: 0x4020002},    // too many bits
+		{format: WiegandH10301, wiegand: 0x0020002},    // bad even parity
+		{format: WiegandH10301, wiegand: 0x2020003},    // bad odd parity
+		{format: WiegandH10304, wiegand: 0x0000100002}, // bad even parity
+	}
+
+	for _, td := range testData {
+		_, err := DecodeWiegand(td.format, td.wiegand)
+		if err == nil {
+			t.Fatalf("%s 0x%x: expected an error", td.format, td.wiegand)
+		}
+	}
+}
+
+func TestParseCard(t *testing.T) {
+	card, err := ParseCard("18:12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Card{SiteCode: 18, CardCode: 12345}
+	if card != expected {
+		t.Fatalf("expected %+v, got %+v", expected, card)
+	}
+	if card.String() != "18:12345" {
+		t.Fatalf("expected 18:12345, got %s", card.String())
+	}
+
+	for _, bad := range []string{"", "18", "18-12345", "18:12345:1", "x:12345", "18:y", "18:"} {
+		_, err = ParseCard(bad)
+		if err == nil {
+			t.Fatalf("expected an error parsing '%s'", bad)
+		}
+	}
+}
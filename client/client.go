@@ -1,11 +1,11 @@
 package client
 
 import (
-	"bufio"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/chrismarget/eidc32proxy"
@@ -33,7 +33,7 @@ func ConnectWithConfig(config ConnectionConfig) (*Client, error) {
 		return nil, err
 	}
 
-	client := UpgradeConnToClient(conn, config.Pager)
+	client := upgradeConnToClient(conn, config.Pager, config.Events)
 
 	if config.FirstWriteTimeout > 0 {
 		err = client.SendRawWithin(raw, config.FirstWriteTimeout)
@@ -54,6 +54,45 @@ func ConnectWithConfig(config ConnectionConfig) (*Client, error) {
 	return client, nil
 }
 
+// ConnectMany connects a client for each of configs using ConnectWithConfig,
+// with at most concurrency connection attempts in flight at once (concurrency
+// less than 1 is treated as 1). The returned slices line up with configs: for
+// each config, either the client or the error is nil. A failed connection
+// doesn't affect the others.
+func ConnectMany(configs []ConnectionConfig, concurrency int) ([]*Client, []error) {
+	clients := make([]*Client, len(configs))
+	errs := make([]error, len(configs))
+	ConnectManyFunc(configs, concurrency, func(i int, client *Client, err error) {
+		clients[i], errs[i] = client, err
+	})
+	return clients, errs
+}
+
+// ConnectManyFunc is ConnectMany, but rather than waiting for every attempt
+// to finish, it calls fn with the index of the config and the result of each
+// attempt as soon as it's done, so the caller can start using a client while
+// the others are still connecting. fn may be called concurrently. It returns
+// after the last call to fn has returned.
+func ConnectManyFunc(configs []ConnectionConfig, concurrency int, fn func(i int, client *Client, err error)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	for i := range configs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			client, err := ConnectWithConfig(configs[i])
+			<-sem
+			fn(i, client, err)
+		}(i)
+	}
+	wg.Wait()
+}
+
 // ConnectionConfig configures a connection to an Intelli-M instance.
 type ConnectionConfig struct {
 	// URL is the IntellimURL to connect to.
@@ -77,6 +116,10 @@ type ConnectionConfig struct {
 	// Request is the ConnectedRequest body to write in the
 	// very first message to Intelli-M.
 	Request eidc32proxy.ConnectedRequest
+
+	// Events enables the client's lifecycle event channel.
+	// See Client.Events().
+	Events bool
 }
 
 func (o ConnectionConfig) Validate() error {
@@ -110,47 +153,90 @@ func (o IntellimURL) ConnectTo() *url.URL {
 }
 
 func UpgradeConnToClient(conn net.Conn, pager eidc32proxy.MessagePager) *Client {
+	return upgradeConnToClient(conn, pager, false)
+}
+
+// upgradeConnToClient is UpgradeConnToClient, optionally with a lifecycle
+// event channel (see Client.Events()).
+func upgradeConnToClient(conn net.Conn, pager eidc32proxy.MessagePager, withEvents bool) *Client {
 	onRead := make(chan []byte, 1)
 	errChan := make(chan error, 1)
+	client := &Client{
+		conn:      conn,
+		onRead:    onRead,
+		pager:     pager,
+		errChan:   errChan,
+		done:      make(chan struct{}),
+		exited:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+	if withEvents {
+		client.events = make(chan ClientEvent, clientEventBufferSize)
+	}
+	client.event(ClientEventConnect, nil)
+
 	go func() {
+		defer close(client.exited)
 		defer close(onRead)
-		scanner := bufio.NewScanner(conn)
-		scanner.Split(eidc32proxy.SplitHttpMsg)
+		scanner := eidc32proxy.NewMessageScanner(conn, eidc32proxy.Southbound)
+		firstRead := true
 		for scanner.Scan() {
+			if firstRead {
+				client.event(ClientEventFirstRead, nil)
+				firstRead = false
+			}
 			select {
 			case onRead <- scanner.Bytes():
 			default:
 			}
-			msg, err := eidc32proxy.ReadMsg(scanner.Bytes(), eidc32proxy.Southbound)
-			if err != nil {
-				// TODO: Maybe this should be a "class"
-				//  of error, and not an automatic
-				//  "kill the connection" error?
-				errChan <- err
+			msg, err := scanner.Message()
+			switch err.(type) {
+			case nil:
+			case *eidc32proxy.ErrNotHTTP, *eidc32proxy.ErrMalformedHeader,
+				*eidc32proxy.ErrTruncatedBody, *eidc32proxy.ErrMalformedBody:
+				// The scanner has already found the end of the
+				// message, so skip it and carry on with the next.
+				continue
+			default:
+				client.disconnected(err)
+				select {
+				case errChan <- err:
+				case <-client.done:
+				}
 				return
 			}
+			if msg.Type == eidc32proxy.MsgTypeGetoutboundRequest {
+				client.event(ClientEventGetOutbound, nil)
+			}
 			pager.DistributeMessage(msg)
 		}
 
+		err := scanner.Err()
+		select {
+		case <-client.done:
+			// Close() was called, so the read error is expected.
+			err = nil
+		default:
+		}
+		client.disconnected(err)
 		select {
-		case errChan <- scanner.Err():
+		case errChan <- err:
 		default:
 		}
 	}()
 
-	return &Client{
-		conn:    conn,
-		onRead:  onRead,
-		pager:   pager,
-		errChan: errChan,
-	}
+	return client
 }
 
 type Client struct {
-	conn    net.Conn
-	pager   eidc32proxy.MessagePager
-	errChan <-chan error
-	onRead  <-chan []byte
+	conn      net.Conn
+	pager     eidc32proxy.MessagePager
+	errChan   <-chan error
+	onRead    <-chan []byte
+	done      chan struct{} // closed by Close()
+	exited    chan struct{} // closed when the reader goroutine exits
+	closeOnce *sync.Once
+	events    chan ClientEvent // nil unless ConnectionConfig.Events
 }
 
 func (o *Client) OnConnClosed() <-chan error {
@@ -194,8 +280,22 @@ func (o *Client) ReadWithin(timeout time.Duration) ([]byte, error) {
 	}
 }
 
+// Close closes the connection. The reader goroutine stops without reporting
+// the resulting read error: OnConnClosed() gets nil, as though the far end had
+// closed the connection. Use Wait() to be sure the reader has finished.
 func (o *Client) Close() error {
-	return o.conn.Close()
+	var err error
+	o.closeOnce.Do(func() {
+		close(o.done)
+		err = o.conn.Close()
+	})
+	return err
+}
+
+// Wait blocks until the client's reader goroutine has exited, either because
+// the connection was closed at either end or because of a read error.
+func (o *Client) Wait() {
+	<-o.exited
 }
 
 // SubscribeTo helps to subscribe to a MessagePager for several types of
@@ -218,6 +318,19 @@ func SubscribeTo(pager eidc32proxy.MessagePager, msgTypes ...eidc32proxy.MsgType
 	return chans, unsubFns
 }
 
+// SubscribeToDangerous subscribes to the MessagePager for each of the
+// message types which reboot, wipe or reflash an eIDC. Channels (and unsub
+// functions) are returned in the following order: reboot, resetdb,
+// defaultconfig, reflash. Simulated clients can use these to log or refuse
+// destructive commands rather than treating them as unsupported garbage.
+func SubscribeToDangerous(pager eidc32proxy.MessagePager) ([]<-chan eidc32proxy.Message, []func()) {
+	return SubscribeTo(pager,
+		eidc32proxy.MsgTypeRebootRequest,
+		eidc32proxy.MsgTypeResetDBRequest,
+		eidc32proxy.MsgTypeDefaultConfigRequest,
+		eidc32proxy.MsgTypeReflashRequest)
+}
+
 // TrueDat starts a go routine for each provided Message channel and attempts
 // to automatically respond with a response whose 'result' field is set
 // to 'true'. Any response failures (such as response serialization errors,
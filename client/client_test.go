@@ -0,0 +1,304 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrismarget/eidc32proxy"
+)
+
+func TestSubscribeToDangerous(t *testing.T) {
+	testData := "GET /eidc/defaultconfig?username=admin&password=admin&seq=14 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := eidc32proxy.ReadMsg([]byte(testData), eidc32proxy.Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pager := eidc32proxy.NewMessagePager()
+	chans, unsubFns := SubscribeToDangerous(pager)
+	defer func() {
+		for _, unsub := range unsubFns {
+			unsub()
+		}
+	}()
+	if len(chans) != 4 {
+		t.Fatalf("expected 4 channels, got %d", len(chans))
+	}
+
+	go pager.DistributeMessage(msg)
+
+	// defaultconfig is the third channel
+	select {
+	case result := <-chans[2]:
+		if result.GetType() != eidc32proxy.MsgTypeDefaultConfigRequest {
+			t.Fatalf("expected %s, got %s",
+				eidc32proxy.MsgTypeDefaultConfigRequest, result.GetType())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for defaultconfig message")
+	}
+}
+
+func TestUpgradeConnToClientSkipsBadMessages(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	pager := eidc32proxy.NewMessagePager()
+	msgs, unsub := pager.Subscribe(eidc32proxy.SubInfo{Category: eidc32proxy.SubMsgCatAny})
+	defer unsub()
+	client := UpgradeConnToClient(local, pager)
+
+	go func() {
+		remote.Write([]byte("this is not http\r\n\r\n"))
+		remote.Write([]byte("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"))
+	}()
+
+	select {
+	case msg := <-msgs:
+		if msg.GetType() != eidc32proxy.MsgTypeHeartbeatRequest {
+			t.Fatalf("expected %s, got %s", eidc32proxy.MsgTypeHeartbeatRequest, msg.GetType())
+		}
+	case err := <-client.OnConnClosed():
+		t.Fatalf("connection closed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for heartbeat")
+	}
+}
+
+func TestClient_CloseMidRead(t *testing.T) {
+	heartbeat := []byte("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n\r\n")
+
+	for _, partial := range []bool{false, true} {
+		local, remote := net.Pipe()
+		client := UpgradeConnToClient(local, eidc32proxy.NewMessagePager())
+
+		// keep the reader busy: a stream of messages, or half of one
+		go func() {
+			defer remote.Close()
+			if partial {
+				// hold the connection open until the client closes
+				// it, so that Close() interrupts the read
+				remote.Write(heartbeat[:len(heartbeat)/2])
+				io.Copy(ioutil.Discard, remote)
+				return
+			}
+			for {
+				if _, err := remote.Write(heartbeat); err != nil {
+					return
+				}
+			}
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Close(); err != nil {
+			t.Fatalf("second Close() returned %v", err)
+		}
+
+		waited := make(chan struct{})
+		go func() {
+			client.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the reader to exit")
+		}
+
+		select {
+		case err := <-client.OnConnClosed():
+			if err != nil {
+				t.Fatalf("expected no error after Close(), got %v", err)
+			}
+		default:
+			t.Fatal("expected OnConnClosed() to report the close")
+		}
+	}
+}
+
+func TestClient_Events(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the mock IntelliM reads the connected request, asks for the outbound
+	// config, and hangs up.
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		s := bufio.NewScanner(c)
+		s.Split(eidc32proxy.SplitHttpMsg)
+		if !s.Scan() {
+			return
+		}
+		c.Write([]byte("GET /eidc/getoutbound?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"))
+	}()
+
+	config := ConnectionConfig{
+		URL:       &IntellimURL{IntelliM: &url.URL{Scheme: "http", Host: ln.Addr().String()}},
+		Pager:     eidc32proxy.NewMessagePager(),
+		ServerKey: "serverkey",
+		Events:    true,
+	}
+	client, err := ConnectWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	expected := []ClientEventType{
+		ClientEventConnect,
+		ClientEventFirstRead,
+		ClientEventGetOutbound,
+		ClientEventDisconnect,
+	}
+	var last time.Time
+	for _, eventType := range expected {
+		select {
+		case event, ok := <-client.Events():
+			if !ok {
+				t.Fatalf("events channel closed while waiting for %s", eventType)
+			}
+			if event.Type != eventType {
+				t.Fatalf("expected %s event, got %s", eventType, event.Type)
+			}
+			if event.At.Before(last) {
+				t.Fatalf("%s event timestamp went backwards", eventType)
+			}
+			if event.Err != nil {
+				t.Fatalf("unexpected error with %s event - %s", eventType, event.Err)
+			}
+			last = event.At
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s event", eventType)
+		}
+	}
+
+	select {
+	case event, ok := <-client.Events():
+		if ok {
+			t.Fatalf("unexpected %s event after disconnect", event.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("events channel wasn't closed after disconnect")
+	}
+
+	// events are off by default
+	local, remote := net.Pipe()
+	defer remote.Close()
+	quiet := UpgradeConnToClient(local, eidc32proxy.NewMessagePager())
+	defer quiet.Close()
+	if quiet.Events() != nil {
+		t.Fatal("expected a nil events channel when events weren't requested")
+	}
+}
+
+func TestConnectMany(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the mock IntelliM takes its time answering each connected request,
+	// and keeps track of how many it's handling at once.
+	var mu sync.Mutex
+	var active, maxActive int
+	var conns []net.Conn
+	defer func() {
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mu.Unlock()
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			go func() {
+				s := bufio.NewScanner(c)
+				s.Split(eidc32proxy.SplitHttpMsg)
+				if !s.Scan() {
+					return
+				}
+				time.Sleep(30 * time.Millisecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+				c.Write([]byte("GET /eidc/getoutbound?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+					"Host: 192.168.6.40\r\n" +
+					"User-Agent: eIDCListener\r\n\r\n\r\n"))
+			}()
+		}
+	}()
+
+	var configs []ConnectionConfig
+	for i := 0; i < 6; i++ {
+		configs = append(configs, ConnectionConfig{
+			URL:              &IntellimURL{IntelliM: &url.URL{Scheme: "http", Host: ln.Addr().String()}},
+			Pager:            eidc32proxy.NewMessagePager(),
+			ServerKey:        "serverkey",
+			FirstReadTimeout: 2 * time.Second,
+		})
+	}
+	configs[3].ServerKey = "" // fails validation
+
+	clients, errs := ConnectMany(configs, 2)
+	for i := range configs {
+		if i == 3 {
+			if errs[i] == nil || clients[i] != nil {
+				t.Fatalf("expected client %d to fail, got %v, %v", i, clients[i], errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Fatalf("client %d: %s", i, errs[i])
+		}
+		if clients[i] == nil {
+			t.Fatalf("client %d: expected a client", i)
+		}
+		defer clients[i].Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive > 2 {
+		t.Fatalf("expected at most 2 connections at once, got %d", maxActive)
+	}
+	if maxActive < 2 {
+		t.Fatalf("expected connections to be made in parallel, got %d at most", maxActive)
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// clientEventBufferSize is the capacity of the Events() channel. A connection
+// produces only a handful of events, so a reader which keeps up at all won't
+// miss any.
+const clientEventBufferSize = 16
+
+// ClientEventType identifies a milestone in the life of a Client's connection.
+type ClientEventType int
+
+const (
+	ClientEventConnect     ClientEventType = iota // the connection to IntelliM was established
+	ClientEventFirstRead                          // the first bytes arrived from IntelliM
+	ClientEventGetOutbound                        // IntelliM sent a getoutbound request
+	ClientEventDisconnect                         // the connection ended, see ClientEvent.Err
+)
+
+func (o ClientEventType) String() string {
+	switch o {
+	case ClientEventConnect:
+		return "Connect"
+	case ClientEventFirstRead:
+		return "First Read"
+	case ClientEventGetOutbound:
+		return "Getoutbound Seen"
+	case ClientEventDisconnect:
+		return "Disconnect"
+	}
+	return fmt.Sprintf("ClientEventType(%d)", int(o))
+}
+
+// ClientEvent is written to the Events() channel as the connection reaches
+// each milestone.
+type ClientEvent struct {
+	Type ClientEventType
+	At   time.Time
+	Err  error // the error which ended the connection (ClientEventDisconnect only), if any
+}
+
+// Events returns the client's lifecycle event channel, or nil unless the
+// client was created with ConnectionConfig.Events set. The channel is closed
+// after the ClientEventDisconnect event. Events are dropped rather than
+// holding up the connection if the channel is full.
+func (o *Client) Events() <-chan ClientEvent {
+	return o.events
+}
+
+// event writes an event to the events channel, if there is one.
+func (o *Client) event(eventType ClientEventType, err error) {
+	if o.events == nil {
+		return
+	}
+	select {
+	case o.events <- ClientEvent{Type: eventType, At: time.Now(), Err: err}:
+	default:
+	}
+}
+
+// disconnected writes the ClientEventDisconnect event and closes the events
+// channel, if there is one.
+func (o *Client) disconnected(err error) {
+	if o.events == nil {
+		return
+	}
+	o.event(ClientEventDisconnect, err)
+	close(o.events)
+}
@@ -1,19 +1,40 @@
 package client
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"math/big"
 	mathrand "math/rand"
 	"net"
 	"strings"
-	"time"
 )
 
+const (
+	serialNumberPrefix    = "0x000000"
+	serialNumberSuffixLen = 6
+)
+
+// eidcOUI is the vendor OUI at the start of every eIDC32's MAC address.
+var eidcOUI = net.HardwareAddr{0x00, 0x14, 0xE4}
+
 // RandomSiteKey generates a site key string in GUUID format.
 func RandomSiteKey() (string, error) {
+	return siteKeyFrom(rand.Reader)
+}
+
+// RandomSiteKeyFrom is like RandomSiteKey, but draws from r, so that the
+// same seed produces the same key.
+func RandomSiteKeyFrom(r *mathrand.Rand) string {
+	key, _ := siteKeyFrom(r) // reading from a *rand.Rand never fails
+	return key
+}
+
+func siteKeyFrom(r io.Reader) (string, error) {
 	b := make([]byte, 16)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(r, b)
 	if err != nil {
 		return "", err
 	}
@@ -23,55 +44,92 @@ func RandomSiteKey() (string, error) {
 
 // RandomServerKey generates a random server key string.
 func RandomServerKey() (string, error) {
+	return serverKeyFrom(rand.Reader)
+}
+
+// RandomServerKeyFrom is like RandomServerKey, but draws from r, so that the
+// same seed produces the same key.
+func RandomServerKeyFrom(r *mathrand.Rand) string {
+	key, _ := serverKeyFrom(r) // reading from a *rand.Rand never fails
+	return key
+}
+
+func serverKeyFrom(r io.Reader) (string, error) {
 	b := make([]byte, 8)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(r, b)
 	if err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(b), nil
 }
 
+// internalNets are the networks (/24) used by RandomInternalIPv4Address.
+var internalNets = [][]byte{
+	{10, 0, 1},
+	{10, 0, 2},
+	{172, 16, 1},
+	{192, 168, 1},
+}
+
+// internalHosts is the number of host addresses in each of internalNets.
+const internalHosts = 254
+
 // RandomInternalIPv4Address generates a random IPv4 address that might appear
-// in an internal network.
+// in an internal network. The network and host are chosen uniformly, the host
+// from 1 through 254 so that the address is never a network or broadcast
+// address.
 func RandomInternalIPv4Address() (net.IP, error) {
-	randomByte := func(allowZero bool) (byte, error) {
-		for {
-			b := make([]byte, 1)
-			_, err := rand.Read(b)
-			if err != nil {
-				return 0, err
-			}
-			if !allowZero && b[0] == 0 {
-				continue
-			}
-			return b[0], nil
+	return internalIPv4AddressFrom(rand.Reader)
+}
+
+// RandomInternalIPv4AddressFrom is like RandomInternalIPv4Address, but draws
+// from r, so that the same seed produces the same address.
+func RandomInternalIPv4AddressFrom(r *mathrand.Rand) net.IP {
+	ip, _ := internalIPv4AddressFrom(r) // reading from a *rand.Rand never fails
+	return ip
+}
+
+func internalIPv4AddressFrom(r io.Reader) (net.IP, error) {
+	randomInt := func(n int) (int, error) {
+		i, err := rand.Int(r, big.NewInt(int64(n)))
+		if err != nil {
+			return 0, err
 		}
+		return int(i.Int64()), nil
 	}
 
-	lastByte, err := randomByte(false)
+	randomNetsIndex, err := randomInt(len(internalNets))
 	if err != nil {
 		return nil, err
 	}
 
-	nets := [][]byte{
-		{10, 0, 1},
-		{10, 0, 2},
-		{172, 16, 1},
-		{192, 168, 1},
+	host, err := randomInt(internalHosts)
+	if err != nil {
+		return nil, err
 	}
 
-	randomNetsIndex := mathrand.New(mathrand.NewSource(time.Now().Unix())).Intn(len(nets))
-
-	return net.IPv4(nets[randomNetsIndex][0], nets[randomNetsIndex][1], nets[randomNetsIndex][2], lastByte), nil
+	n := internalNets[randomNetsIndex]
+	return net.IPv4(n[0], n[1], n[2], byte(host+1)), nil
 }
 
 // MostlyRandomMAC generates a MAC address that begins with the eIDC vendor OUI
 // and ends with randomly generated bytes greater than 02:0D:F2.
 func MostlyRandomMAC() (*EIDCMAC, error) {
+	return mostlyRandomMACFrom(rand.Reader)
+}
+
+// MostlyRandomMACFrom is like MostlyRandomMAC, but draws from r, so that the
+// same seed produces the same address.
+func MostlyRandomMACFrom(r *mathrand.Rand) *EIDCMAC {
+	mac, _ := mostlyRandomMACFrom(r) // reading from a *rand.Rand never fails
+	return mac
+}
+
+func mostlyRandomMACFrom(r io.Reader) (*EIDCMAC, error) {
 	randomByte := func(floor byte) (byte, error) {
 		for {
 			b := make([]byte, 1)
-			_, err := rand.Read(b)
+			_, err := io.ReadFull(r, b)
 			if err != nil {
 				return 0, err
 			}
@@ -107,6 +165,61 @@ func MostlyRandomMAC() (*EIDCMAC, error) {
 	return &EIDCMAC{MAC: addr}, nil
 }
 
+// Personality is the identity of one simulated eIDC.
+type Personality struct {
+	SiteKey      string
+	MAC          *EIDCMAC
+	SerialNumber string
+	IPAddress    net.IP
+	ServerKey    string
+}
+
+// NewPersonalitySet returns n personalities generated from seed. No two of
+// them share a site key, MAC address (and so serial number), IP address or
+// server key. The same seed and n always produce the same set, which makes
+// a simulated swarm reproducible. It returns an error if n is more than the
+// number of distinct IP addresses RandomInternalIPv4Address can produce.
+func NewPersonalitySet(seed int64, n int) ([]Personality, error) {
+	if n > len(internalNets)*internalHosts {
+		return nil, fmt.Errorf("can't generate %d personalities with unique IP addresses, the limit is %d",
+			n, len(internalNets)*internalHosts)
+	}
+
+	r := mathrand.New(mathrand.NewSource(seed))
+	siteKeys := make(map[string]struct{})
+	macs := make(map[string]struct{})
+	ips := make(map[string]struct{})
+	serverKeys := make(map[string]struct{})
+
+	// unique returns the next value from gen which isn't already in seen.
+	unique := func(seen map[string]struct{}, gen func() string) string {
+		for {
+			s := gen()
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				return s
+			}
+		}
+	}
+
+	result := make([]Personality, n)
+	for i := range result {
+		p := &result[i]
+		p.SiteKey = unique(siteKeys, func() string { return RandomSiteKeyFrom(r) })
+		unique(macs, func() string {
+			p.MAC = MostlyRandomMACFrom(r)
+			return p.MAC.String()
+		})
+		p.SerialNumber = SerialNumberFromMAC(p.MAC.MAC)
+		unique(ips, func() string {
+			p.IPAddress = RandomInternalIPv4AddressFrom(r)
+			return p.IPAddress.String()
+		})
+		p.ServerKey = unique(serverKeys, func() string { return RandomServerKeyFrom(r) })
+	}
+	return result, nil
+}
+
 // EIDCMAC is a wrapper struct that makes a normal net.HardwareAddr more
 // similar to a MAC used by a eIDC.
 type EIDCMAC struct {
@@ -118,6 +231,39 @@ func (o EIDCMAC) String() string {
 	return strings.ToUpper(o.MAC.String())
 }
 
+// ErrNotEIDCOUI is returned by ValidateEIDCMAC when a well-formed MAC address
+// doesn't begin with the eIDC vendor OUI. Callers which want to relax that
+// rule can check for it with errors.As().
+type ErrNotEIDCOUI struct {
+	MAC string
+}
+
+func (o *ErrNotEIDCOUI) Error() string {
+	return fmt.Sprintf("MAC address '%s' does not begin with the eIDC vendor OUI %s",
+		o.MAC, EIDCMAC{MAC: eidcOUI})
+}
+
+// ValidateEIDCMAC returns an error if the provided string isn't a MAC address
+// that a real eIDC might have: 6 bytes, beginning with the eIDC vendor OUI
+// (00:14:E4). Serial numbers derived from other addresses may be rejected by
+// IntelliM. A well-formed address with the wrong OUI produces *ErrNotEIDCOUI.
+func ValidateEIDCMAC(macStr string) error {
+	mac, err := net.ParseMAC(macStr)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address '%s' - %w", macStr, err)
+	}
+
+	if len(mac) != 6 {
+		return fmt.Errorf("MAC address '%s' should be 6 bytes, got %d", macStr, len(mac))
+	}
+
+	if !bytes.HasPrefix(mac, eidcOUI) {
+		return &ErrNotEIDCOUI{MAC: macStr}
+	}
+
+	return nil
+}
+
 // SerialNumberFromMACString returns a eIDC serial number using the provided
 // MAC address string. The address is validated before creating the
 // serial number.
@@ -137,7 +283,28 @@ func SerialNumberFromMAC(mac net.HardwareAddr) string {
 }
 
 // SerialNumberWithSuffix returns a eIDC serial number without performing any
-// validation on the provided serial number suffix.
+// validation on the provided serial number suffix. See ValidateSerialNumber().
 func SerialNumberWithSuffix(suffix string) string {
-	return fmt.Sprintf("0x000000%s", suffix)
+	return fmt.Sprintf("%s%s", serialNumberPrefix, suffix)
+}
+
+// ValidateSerialNumber returns an error if the provided string doesn't look
+// like an eIDC serial number: "0x" followed by 12 hex digits, the last 6 of
+// which are normally the last 3 bytes of the MAC address.
+func ValidateSerialNumber(s string) error {
+	if !strings.HasPrefix(s, "0x") {
+		return fmt.Errorf("serial number '%s' does not begin with '0x'", s)
+	}
+
+	if len(s) != len(serialNumberPrefix)+serialNumberSuffixLen {
+		return fmt.Errorf("serial number '%s' should be %d characters, got %d",
+			s, len(serialNumberPrefix)+serialNumberSuffixLen, len(s))
+	}
+
+	_, err := hex.DecodeString(s[len("0x"):])
+	if err != nil {
+		return fmt.Errorf("serial number '%s' is not hexadecimal - %w", s, err)
+	}
+
+	return nil
 }
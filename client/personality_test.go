@@ -0,0 +1,187 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSerialNumber(t *testing.T) {
+	valid := []string{
+		"0x000000123456",
+		"0x000000ABCDEF",
+		"0x000000abcdef",
+		SerialNumberWithSuffix("01E4FF"),
+	}
+	for _, s := range valid {
+		err := ValidateSerialNumber(s)
+		if err != nil {
+			t.Fatalf("serial number '%s' should be valid - %s", s, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"000000123456",
+		"0X000000123456",
+		"0x00000012345",
+		"0x0000001234567",
+		"0x00000012345G",
+		"0x000000 12345",
+		SerialNumberWithSuffix("0014E4012345"),
+	}
+	for _, s := range invalid {
+		err := ValidateSerialNumber(s)
+		if err == nil {
+			t.Fatalf("serial number '%s' should be invalid", s)
+		}
+	}
+}
+
+func TestSerialNumberFromMAC(t *testing.T) {
+	sn, err := SerialNumberFromMACString("00:14:E4:01:23:45")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "0x000000012345"
+	if sn != expected {
+		t.Fatalf("expected %s, got %s", expected, sn)
+	}
+	err = ValidateSerialNumber(sn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateEIDCMAC(t *testing.T) {
+	valid := []string{
+		"00:14:E4:01:23:45",
+		"00:14:e4:ab:cd:ef",
+		"00-14-E4-01-23-45",
+		"0014.e401.2345",
+	}
+	for _, s := range valid {
+		err := ValidateEIDCMAC(s)
+		if err != nil {
+			t.Fatalf("MAC address '%s' should be valid - %s", s, err)
+		}
+	}
+
+	wrongOUI := []string{
+		"00:14:E5:01:23:45",
+		"01:23:45:01:23:45",
+	}
+	for _, s := range wrongOUI {
+		err := ValidateEIDCMAC(s)
+		var ouiErr *ErrNotEIDCOUI
+		if !errors.As(err, &ouiErr) {
+			t.Fatalf("MAC address '%s' should produce *ErrNotEIDCOUI, got %v", s, err)
+		}
+		if !strings.Contains(err.Error(), "00:14:E4") {
+			t.Fatalf("expected the error to name the eIDC OUI, got '%s'", err)
+		}
+	}
+
+	malformed := []string{
+		"",
+		"00:14:E4:01:23",
+		"00:14:E4:01:23:4G",
+		"00:14:E4:01:23:45:67:89",
+	}
+	for _, s := range malformed {
+		err := ValidateEIDCMAC(s)
+		if err == nil {
+			t.Fatalf("MAC address '%s' should be invalid", s)
+		}
+		var ouiErr *ErrNotEIDCOUI
+		if errors.As(err, &ouiErr) {
+			t.Fatalf("malformed MAC address '%s' shouldn't be reported as a wrong OUI", s)
+		}
+	}
+}
+
+func TestRandomInternalIPv4Address(t *testing.T) {
+	nets := map[string]int{
+		"10.0.1":    0,
+		"10.0.2":    0,
+		"172.16.1":  0,
+		"192.168.1": 0,
+	}
+	hosts := make(map[byte]struct{})
+
+	calls := 4000
+	for i := 0; i < calls; i++ {
+		ip, err := RandomInternalIPv4Address()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip4 := ip.To4()
+		network := fmt.Sprintf("%d.%d.%d", ip4[0], ip4[1], ip4[2])
+		if _, ok := nets[network]; !ok {
+			t.Fatalf("unexpected network in %s", ip)
+		}
+		nets[network]++
+		if ip4[3] == 0 || ip4[3] == 255 {
+			t.Fatalf("unexpected host address %s", ip)
+		}
+		hosts[ip4[3]] = struct{}{}
+	}
+
+	// 1000 expected per network, with a standard deviation of about 27
+	for network, n := range nets {
+		if n < 800 || n > 1200 {
+			t.Fatalf("network %s chosen %d times out of %d, expected about %d",
+				network, n, calls, calls/len(nets))
+		}
+	}
+
+	// each host is expected about 16 times, so all of them should turn up
+	if len(hosts) < 250 {
+		t.Fatalf("only %d distinct host addresses in %d calls", len(hosts), calls)
+	}
+}
+
+func TestNewPersonalitySet(t *testing.T) {
+	n := 200
+	a, err := NewPersonalitySet(42, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPersonalitySet(42, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("expected identical personality sets from the same seed")
+	}
+
+	c, err := NewPersonalitySet(43, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(a, c) {
+		t.Fatal("expected different personality sets from different seeds")
+	}
+
+	seen := make(map[string]struct{})
+	for _, p := range a {
+		for _, s := range []string{p.SiteKey, p.MAC.String(), p.SerialNumber, p.IPAddress.String(), p.ServerKey} {
+			if _, ok := seen[s]; ok {
+				t.Fatalf("duplicate value '%s' in personality set", s)
+			}
+			seen[s] = struct{}{}
+		}
+		if err = ValidateEIDCMAC(p.MAC.String()); err != nil {
+			t.Fatal(err)
+		}
+		if err = ValidateSerialNumber(p.SerialNumber); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = NewPersonalitySet(42, 4*254+1); err == nil {
+		t.Fatal("expected an error when there aren't enough unique IP addresses")
+	}
+}
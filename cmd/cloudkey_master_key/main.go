@@ -4,11 +4,9 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"time"
 
@@ -26,28 +24,12 @@ type config struct {
 	card []eidc32proxy.Card
 }
 
-func parseCardInfo(in string) (eidc32proxy.Card, error) {
-	cardInfo := strings.Split(in, ":")
-	if len(cardInfo) != 2 {
-		return eidc32proxy.Card{}, fmt.Errorf("%s is not a valid card spec", in)
-	}
-	siteCode, err := strconv.Atoi(cardInfo[0])
-	if err != nil {
-		return eidc32proxy.Card{}, err
-	}
-	cardCode, err := strconv.Atoi(cardInfo[1])
-	if err != nil {
-		return eidc32proxy.Card{}, err
-	}
-	return eidc32proxy.Card{SiteCode: siteCode, CardCode: cardCode}, nil
-}
-
 func getConfig() (*config, error) {
 	cards := flag.String("c", "", "card number in the form sitecode:cardcode,sitecode:cardcode,...")
 	flag.Parse()
 	config := &config{}
 	for _, s := range strings.Split(*cards, ",") {
-		card, err := parseCardInfo(s)
+		card, err := eidc32proxy.ParseCard(s)
 		if err != nil {
 			return nil, err
 		}
@@ -62,16 +44,11 @@ func goGoGadgetMagicCard() chan *eidc32proxy.Session {
 		for s := range trigger {
 			time.Sleep(100*time.Millisecond)
 			log.Println("omg, so unlocking that door")
-			err := s.SetLockStatus(eidc32proxy.Unlocked, true)
-			if err != nil {
-				log.Println(err)
-			}
-			time.Sleep(4 * time.Second)
-			err = s.SetLockStatus(eidc32proxy.Locked, true)
+			err := s.MomentaryUnlock(4*time.Second, true)
 			if err != nil {
 				log.Println(err)
 			}
-			log.Println("relocking that door")
+			log.Println("door relocked")
 		}
 	}()
 	return trigger
@@ -87,9 +64,6 @@ func enrollMagicCard(card eidc32proxy.Card, s *eidc32proxy.Session, noisy bool)
 		if request.CardCode != card.CardCode {
 			return false
 		}
-		if request.SiteCode != card.SiteCode {
-			return false
-		}
 		log.Println("mangler FilterFunc says: master key found")
 		return true
 	}
@@ -103,6 +77,7 @@ func enrollMagicCard(card eidc32proxy.Card, s *eidc32proxy.Session, noisy bool)
 	}
 
 	mcm := eidc32proxy.DropEidcEvent{
+		SiteCodes:  []int{card.SiteCode},
 		FilterFunc: filterFunc,
 		Session:    s,
 		OneShot:    false,
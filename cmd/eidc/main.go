@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"net/http"
@@ -26,6 +27,7 @@ func main() {
 	serverKey := flag.String("server-key", "", "The initial server key to use (defaults to random string)")
 	macAddress := flag.String("mac", "00:14:E4:01:23:45", "The eIDC MAC address to use")
 	macAddressOverride := flag.String("mac-override", "", "Override and do not validate the MAC address")
+	allowAnyOUI := flag.Bool("allow-any-oui", false, "Allow a -mac which doesn't begin with the eIDC vendor OUI")
 	serialNumberOverride := flag.String("serial-override", "", "Override the serial number (normally derived from MAC)")
 	firmwareVersion := flag.String("firmware", "3.4.20", "The client's firmware version")
 	cardFormat := flag.String("card-format", "short", "The client's card format")
@@ -55,17 +57,38 @@ client -u https://127.0.0.1:18800
 
 	var macAddressFinal string
 	if len(*macAddressOverride) > 0 {
+		// the MAC override is deliberately unvalidated, so don't insist
+		err := client.ValidateEIDCMAC(*macAddressOverride)
+		if err != nil {
+			log.Printf("[warning] %s", err.Error())
+		}
 		macAddressFinal = *macAddressOverride
 	} else {
+		err := client.ValidateEIDCMAC(*macAddress)
+		var wrongOUI *client.ErrNotEIDCOUI
+		if errors.As(err, &wrongOUI) && *allowAnyOUI {
+			log.Printf("[warning] %s", err.Error())
+		} else if err != nil {
+			log.Fatalf("invalid mac address - %s", err.Error())
+		}
 		macAddressFinal = *macAddress
 	}
 
 	var serialNumberFinal string
 	if len(*serialNumberOverride) > 0 {
+		err := client.ValidateSerialNumber(*serialNumberOverride)
+		if err != nil {
+			log.Fatalf("invalid serial number override - %s", err.Error())
+		}
 		serialNumberFinal = *serialNumberOverride
 	} else {
 		if len(*macAddressOverride) > 0 {
 			serialNumberFinal = client.SerialNumberWithSuffix(strings.ReplaceAll(*macAddressOverride, ":", ""))
+			// the MAC override is deliberately unvalidated, so don't insist
+			err := client.ValidateSerialNumber(serialNumberFinal)
+			if err != nil {
+				log.Printf("[warning] %s", err.Error())
+			}
 		} else {
 			sn, err := client.SerialNumberFromMACString(*macAddress)
 			if err != nil {
@@ -6,7 +6,9 @@ import (
 	"flag"
 	"github.com/chrismarget/eidc32proxy"
 	"github.com/chrismarget/eidc32proxy/display"
+	"github.com/chrismarget/eidc32proxy/metrics"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
@@ -23,18 +25,23 @@ const (
 	displayTview displayType = iota
 	displayDump
 	displayLog
+	displayCSV
 )
 
 type displayType int
 
 type config struct {
 	display displayType
+	debug   bool
+	metrics string
 }
 
 func getConfig() *config {
-	dtype := flag.String("d", "", "display type: dumpfirst/log/tview (default tview)")
+	dtype := flag.String("d", "", "display type: dumpfirst/log/tview/csv (default tview)")
+	debug := flag.Bool("debug", false, "enable display debugging hacks")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics at http://<addr>/metrics (e.g. :9180)")
 	flag.Parse()
-	config := &config{}
+	config := &config{debug: *debug, metrics: *metricsAddr}
 	switch *dtype {
 	case "tview":
 		config.display = displayTview
@@ -42,6 +49,8 @@ func getConfig() *config {
 		config.display = displayDump
 	case "log":
 		config.display = displayLog
+	case "csv":
+		config.display = displayCSV
 	}
 	return config
 }
@@ -87,12 +96,28 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// serve metrics on the admin port
+	if config.metrics != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Println("metrics server error:", http.ListenAndServe(config.metrics, mux))
+		}()
+	}
+
 	controlC := make(chan os.Signal)
 	signal.Notify(controlC, os.Interrupt, os.Kill)
 
 	// Aggregate the all server instance session channels into a single channel
 	sessAgg := func(in, out chan *eidc32proxy.Session) {
 		for newSess := range in {
+			if config.metrics != "" {
+				metrics.Watch(newSess)
+			}
+			go func(s *eidc32proxy.Session) {
+				<-s.Done()
+				log.Print(s.Summary())
+			}(newSess)
 			out <- newSess
 		}
 	}
@@ -104,9 +129,13 @@ func main() {
 
 	switch config.display {
 	case displayTview:
-		disp = display.NewTVDisplay(aggregatedSessions)
+		tvDisplay := display.NewTVDisplay(aggregatedSessions, version)
+		tvDisplay.SetDebug(config.debug)
+		disp = tvDisplay
 	case displayDump:
 		disp = display.NewDumpFirstDisplay(aggregatedSessions)
+	case displayCSV:
+		disp = display.NewCSVEventDisplay(aggregatedSessions, os.Stdout)
 	}
 
 	go disp.Run()
@@ -129,6 +158,7 @@ MAINLOOP:
 			break MAINLOOP
 		}
 	}
+	disp.Stop()
 	sslServer.Stop()
 	clearServer.Stop()
 }
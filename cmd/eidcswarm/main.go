@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -30,6 +31,12 @@ func main() {
 		"Optional MAC address to use (defaults to random value for each client)")
 	firmwareVersion := flag.String("firmware", "3.4.20", "The client's firmware version")
 	numClients := flag.Int("n", 10, "Number of clients to simulate")
+	concurrency := flag.Int("concurrency", 10, "Number of clients to connect at once")
+	seed := flag.Int64("seed", 0, "Seed for the clients' random personalities (0 picks one, which is logged)")
+	retryInterval := flag.Int("retry-interval", 1,
+		"Seconds the clients wait before reconnecting (reported as retryInterval in getoutbound responses)")
+	maxRandomRetry := flag.Int("max-random-retry", 60,
+		"Maximum random seconds added to -retry-interval (reported as maxRandomRetryInterval)")
 	showHelp := flag.Bool("h", false, "Display this help page")
 	showExamples := flag.Bool("x", false, "Show example usages")
 
@@ -67,128 +74,88 @@ client -u https://127.0.0.1:18800
 		OptionalProxy: optionalProxy,
 	}
 
-	sitekeys := make(map[string]struct{})
-	optionalSiteKey, ok := os.LookupEnv(*siteKeyEnv)
-	if !ok {
-		for i := 0; i < *numClients; i++ {
-			siteKey, err := client.RandomSiteKey()
-			if err != nil {
-				log.Fatalf("failed to generate a random site key - %s", err.Error())
-			}
-			_, ok := sitekeys[siteKey]
-			if ok {
-				i--
-				continue
-			}
-			sitekeys[siteKey] = struct{}{}
-		}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
 	}
+	log.Printf("generating client personalities with -seed %d", *seed)
+	rand.Seed(*seed)
+	personalities, err := client.NewPersonalitySet(*seed, *numClients)
+	if err != nil {
+		log.Fatalf("failed to generate client personalities - %s", err.Error())
+	}
+
+	optionalSiteKey, _ := os.LookupEnv(*siteKeyEnv)
 
-	macsToSerials := make(map[string]string)
 	var optionalSerial string
 	if len(*optionalMACAddress) > 0 {
 		optionalSerial, err = client.SerialNumberFromMACString(*optionalMACAddress)
 		if err != nil {
 			log.Fatalf("failed to generate serial number from specified mac - %s", err.Error())
 		}
-	} else {
-		for i := 0; i < *numClients; i++ {
-			mac, err := client.MostlyRandomMAC()
-			if err != nil {
-				log.Fatalf("failed to generate a random mac - %s", err.Error())
-			}
-			_, ok := macsToSerials[mac.String()]
-			if ok {
-				i--
-				continue
-			}
-			macsToSerials[mac.String()] = client.SerialNumberFromMAC(mac.MAC)
-		}
 	}
 
-	ips := make(map[string]struct{})
-	for i := 0; i < *numClients; i++ {
-		ip, err := client.RandomInternalIPv4Address()
-		if err != nil {
-			log.Fatalf("failed to generate a random ip address - %s", err.Error())
-		}
-		_, ok := ips[ip.String()]
-		if ok {
-			i--
-			continue
-		}
-		ips[ip.String()] = struct{}{}
+	retry := retryPolicy{
+		interval:  *retryInterval,
+		maxRandom: *maxRandomRetry,
 	}
 
-	serverKeys := make(map[string]struct{})
-	for i := 0; i < *numClients; i++ {
-		serverKey, err := client.RandomServerKey()
-		if err != nil {
-			log.Fatalf("failed to generate a random server key - %s", err.Error())
-		}
-		_, ok := serverKeys[serverKey]
-		if ok {
-			i--
-			continue
-		}
-		serverKeys[serverKey] = struct{}{}
-	}
-
-	var clients []*client.Client
-	wg := &sync.WaitGroup{}
-	for i := 0; i < *numClients; i++ {
+	var swarm []*swarmClient
+	var configs []client.ConnectionConfig
+	for _, personality := range personalities {
 		req := eidc32proxy.ConnectedRequest{
 			CardFormat:      "short",
 			FirmwareVersion: *firmwareVersion,
+			SiteKey:         personality.SiteKey,
+			MacAddress:      personality.MAC.String(),
+			SerialNumber:    personality.SerialNumber,
+			IPAddress:       personality.IPAddress.String(),
 		}
 		if len(optionalSiteKey) > 0 {
 			req.SiteKey = optionalSiteKey
-		} else {
-			for k := range sitekeys {
-				req.SiteKey = k
-				delete(sitekeys, k)
-				break
-			}
 		}
 		if len(*optionalMACAddress) > 0 {
 			req.MacAddress = *optionalMACAddress
 			req.SerialNumber = optionalSerial
-		} else {
-			for k, v := range macsToSerials {
-				req.MacAddress = k
-				req.SerialNumber = v
-				delete(macsToSerials, k)
-				break
-			}
-		}
-		for k := range ips {
-			req.IPAddress = k
-			delete(ips, k)
-			break
-		}
-		var serverKey string
-		for k := range serverKeys {
-			serverKey = k
-			delete(serverKeys, k)
-			break
 		}
 		raw, _ := json.MarshalIndent(&req, "", "    ")
 		log.Printf("connecting to %s with config: %s",
 			intellimURL.ConnectTo().String(), raw)
-		eidcClient, err := connectTo(client.ConnectionConfig{
+		sc, err := newSwarmClient(client.ConnectionConfig{
 			URL:               intellimURL,
 			FirstWriteTimeout: 60 * time.Second,
 			FirstReadTimeout:  60 * time.Second,
-			ServerKey:         serverKey,
+			ServerKey:         personality.ServerKey,
 			Request:           req,
-		}, wg)
+		}, retry)
 		if err != nil {
-			for _, c := range clients {
-				c.Close()
-			}
-			log.Fatalf("failed to connect client - %s", err.Error())
+			log.Fatalf("failed to set up client - %s", err.Error())
 		}
-		clients = append(clients, eidcClient)
+		swarm = append(swarm, sc)
+		configs = append(configs, sc.prepare())
+	}
+
+	// Clients which fail to connect are left out, rather than
+	// abandoning the whole swarm.
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	var connected int
+	mu := &sync.Mutex{}
+	client.ConnectManyFunc(configs, *concurrency, func(i int, eidcClient *client.Client, err error) {
+		sc := swarm[i]
+		if err != nil {
+			sc.unprepare()
+			log.Printf("[warning] %s failed to connect to %s - %s", sc.info.Request.SerialNumber,
+				sc.info.URL.ConnectTo().String(), err.Error())
+			return
+		}
+		mu.Lock()
+		connected++
+		mu.Unlock()
+		sc.keepConnected(eidcClient, stop, wg)
+	})
+	log.Printf("connected %d of %d clients", connected, len(swarm))
+	if connected == 0 {
+		log.Fatal("no clients connected")
 	}
 
 	controlC := make(chan os.Signal, 1)
@@ -203,14 +170,32 @@ client -u https://127.0.0.1:18800
 	case <-allDone:
 		log.Println("all connections ended")
 	case <-controlC:
-		for _, c := range clients {
-			c.Close()
-		}
+		close(stop)
+		<-allDone
+	}
+}
+
+// retryPolicy is the reconnect behavior of a simulated eIDC32, and what it
+// reports in its getoutbound responses.
+type retryPolicy struct {
+	interval  int // seconds
+	maxRandom int // seconds
+}
+
+// delay returns how long to wait before reconnecting: interval seconds, plus
+// up to maxRandom more.
+func (o retryPolicy) delay() time.Duration {
+	d := time.Duration(o.interval) * time.Second
+	if o.maxRandom > 0 {
+		d += time.Duration(rand.Intn(o.maxRandom+1)) * time.Second
 	}
+	return d
 }
 
-func connectTo(info client.ConnectionConfig, onExited *sync.WaitGroup) (*client.Client, error) {
-	rawGobrResp, err := eidc32proxy.EIDCHTTPResponseBytes(&eidc32proxy.EIDCHTTPResponseData{
+// getOutboundResponse returns the raw response to IntelliM's getoutbound
+// requests, reporting the retry policy.
+func getOutboundResponse(info client.ConnectionConfig, retry retryPolicy) ([]byte, error) {
+	return eidc32proxy.EIDCHTTPResponseBytes(&eidc32proxy.EIDCHTTPResponseData{
 		StatusCode: http.StatusOK,
 		WrapperBody: &eidc32proxy.EIDCSimpleResponse{
 			Cmd:    eidc32proxy.GetoutboundResponseCmd,
@@ -224,90 +209,182 @@ func connectTo(info client.ConnectionConfig, onExited *sync.WaitGroup) (*client.
 			SecondaryPort:          18800,
 			PrimarySsl:             1,
 			SecondarySsl:           1,
-			RetryInterval:          1,
-			MaxRandomRetryInterval: 60,
+			RetryInterval:          retry.interval,
+			MaxRandomRetryInterval: retry.maxRandom,
 			Enabled:                1,
 		},
 	})
+}
+
+// swarmClient is one simulated eIDC32. Each connection it makes gets a new
+// pager, set up by prepare().
+type swarmClient struct {
+	info        client.ConnectionConfig
+	retry       retryPolicy
+	rawGobrResp []byte
+	subs        *subscriptions // the subscriptions for the connection being made
+}
+
+// subscriptions are a connection's pager subscriptions.
+type subscriptions struct {
+	anyMessages         <-chan eidc32proxy.Message
+	getOutboundRequests <-chan eidc32proxy.Message
+	garbageRequests     []<-chan eidc32proxy.Message
+	dangerousRequests   []<-chan eidc32proxy.Message
+	unsubFns            []func()
+}
+
+func newSwarmClient(info client.ConnectionConfig, retry retryPolicy) (*swarmClient, error) {
+	rawGobrResp, err := getOutboundResponse(info, retry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to pre-compute response to gobr - %w", err)
 	}
+	info.Events = true
+	return &swarmClient{
+		info:        info,
+		retry:       retry,
+		rawGobrResp: rawGobrResp,
+	}, nil
+}
 
-	// Create a pager before connecting and subscribe so we
-	// do not miss any messages.
+// prepare creates a pager and subscribes to it before connecting, so we do
+// not miss any messages. It returns the config to connect with.
+func (o *swarmClient) prepare() client.ConnectionConfig {
+	info := o.info
 	info.Pager = eidc32proxy.NewMessagePager()
-	anyMessages, stopAnyMessages := info.Pager.Subscribe(eidc32proxy.SubInfo{
+
+	subs := &subscriptions{}
+	var stopAnyMessages, stopGetOutboundRequests func()
+	subs.anyMessages, stopAnyMessages = info.Pager.Subscribe(eidc32proxy.SubInfo{
 		Category: eidc32proxy.SubMsgCatAny,
 	})
-	getOutboundRequests, stopGetOutboundsRequests := info.Pager.Subscribe(eidc32proxy.SubInfo{
+	subs.getOutboundRequests, stopGetOutboundRequests = info.Pager.Subscribe(eidc32proxy.SubInfo{
 		MsgTypes: []eidc32proxy.MsgType{eidc32proxy.MsgTypeGetoutboundRequest},
 	})
-	garbageRequests, garbageUnsubFns := client.SubscribeTo(info.Pager,
+	var garbageUnsubFns, dangerousUnsubFns []func()
+	subs.garbageRequests, garbageUnsubFns = client.SubscribeTo(info.Pager,
 		eidc32proxy.MsgTypeHeartbeatRequest,
 		eidc32proxy.MsgTypeResetEventsRequest,
 		eidc32proxy.MsgTypeEnableEventsRequest,
 		eidc32proxy.MsgTypeSetOutboundRequest,
 		eidc32proxy.MsgTypeSetWebUserRequest)
-	unsubAllPagerSubsFn := func() {
-		stopAnyMessages()
-		stopGetOutboundsRequests()
-		for _, unsub := range garbageUnsubFns {
-			unsub()
-		}
+	subs.dangerousRequests, dangerousUnsubFns = client.SubscribeToDangerous(info.Pager)
+	subs.unsubFns = append([]func(){stopAnyMessages, stopGetOutboundRequests}, garbageUnsubFns...)
+	subs.unsubFns = append(subs.unsubFns, dangerousUnsubFns...)
+
+	o.subs = subs
+	return info
+}
+
+// unprepare undoes prepare(), for when the connection fails.
+func (o *swarmClient) unprepare() {
+	for _, unsub := range o.subs.unsubFns {
+		unsub()
 	}
+	o.subs = nil
+}
 
-	eidcClient, err := client.ConnectWithConfig(info)
+// connect prepares and makes a single connection.
+func (o *swarmClient) connect() (*client.Client, error) {
+	eidcClient, err := client.ConnectWithConfig(o.prepare())
 	if err != nil {
-		unsubAllPagerSubsFn()
+		o.unprepare()
 		return nil, fmt.Errorf("failed to connect to %s - %s",
-			info.URL.ConnectTo().String(), err.Error())
+			o.info.URL.ConnectTo().String(), err.Error())
 	}
+	return eidcClient, nil
+}
 
+// keepConnected looks after an established connection, reconnecting
+// according to the retry policy whenever it drops, until stop is closed.
+func (o *swarmClient) keepConnected(eidcClient *client.Client, stop <-chan struct{}, onExited *sync.WaitGroup) {
 	onExited.Add(1)
 	go func() {
-		sendWrapperFn := func(raw []byte, msgType eidc32proxy.MsgType) error {
-			log.Printf("[notice] automaically responding to '%s' with:\n%s",
-				msgType.String(), raw)
-			return eidcClient.SendRaw(raw)
-		}
-
-		respondTrueErrs := client.TrueDat(sendWrapperFn, garbageRequests...)
-
+		defer onExited.Done()
 		for {
-			select {
-			case err := <-eidcClient.OnConnClosed():
-				if err != nil && !strings.HasSuffix(err.Error(), ": use of closed network connection") {
-					log.Printf("[fatal] connection ended - %s", err.Error())
-				} else {
-					log.Println("[done] socket closed")
-				}
-				unsubAllPagerSubsFn()
-				eidcClient.Close()
-				onExited.Done()
-				return
-			case msg := <-anyMessages:
-				if msg.Direction() == eidc32proxy.Northbound {
-					log.Printf("[outgoing message]\n'%s'", msg.OrigBytes())
-				} else {
-					log.Printf("[incoming message]\n'%s'", msg.OrigBytes())
-				}
-			case <-getOutboundRequests:
-				log.Println("responding to gobr...")
-
-				err = eidcClient.SendRaw(rawGobrResp)
-				if err != nil {
-					log.Printf("failed to send response to getOutboundRequest - %s", err.Error())
-					continue
+			o.serve(eidcClient, stop)
+			for eidcClient = nil; eidcClient == nil; {
+				delay := o.retry.delay()
+				log.Printf("[retry] %s reconnecting in %s", o.info.Request.SerialNumber, delay)
+				select {
+				case <-stop:
+					return
+				case <-time.After(delay):
 				}
-
-				log.Printf("sent this response to gobr: '%s'", rawGobrResp)
-			case err := <-respondTrueErrs:
+				var err error
+				eidcClient, err = o.connect()
 				if err != nil {
-					log.Printf("[warning] failed to automatically respond to a message - %s", err.Error())
+					log.Printf("[warning] %s", err.Error())
 				}
 			}
 		}
 	}()
+}
 
-	return eidcClient, nil
+// serve looks after a connection until either it ends or stop is closed.
+func (o *swarmClient) serve(eidcClient *client.Client, stop <-chan struct{}) {
+	subs := o.subs
+	defer o.unprepare()
+
+	sendWrapperFn := func(raw []byte, msgType eidc32proxy.MsgType) error {
+		log.Printf("[notice] automaically responding to '%s' with:\n%s",
+			msgType.String(), raw)
+		return eidcClient.SendRaw(raw)
+	}
+
+	respondTrueErrs := client.TrueDat(sendWrapperFn, subs.garbageRequests...)
+	events := eidcClient.Events()
+
+	// Never pretend to reboot, wipe or reflash. Just complain loudly.
+	for _, c := range subs.dangerousRequests {
+		go func(c <-chan eidc32proxy.Message) {
+			for msg := range c {
+				log.Printf("[warning] refusing destructive command '%s'",
+					msg.GetType().String())
+			}
+		}(c)
+	}
+
+	for {
+		select {
+		case err := <-eidcClient.OnConnClosed():
+			if err != nil {
+				log.Printf("[fatal] connection ended - %s", err.Error())
+			} else {
+				log.Println("[done] socket closed")
+			}
+			eidcClient.Close()
+			return
+		case <-stop:
+			eidcClient.Close()
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			log.Printf("[event] %s %s at %s", o.info.Request.SerialNumber,
+				event.Type.String(), event.At.Format(time.RFC3339Nano))
+		case msg := <-subs.anyMessages:
+			if msg.Direction() == eidc32proxy.Northbound {
+				log.Printf("[outgoing message]\n'%s'", msg.OrigBytes())
+			} else {
+				log.Printf("[incoming message]\n'%s'", msg.OrigBytes())
+			}
+		case <-subs.getOutboundRequests:
+			log.Println("responding to gobr...")
+
+			err := eidcClient.SendRaw(o.rawGobrResp)
+			if err != nil {
+				log.Printf("failed to send response to getOutboundRequest - %s", err.Error())
+				continue
+			}
+
+			log.Printf("sent this response to gobr: '%s'", o.rawGobrResp)
+		case err := <-respondTrueErrs:
+			if err != nil {
+				log.Printf("[warning] failed to automatically respond to a message - %s", err.Error())
+			}
+		}
+	}
 }
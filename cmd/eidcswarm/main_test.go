@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/chrismarget/eidc32proxy/client"
+)
+
+func TestGetOutboundResponse(t *testing.T) {
+	target, err := url.Parse("https://intellim.example.com:18800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := client.ConnectionConfig{
+		URL:     &client.IntellimURL{IntelliM: target},
+		Request: eidc32proxy.ConnectedRequest{SiteKey: "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx"},
+	}
+
+	raw, err := getOutboundResponse(info, retryPolicy{interval: 30, maxRandom: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := eidc32proxy.ReadMsg(raw, eidc32proxy.Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gor, err := msg.ParseGetOutboundResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gor.RetryInterval != 30 || gor.MaxRandomRetryInterval != 90 {
+		t.Fatalf("expected retryInterval 30 and maxRandomRetryInterval 90, got %d and %d",
+			gor.RetryInterval, gor.MaxRandomRetryInterval)
+	}
+	if gor.PrimaryHostAddress != "intellim.example.com" || gor.SiteKey != info.Request.SiteKey {
+		t.Fatalf("unexpected getoutbound response %+v", gor)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	retry := retryPolicy{interval: 2, maxRandom: 3}
+	for i := 0; i < 100; i++ {
+		d := retry.delay()
+		if d < 2*time.Second || d > 5*time.Second {
+			t.Fatalf("expected a delay between 2s and 5s, got %s", d)
+		}
+	}
+
+	if d := (retryPolicy{interval: 1}).delay(); d != time.Second {
+		t.Fatalf("expected 1s without jitter, got %s", d)
+	}
+}
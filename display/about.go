@@ -0,0 +1,61 @@
+package display
+
+import (
+	"fmt"
+	"github.com/rivo/tview"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	aboutProgName = "eidc32proxy"
+	unknownInfo   = "unknown"
+)
+
+// aboutLegend explains the colors used by Message.PrintableLines() in the
+// message log.
+var aboutLegend = []string{
+	"[red]red[white]           northbound: eIDC32 to IntelliM",
+	"[blue]blue[white]          southbound: IntelliM to eIDC32",
+	"[::i]italic[::-]        injected by the proxy",
+	"[:red]red background[:-] dropped northbound message",
+	"[:blue]blue background[:-] dropped southbound message",
+}
+
+// aboutText returns the contents of the About pane.
+func aboutText(version string) string {
+	if version == "" {
+		version = unknownInfo
+	}
+
+	module := unknownInfo
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		module = bi.Main.Path
+		if bi.Main.Version != "" {
+			module += " " + bi.Main.Version
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow]%s[white] version %s\n\n", aboutProgName, tview.Escape(version))
+	fmt.Fprintf(&b, "Module:  %s\n", tview.Escape(module))
+	fmt.Fprintf(&b, "Go:      %s %s/%s\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	b.WriteString("[yellow]Message log colors[white]\n")
+	for _, l := range aboutLegend {
+		b.WriteString("  " + l + "\n")
+	}
+	return b.String()
+}
+
+// about is the About pane. Its text never changes, so it's set once, when
+// the pane is created.
+type about struct {
+	tv *tview.TextView
+}
+
+func newAbout(version string) about {
+	return about{
+		tv: tview.NewTextView().SetDynamicColors(true).SetText(aboutText(version)),
+	}
+}
@@ -0,0 +1,23 @@
+package display
+
+import (
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
+	"strings"
+	"testing"
+)
+
+func TestAbout(t *testing.T) {
+	d := NewTVDisplay(make(chan *eidc32proxy.Session), "1.2.3")
+	d.app.SetScreen(simulationScreen(t))
+	go d.app.Run()
+	defer d.app.Stop()
+
+	if !strings.Contains(d.about.tv.GetText(true), "1.2.3") {
+		t.Fatalf("expected the About pane to contain the version, got %q", d.about.tv.GetText(true))
+	}
+
+	// select About using its shortcut, on the event loop like a real key press
+	d.app.QueueEvent(tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone))
+	responsive(t, d.app)
+}
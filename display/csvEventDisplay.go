@@ -0,0 +1,166 @@
+package display
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const csvFlushInterval = time.Second
+
+var csvEventHeader = []string{"timestamp", "serial", "event type", "site code", "card code", "point id"}
+
+// CSVEventDisplay writes the access events (MsgTypeEventRequest) of every
+// session as CSV, one row per event, for analysis in a spreadsheet or by
+// auditors.
+type CSVEventDisplay struct {
+	sessChan    chan *eidc32proxy.Session
+	mu          sync.Mutex
+	w           *csv.Writer
+	wroteHeader bool
+	errChan     chan error
+	stopChan    chan struct{}
+	stopOnce    sync.Once
+	running     bool
+}
+
+// NewCSVEventDisplay returns an implementation of Display that writes a CSV
+// row to w for each event reported by the sessions arriving on sessChan. The
+// columns are those in the header row: event timestamp (RFC3339, UTC), eIDC32
+// serial number, event type, site code, card code and point ID. Output is
+// flushed every second and when the display stops.
+func NewCSVEventDisplay(sessChan chan *eidc32proxy.Session, w io.Writer) *CSVEventDisplay {
+	return &CSVEventDisplay{
+		sessChan: sessChan,
+		w:        csv.NewWriter(w),
+		errChan:  make(chan error, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Run starts relaying the sessions arriving on the display's session channel
+// and recording their events. It does not return until Stop() is called or
+// writing fails.
+func (o *CSVEventDisplay) Run() {
+	o.mu.Lock()
+	if o.running {
+		o.mu.Unlock()
+		o.errChan <- errors.New("display already running")
+		return
+	}
+	o.running = true
+	o.writeHeader()
+	o.mu.Unlock()
+
+	var unsubs []func()
+	defer func() {
+		for _, unsub := range unsubs {
+			unsub()
+		}
+	}()
+
+	ticker := time.NewTicker(csvFlushInterval)
+	defer ticker.Stop()
+
+	sessChan := o.sessChan
+	for {
+		select {
+		case s, ok := <-sessChan:
+			if !ok {
+				sessChan = nil
+				continue
+			}
+			unsubs = append(unsubs, o.watch(s))
+		case <-ticker.C:
+			err := o.flush()
+			if err != nil {
+				o.errChan <- err
+				return
+			}
+		case <-o.stopChan:
+			return
+		}
+	}
+}
+
+// watch records the session's events and starts it relaying. The returned
+// function ends the subscription.
+func (o *CSVEventDisplay) watch(s *eidc32proxy.Session) func() {
+	msgs, unsub := s.Pager.Subscribe(eidc32proxy.SubInfo{
+		MsgTypes: []eidc32proxy.MsgType{eidc32proxy.MsgTypeEventRequest},
+	})
+	go func() {
+		for msg := range msgs {
+			o.add(s.Serial(), msg)
+		}
+	}()
+	s.BeginRelaying()
+	return unsub
+}
+
+// eventRow returns the CSV row describing an event message.
+func eventRow(serial string, msg eidc32proxy.Message) ([]string, error) {
+	if msg.GetType() != eidc32proxy.MsgTypeEventRequest {
+		return nil, fmt.Errorf("not an event: %s", msg.GetType())
+	}
+	er, err := msg.ParseEventRequest()
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		time.Unix(int64(er.Time), 0).UTC().Format(time.RFC3339),
+		serial,
+		er.EventType.String(),
+		strconv.Itoa(er.SiteCode),
+		strconv.Itoa(er.CardCode),
+		strconv.Itoa(er.PointID),
+	}, nil
+}
+
+// add writes a row for the event message. Messages which don't parse are
+// skipped.
+func (o *CSVEventDisplay) add(serial string, msg eidc32proxy.Message) {
+	row, err := eventRow(serial, msg)
+	if err != nil {
+		return
+	}
+	o.mu.Lock()
+	o.writeHeader()
+	o.w.Write(row)
+	o.mu.Unlock()
+}
+
+// writeHeader writes the header row if it hasn't been written yet. Callers
+// must hold o.mu.
+func (o *CSVEventDisplay) writeHeader() {
+	if o.wroteHeader {
+		return
+	}
+	o.w.Write(csvEventHeader)
+	o.wroteHeader = true
+}
+
+func (o *CSVEventDisplay) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Flush()
+	return o.w.Error()
+}
+
+// ErrChan returns a channel on which the display sends write errors.
+func (o *CSVEventDisplay) ErrChan() chan error {
+	return o.errChan
+}
+
+// Stop stops the display and flushes any buffered rows.
+func (o *CSVEventDisplay) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopChan)
+	})
+	o.flush()
+}
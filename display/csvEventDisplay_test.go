@@ -0,0 +1,45 @@
+package display
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"reflect"
+	"testing"
+)
+
+func eventRequest(t *testing.T, eventType eidc32proxy.EventType, siteCode int, cardCode int, pointID int) eidc32proxy.Message {
+	payload := fmt.Sprintf(`{"eventId":7, "eventType":%d, "time":1572652251, "pointId":%d, "siteCode":%d, "cardCode":%d}`,
+		eventType, pointID, siteCode, cardCode)
+	msg, err := eidc32proxy.ReadMsg([]byte(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+		"Host: 192.168.6.10\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", eidc32proxy.EventRequestURI, len(payload), payload)), eidc32proxy.Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+func TestCSVEventDisplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	d := NewCSVEventDisplay(nil, buf)
+	d.add("0x000000123456", eventRequest(t, eidc32proxy.EventAccessGranted, 12, 3456, 2))
+	d.add("0x000000123456", pointStatusRequest(t, 1, 0, 1)) // not an event, skipped
+	d.add("0x000000654321", eventRequest(t, eidc32proxy.EventAuthentication_UnknownCard|eidc32proxy.BufferedEventFlag, 0, 99, 0))
+	d.Stop()
+
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		csvEventHeader,
+		{"2019-11-01T23:50:51Z", "0x000000123456", "AccessGranted", "12", "3456", "2"},
+		{"2019-11-01T23:50:51Z", "0x000000654321", "(Authentication_UnknownCard)", "0", "99", "0"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected %q, got %q", expected, records)
+	}
+}
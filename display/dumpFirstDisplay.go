@@ -46,6 +46,9 @@ func (o *DumpFirstDisplay) Run() {
 			printMsg(msg)
 		case err := <-sessErrChan:
 			o.errChan <- err
+		case <-session.Done():
+			unSub()
+			return
 		case <-o.stopChan:
 			unSub()
 			return
@@ -0,0 +1,210 @@
+package display
+
+import (
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/rivo/tview"
+	"strings"
+	"sync"
+)
+
+const (
+	maxLogMessages = 500
+	logTitle       = "Messages (%s)"
+	logTitleType   = "Messages (%s, %s only)"
+	anyMsgType     = "Any"
+)
+
+// logFilter selects which messages appear in the messageLog.
+type logFilter int
+
+const (
+	logFilterAll logFilter = iota
+	logFilterRequests
+	logFilterResponses
+	logFilterEvents
+	logFilterCount // not a filter, just the number of them
+)
+
+func (o logFilter) String() string {
+	switch o {
+	case logFilterAll:
+		return "all"
+	case logFilterRequests:
+		return "requests"
+	case logFilterResponses:
+		return "responses"
+	case logFilterEvents:
+		return "events"
+	}
+	return "unknown"
+}
+
+// next returns the filter after this one, wrapping around to logFilterAll.
+func (o logFilter) next() logFilter {
+	return (o + 1) % logFilterCount
+}
+
+// match returns true if msg should appear in a log using this filter.
+func (o logFilter) match(msg eidc32proxy.Message) bool {
+	switch o {
+	case logFilterRequests:
+		return msg.Request != nil
+	case logFilterResponses:
+		return msg.Response != nil
+	case logFilterEvents:
+		return msg.GetType() == eidc32proxy.MsgTypeEventRequest
+	}
+	return true
+}
+
+// messageLog is a scrolling, color-coded log of the current session's
+// messages (see Message.PrintableLines()). The most recent messages are kept
+// so that the log can be redrawn when the filter changes.
+type messageLog struct {
+	tv      *tview.TextView
+	mu      *sync.Mutex
+	filter  logFilter
+	msgType eidc32proxy.MsgType // MsgTypeUnknown shows every type
+	msgs    []eidc32proxy.Message
+	session *eidc32proxy.Session // messages from other sessions are ignored
+}
+
+func newMessageLog() *messageLog {
+	o := &messageLog{
+		tv: tview.NewTextView().SetDynamicColors(true).SetScrollable(true),
+		mu: &sync.Mutex{},
+	}
+	o.tv.SetBorder(false).SetTitle(o.title())
+	return o
+}
+
+// title describes the log's filters. Call it with the lock held.
+func (o *messageLog) title() string {
+	if o.msgType == eidc32proxy.MsgTypeUnknown {
+		return fmt.Sprintf(logTitle, o.filter)
+	}
+	return fmt.Sprintf(logTitleType, o.filter, o.msgType)
+}
+
+// show returns true if msg matches both of the log's filters. Call it with
+// the lock held.
+func (o *messageLog) show(msg eidc32proxy.Message) bool {
+	if o.msgType != eidc32proxy.MsgTypeUnknown && msg.GetType() != o.msgType {
+		return false
+	}
+	return o.filter.match(msg)
+}
+
+// printable renders msg as tview-colored text.
+func printable(msg eidc32proxy.Message) string {
+	lines, err := msg.PrintableLines()
+	if err != nil {
+		return fmt.Sprintf("[red]%s: %s[white]\n", msg.GetType(), tview.Escape(err.Error()))
+	}
+	// escape first, so message text which looks like a tag (say, "[red]" in
+	// a JSON string) isn't eaten. The ANSI color sequences survive escaping.
+	return tview.TranslateANSI(tview.Escape(strings.Join(lines, "")))
+}
+
+// add appends msg from session s to the log, and to the display if it
+// matches the filter.
+func (o *messageLog) add(app *tview.Application, s *eidc32proxy.Session, msg eidc32proxy.Message) {
+	o.mu.Lock()
+	if s != o.session {
+		// a straggler from before the last runForSession()
+		o.mu.Unlock()
+		return
+	}
+	o.msgs = append(o.msgs, msg)
+	if len(o.msgs) > maxLogMessages {
+		o.msgs = o.msgs[len(o.msgs)-maxLogMessages:]
+	}
+	show := o.show(msg)
+	o.mu.Unlock()
+
+	if !show {
+		return
+	}
+	text := printable(msg)
+	app.QueueUpdateDraw(func() {
+		o.tv.Write([]byte(text))
+		o.tv.ScrollToEnd()
+	})
+}
+
+// refresh replaces the displayed text with the kept messages which match the
+// filter. It touches the TextView directly, so it must run on the
+// application's event loop (e.g. from a List callback). Use redraw() from
+// anywhere else.
+func (o *messageLog) refresh() {
+	o.mu.Lock()
+	title := o.title()
+	var text strings.Builder
+	for _, msg := range o.msgs {
+		if o.show(msg) {
+			text.WriteString(printable(msg))
+		}
+	}
+	o.mu.Unlock()
+
+	o.tv.SetTitle(title)
+	o.tv.SetText(text.String())
+	o.tv.ScrollToEnd()
+}
+
+// redraw is refresh() for goroutines other than the application's event
+// loop. Calling it from the event loop deadlocks: QueueUpdateDraw() waits for
+// the loop to run the update.
+func (o *messageLog) redraw(app *tview.Application) {
+	app.QueueUpdateDraw(o.refresh)
+}
+
+// cycleFilter switches to the next filter and refreshes the log. Like
+// refresh(), it must run on the application's event loop.
+func (o *messageLog) cycleFilter() {
+	o.mu.Lock()
+	o.filter = o.filter.next()
+	o.mu.Unlock()
+	o.refresh()
+}
+
+// setMsgType limits the log to messages of type t (MsgTypeUnknown removes
+// the limit). It doesn't touch the display; follow it with refresh() or
+// redraw().
+func (o *messageLog) setMsgType(t eidc32proxy.MsgType) {
+	o.mu.Lock()
+	o.msgType = t
+	o.mu.Unlock()
+}
+
+// newMsgTypeMenu returns a list of every message type, preceded by an entry
+// for "any" type. selected is called with the chosen type (MsgTypeUnknown for
+// "any").
+func newMsgTypeMenu(selected func(eidc32proxy.MsgType)) *tview.List {
+	list := tview.NewList().ShowSecondaryText(false)
+	list.AddItem(anyMsgType, "", 0, func() { selected(eidc32proxy.MsgTypeUnknown) })
+	for _, t := range eidc32proxy.AllMsgTypes() {
+		t := t
+		list.AddItem(t.String(), "", 0, func() { selected(t) })
+	}
+	return list
+}
+
+// runForSession clears the log and fills it with the session's messages as
+// they're relayed (or dropped). The returned function stops the updates.
+func (o *messageLog) runForSession(app *tview.Application, s *eidc32proxy.Session) func() {
+	o.mu.Lock()
+	o.msgs = nil
+	o.session = s
+	o.mu.Unlock()
+	o.redraw(app)
+
+	msgs, unsub := s.Pager.Subscribe(eidc32proxy.SubInfo{Category: eidc32proxy.SubMsgCatAny})
+	go func() {
+		for msg := range msgs {
+			o.add(app, s, msg)
+		}
+	}()
+	return unsub
+}
@@ -0,0 +1,126 @@
+package display
+
+import (
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFilter(t *testing.T) {
+	request := pointStatusRequest(t, 12, 0, 1)
+	response := lockStatusResponse(t, "Locked")
+
+	testData := []struct {
+		filter   logFilter
+		request  bool
+		response bool
+	}{
+		{filter: logFilterAll, request: true, response: true},
+		{filter: logFilterRequests, request: true, response: false},
+		{filter: logFilterResponses, request: false, response: true},
+		{filter: logFilterEvents, request: false, response: false},
+	}
+	for _, td := range testData {
+		if td.filter.match(request) != td.request || td.filter.match(response) != td.response {
+			t.Fatalf("filter %s matched request: %t response: %t",
+				td.filter, td.filter.match(request), td.filter.match(response))
+		}
+	}
+
+	// cycling wraps around
+	f := logFilterAll
+	for i := 0; i < int(logFilterCount); i++ {
+		f = f.next()
+	}
+	if f != logFilterAll {
+		t.Fatalf("expected %s after a full cycle, got %s", logFilterAll, f)
+	}
+}
+
+func TestMessageLog(t *testing.T) {
+	ml := newMessageLog()
+	app := tview.NewApplication().SetScreen(simulationScreen(t))
+	msgs := []eidc32proxy.Message{
+		pointStatusRequest(t, 12, 0, 1),
+		lockStatusResponse(t, "Locked"),
+		pointStatusRequest(t, 16, 1, 0),
+	}
+	go func() {
+		for i := 1; i < 15; i++ {
+			time.Sleep(50 * time.Millisecond)
+			ml.add(app, nil, msgs[i%len(msgs)])
+			if i%4 == 0 {
+				// as from a List callback, on the event loop
+				app.QueueUpdateDraw(ml.cycleFilter)
+			}
+		}
+		app.Stop()
+	}()
+	err := app.SetRoot(ml.tv, true).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageLogMsgType(t *testing.T) {
+	request := pointStatusRequest(t, 12, 0, 1)
+	response := lockStatusResponse(t, "Locked")
+	menu := newMsgTypeMenu(func(eidc32proxy.MsgType) {})
+	if menu.GetItemCount() != len(eidc32proxy.AllMsgTypes())+1 {
+		t.Fatalf("expected %d menu items, got %d", len(eidc32proxy.AllMsgTypes())+1, menu.GetItemCount())
+	}
+
+	ml := newMessageLog()
+	if !ml.show(request) || !ml.show(response) {
+		t.Fatal("expected both messages to be shown by default")
+	}
+	ml.setMsgType(request.GetType())
+	if !ml.show(request) || ml.show(response) {
+		t.Fatalf("expected only %s to be shown", request.GetType())
+	}
+	ml.filter = logFilterResponses
+	if ml.show(request) || ml.show(response) {
+		t.Fatal("expected neither message to be shown")
+	}
+	ml.setMsgType(eidc32proxy.MsgTypeUnknown)
+	if ml.show(request) || !ml.show(response) {
+		t.Fatal("expected only the response to be shown")
+	}
+}
+
+func TestPrintableEscapesTags(t *testing.T) {
+	payload := `{"time":"2019-11-01T18:50:51-05:00", "points":[], "note":"[red]"}`
+	msg, err := eidc32proxy.ReadMsg([]byte(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+		"Host: 192.168.6.10\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", eidc32proxy.PointStatusRequestURI, len(payload), payload)), eidc32proxy.Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GetText(true) mangles escaped tags, so check what's actually drawn
+	tv := tview.NewTextView().SetDynamicColors(true).SetWrap(false)
+	tv.SetText(printable(*msg))
+	screen := simulationScreen(t).(tcell.SimulationScreen)
+	screen.SetSize(200, 10)
+	tv.SetRect(0, 0, 200, 10)
+	tv.Draw(screen)
+	screen.Show()
+	cells, width, _ := screen.GetContents()
+	var text strings.Builder
+	for i, cell := range cells {
+		if i > 0 && i%width == 0 {
+			text.WriteString("\n")
+		}
+		text.Write(cell.Bytes)
+	}
+	for _, expected := range []string{`"points":[]`, `"note":"[red]"`} {
+		if !strings.Contains(text.String(), expected) {
+			t.Fatalf("expected the log to contain %s, got:\n%s", expected, text.String())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package display
+
+import (
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"sort"
+)
+
+var (
+	pointsHeader = []string{"Point", "Old Status", "New Status"}
+
+	// wellKnownPoints names the points which change when the door strike
+	// operates. These are the points suppressed by Session.SetLockStatus().
+	wellKnownPoints = map[int]string{
+		12: "door strike",
+		16: "door strike",
+		38: "door strike",
+	}
+)
+
+// pointName returns a human readable label for a point ID.
+func pointName(id int) string {
+	if name, ok := wellKnownPoints[id]; ok {
+		return fmt.Sprintf("%d (%s)", id, name)
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+// pointsRows renders points as point/old status/new status rows, ordered by
+// point ID.
+func pointsRows(points map[int]eidc32proxy.Point) [][]string {
+	ids := make([]int, 0, len(points))
+	for id := range points {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	result := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		p := points[id]
+		result = append(result, []string{
+			pointName(id),
+			fmt.Sprintf("%d (0x%02x)", p.OldStatus, p.OldStatus),
+			fmt.Sprintf("%d (0x%02x)", p.NewStatus, p.NewStatus),
+		})
+	}
+	return result
+}
+
+// pointsPane is a table showing the status of each point the current
+// session's eIDC32 has reported, as tracked by Session.PointStatus().
+type pointsPane struct {
+	table *tview.Table
+}
+
+func newPointsPane() pointsPane {
+	return pointsPane{
+		table: tview.NewTable().SetBorders(false).SetFixed(1, 0),
+	}
+}
+
+// render redraws the table from points.
+func (o pointsPane) render(app *tview.Application, points map[int]eidc32proxy.Point) {
+	rows := pointsRows(points)
+	app.QueueUpdateDraw(func() {
+		o.table.Clear()
+		for col, h := range pointsHeader {
+			o.table.SetCell(0, col, tview.NewTableCell(h).SetTextColor(tcell.ColorYellow))
+		}
+		for i, row := range rows {
+			for col, cell := range row {
+				o.table.SetCellSimple(i+1, col, cell)
+			}
+		}
+	})
+}
+
+// runForSession renders the session's points and re-renders them each time
+// the eIDC32 reports a point status change. The returned function stops the
+// updates.
+func (o pointsPane) runForSession(app *tview.Application, s *eidc32proxy.Session) func() {
+	o.render(app, s.PointStatus())
+
+	msgs, unsub := s.Pager.Subscribe(eidc32proxy.SubInfo{
+		MsgTypes: []eidc32proxy.MsgType{eidc32proxy.MsgTypePointStatusRequest},
+	})
+	go func() {
+		for range msgs {
+			o.render(app, s.PointStatus())
+		}
+	}()
+	return unsub
+}
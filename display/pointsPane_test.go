@@ -0,0 +1,61 @@
+package display
+
+import (
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"testing"
+	"time"
+)
+
+func TestPointsRows(t *testing.T) {
+	rows := pointsRows(map[int]eidc32proxy.Point{
+		38: {PointID: 38, OldStatus: 1, NewStatus: 0},
+		3:  {PointID: 3, OldStatus: 0, NewStatus: 17},
+		12: {PointID: 12, OldStatus: 0, NewStatus: 1},
+	})
+
+	expected := [][]string{
+		{"3", "0 (0x00)", "17 (0x11)"},
+		{"12 (door strike)", "0 (0x00)", "1 (0x01)"},
+		{"38 (door strike)", "1 (0x01)", "0 (0x00)"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if rows[i][j] != expected[i][j] {
+				t.Fatalf("row %d: expected %v, got %v", i, expected[i], rows[i])
+			}
+		}
+	}
+}
+
+func TestPointsPane(t *testing.T) {
+	pp := newPointsPane()
+	app := tview.NewApplication().SetScreen(simulationScreen(t))
+	go func() {
+		points := make(map[int]eidc32proxy.Point)
+		for i := 1; i < 15; i++ {
+			time.Sleep(100 * time.Millisecond)
+			id := []int{12, 16, 38}[i%3]
+			points[id] = eidc32proxy.Point{PointID: id, OldStatus: points[id].NewStatus, NewStatus: i}
+			pp.render(app, points)
+		}
+		app.Stop()
+	}()
+	err := app.SetRoot(pp.table, true).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// simulationScreen returns a tcell screen which doesn't need a terminal.
+func simulationScreen(t *testing.T) tcell.Screen {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return screen
+}
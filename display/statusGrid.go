@@ -0,0 +1,157 @@
+package display
+
+import (
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+	"sort"
+	"sync"
+)
+
+const (
+	unknownStatus = "?"
+	lockRowLabel  = "Door"
+	pointRowLabel = "Point %d"
+)
+
+// statusGridModel tracks the door lock status and the status of each point
+// reported by a single eIDC32. The lock status is fed from the session's
+// pager, including responses which were dropped by manglers, so it reflects
+// what the eIDC32 actually said rather than what IntelliM was allowed to see.
+// The points are a copy of Session.PointStatus(), the same model the points
+// pane shows, so the two can't disagree.
+type statusGridModel struct {
+	mu         *sync.Mutex
+	lockStatus string
+	points     map[int]eidc32proxy.Point
+}
+
+func newStatusGridModel() *statusGridModel {
+	return &statusGridModel{
+		mu:     &sync.Mutex{},
+		points: make(map[int]eidc32proxy.Point),
+	}
+}
+
+// apply updates the door lock status with the contents of msg. It returns
+// true if the message changed the model.
+func (o *statusGridModel) apply(msg eidc32proxy.Message) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if msg.GetType() != eidc32proxy.MsgTypeDoor0x2fLockStatusResponse {
+		return false
+	}
+	dlsr, err := msg.ParseDoor0x2fLockStatusResponse()
+	if err != nil || dlsr.Status == o.lockStatus {
+		return false
+	}
+	o.lockStatus = dlsr.Status
+	return true
+}
+
+// setPoints replaces the model's points with points (from
+// Session.PointStatus()). It returns true if that changed the model.
+func (o *statusGridModel) setPoints(points map[int]eidc32proxy.Point) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	changed := len(points) != len(o.points)
+	for id, p := range points {
+		if old, ok := o.points[id]; !ok || old.NewStatus != p.NewStatus {
+			changed = true
+		}
+	}
+	o.points = points
+	return changed
+}
+
+// rows returns the model as label/status pairs: the door lock status first,
+// then each known point in order.
+func (o *statusGridModel) rows() [][2]string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lockStatus := o.lockStatus
+	if lockStatus == "" {
+		lockStatus = unknownStatus
+	}
+	result := [][2]string{{lockRowLabel, lockStatus}}
+
+	ids := make([]int, 0, len(o.points))
+	for id := range o.points {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		status := o.points[id].NewStatus
+		result = append(result, [2]string{
+			fmt.Sprintf(pointRowLabel, id),
+			fmt.Sprintf("%d (0x%02x)", status, status),
+		})
+	}
+	return result
+}
+
+// statusGrid is a table showing the door and point status of the current
+// session's eIDC32. It updates live as status messages pass through the
+// session.
+type statusGrid struct {
+	table *tview.Table
+	model *statusGridModel
+}
+
+func newStatusGrid() statusGrid {
+	return statusGrid{
+		table: tview.NewTable().SetBorders(false).SetFixed(1, 0),
+		model: newStatusGridModel(),
+	}
+}
+
+// render redraws the table from the model.
+func (o statusGrid) render(app *tview.Application) {
+	rows := o.model.rows()
+	app.QueueUpdateDraw(func() {
+		o.table.Clear()
+		o.table.SetCell(0, 0, tview.NewTableCell("").SetTextColor(tcell.ColorYellow))
+		o.table.SetCell(0, 1, tview.NewTableCell("Status").SetTextColor(tcell.ColorYellow))
+		for i, row := range rows {
+			o.table.SetCellSimple(i+1, 0, row[0])
+			o.table.SetCellSimple(i+1, 1, row[1])
+		}
+	})
+}
+
+// runForSession resets the grid, seeds it with the session's point status
+// and keeps it updated from the session's pager. The returned function stops
+// the updates.
+func (o *statusGrid) runForSession(app *tview.Application, s *eidc32proxy.Session) func() {
+	o.model = newStatusGridModel()
+	model := o.model
+	model.setPoints(s.PointStatus())
+	grid := *o
+	grid.render(app)
+
+	msgs, unsub := s.Pager.Subscribe(eidc32proxy.SubInfo{
+		MsgTypes: []eidc32proxy.MsgType{
+			eidc32proxy.MsgTypeDoor0x2fLockStatusResponse,
+			eidc32proxy.MsgTypePointStatusRequest,
+		},
+	})
+	go func() {
+		for msg := range msgs {
+			var changed bool
+			if msg.GetType() == eidc32proxy.MsgTypePointStatusRequest {
+				// the session has already applied it to PointStatus()
+				changed = model.setPoints(s.PointStatus())
+			} else {
+				changed = model.apply(msg)
+			}
+			if changed {
+				grid.render(app)
+			}
+		}
+	}()
+	return unsub
+}
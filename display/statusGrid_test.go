@@ -0,0 +1,87 @@
+package display
+
+import (
+	"fmt"
+	"github.com/chrismarget/eidc32proxy"
+	"testing"
+)
+
+func lockStatusResponse(t *testing.T, status string) eidc32proxy.Message {
+	payload := fmt.Sprintf(`{"result":true, "cmd":"DOOR/LOCKSTATUS", "body":{"status":"%s"}}`, status)
+	msg, err := eidc32proxy.ReadMsg([]byte(fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+		"Server: eIDC32 WebServer\r\n"+
+		"Content-type: application/json\r\n"+
+		"Content-Length:  %d\r\n"+
+		"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload)), eidc32proxy.Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+func pointStatusRequest(t *testing.T, pointID int, oldStatus int, newStatus int) eidc32proxy.Message {
+	payload := fmt.Sprintf(`{"time":"2019-11-01T18:50:51-05:00", "points":[{"pointId":%d,"oldStatus":%d,"newStatus":%d}]}`,
+		pointID, oldStatus, newStatus)
+	msg, err := eidc32proxy.ReadMsg([]byte(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+		"Host: 192.168.6.10\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", eidc32proxy.PointStatusRequestURI, len(payload), payload)), eidc32proxy.Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+func TestStatusGridModel(t *testing.T) {
+	model := newStatusGridModel()
+
+	expectRows := func(expected [][2]string) {
+		rows := model.rows()
+		if len(rows) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, rows)
+		}
+		for i := range expected {
+			if rows[i] != expected[i] {
+				t.Fatalf("expected %v, got %v", expected, rows)
+			}
+		}
+	}
+
+	expectRows([][2]string{{"Door", "?"}})
+
+	if !model.apply(lockStatusResponse(t, "Unlocked")) {
+		t.Fatal("lock status change not applied")
+	}
+	if !model.setPoints(map[int]eidc32proxy.Point{38: {PointID: 38, NewStatus: 129}}) {
+		t.Fatal("point status change not applied")
+	}
+	if !model.setPoints(map[int]eidc32proxy.Point{38: {PointID: 38, NewStatus: 129}, 7: {PointID: 7, NewStatus: 1}}) {
+		t.Fatal("point status change not applied")
+	}
+	expectRows([][2]string{
+		{"Door", "Unlocked"},
+		{"Point 7", "1 (0x01)"},
+		{"Point 38", "129 (0x81)"},
+	})
+
+	// repeats aren't changes
+	if model.apply(lockStatusResponse(t, "Unlocked")) {
+		t.Fatal("repeated lock status reported as a change")
+	}
+	if model.setPoints(map[int]eidc32proxy.Point{38: {PointID: 38, NewStatus: 129}, 7: {PointID: 7, NewStatus: 1}}) {
+		t.Fatal("repeated point status reported as a change")
+	}
+
+	// point status requests are the session's business, not the model's
+	if model.apply(pointStatusRequest(t, 38, 129, 0)) {
+		t.Fatal("point status request applied to the model")
+	}
+
+	model.apply(lockStatusResponse(t, "Locked"))
+	model.setPoints(map[int]eidc32proxy.Point{38: {PointID: 38, OldStatus: 129, NewStatus: 0}, 7: {PointID: 7, NewStatus: 1}})
+	expectRows([][2]string{
+		{"Door", "Locked"},
+		{"Point 7", "1 (0x01)"},
+		{"Point 38", "0 (0x00)"},
+	})
+}
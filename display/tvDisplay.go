@@ -7,7 +7,6 @@ import (
 	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -19,6 +18,7 @@ const (
 	noCxnTitle1                  = "Waiting for first eIDC32 session..."
 	titleXofYString              = "Connection %d/%d"
 	eidcShortInfoString          = "S/N %s @ %s -> %s"
+	noSessionInfo                = "<no session>"
 	upString                     = "[green]Up %s[white]"
 	downString                   = "[red]Down %s (Up %s)[white]"
 	next                nextType = true
@@ -32,6 +32,11 @@ var (
 
 const (
 	liDetails     string = "Connection"
+	liStatus      string = "Status"
+	liPoints      string = "Points"
+	liMessages    string = "Messages"
+	liFilter      string = "Msg Filter"
+	liMsgType     string = "Msg Type"
 	liCredentials string = "Credentials"
 	liInject      string = "Inject"
 	liKill        string = "Kill Session"
@@ -45,11 +50,16 @@ const (
 // └──────────────────────────────────────────────────────────────────────────────────────┘
 //  (invisible box)  ┌────────────────────────────────────────────────────────────────────┐
 // (d) Connection    │                                                                    │
+// (s) Status        │                                                                    │
+// (p) Points        │                                                                    │
+// (m) Messages      │                                                                    │
+// (f) Msg Filter    │                                                                    │
+// (t) Msg Type      │                                                                    │
 // (c) Credentials   │                                                                    │
 // (i) Inject        │                                                                    │
 // (k) Kill Session  │                                                                    │
+// (a) About         │                                                                    │
 // (q) Quit          │                                                                    │
-//                   │                                                                    │
 //                   │             this whole pane is RightFlex                           │
 //                   │                                                                    │
 //       ^           │                                                                    │
@@ -129,23 +139,16 @@ type eidcShortInfo struct {
 
 // render displays the eidc serial number and brief connection info
 func (o eidcShortInfo) render(app *tview.Application, sess *eidc32proxy.Session) {
-	snString := sess.LoginInfo.ConnectedReq.SerialNumber
-	snInt, err := strconv.ParseInt(snString, 0, 64)
-	if err != nil {
-		snString = "<unknown>"
-	} else {
-		snString = strconv.Itoa(int(snInt))
+	if sess == nil {
+		updateText(app, o.tv, noSessionInfo)
+		return
 	}
-	eIDCIP := sess.LoginInfo.ConnectedReq.IPAddress
-	obervedIP := strings.Split(sess.Mitm.ClientSide.Client, ":")[0]
-	var printableIPstring string
-	if eIDCIP == obervedIP {
-		printableIPstring = eIDCIP
-	} else {
-		printableIPstring = fmt.Sprintf("%s (%s)", eIDCIP, obervedIP)
+	snString := "<unknown>"
+	snInt, err := sess.SerialInt()
+	if err == nil {
+		snString = strconv.FormatInt(snInt, 10)
 	}
-	destination := sess.LoginInfo.Host
-	result := fmt.Sprintf(eidcShortInfoString, snString, printableIPstring, destination)
+	result := fmt.Sprintf(eidcShortInfoString, snString, sess.ClientIP(), sess.Destination())
 	updateText(app, o.tv, result)
 }
 
@@ -171,10 +174,19 @@ type TVDisplay struct {
 	eidcShortInfo     eidcShortInfo
 	list              *tview.List
 	rightFlex         rightFlex
+	statusGrid        statusGrid
+	pointsPane        pointsPane
+	messageLog        *messageLog
+	msgTypeMenu       *tview.List
+	about             about
+	debug             bool
 	err               chan error
 	newSess           chan int
 	quitNewSess       func()
 	clearDuration     func()
+	clearStatusGrid   func()
+	clearPointsPane   func()
+	clearMessageLog   func()
 }
 
 func (o *TVDisplay) createTitleLine1() *tview.Flex {
@@ -213,10 +225,21 @@ func (o *TVDisplay) createTitleFlex() *tview.Flex {
 func (o *TVDisplay) createListBox() *tview.List {
 	o.list = tview.NewList().ShowSecondaryText(false)
 	o.list.AddItem(liDetails, "", 'd', nil)
+	o.list.AddItem(liStatus, "", 's', func() { o.rightFlex.setContents(o.statusGrid.table, false) })
+	o.list.AddItem(liPoints, "", 'p', func() { o.rightFlex.setContents(o.pointsPane.table, false) })
+	o.list.AddItem(liMessages, "", 'm', func() { o.rightFlex.setContents(o.messageLog.tv, false) })
+	o.list.AddItem(liFilter, "", 'f', func() {
+		o.messageLog.cycleFilter()
+		o.rightFlex.setContents(o.messageLog.tv, false)
+	})
+	o.list.AddItem(liMsgType, "", 't', func() {
+		o.rightFlex.setContents(o.msgTypeMenu, true)
+		o.app.SetFocus(o.msgTypeMenu)
+	})
 	o.list.AddItem(liCredentials, "", 'c', nil)
 	o.list.AddItem(liInject, "", 'i', nil)
 	o.list.AddItem(liKill, "", 'k', nil)
-	o.list.AddItem(liAbout, "", 'a', nil)
+	o.list.AddItem(liAbout, "", 'a', func() { o.rightFlex.setContents(o.about.tv, false) })
 	o.list.AddItem(liQuit, "", 'q', func() { o.Stop() })
 	return o.list
 }
@@ -250,17 +273,37 @@ func createApplication(mainFlex *tview.Flex) *tview.Application {
 	return tview.NewApplication().SetRoot(mainFlex, true)
 }
 
-// NewTVDisplay returns an implementation of Display using rivo/tview
-func NewTVDisplay(sessChan chan *eidc32proxy.Session) *TVDisplay {
+// NewTVDisplay returns an implementation of Display using rivo/tview. version
+// is shown in the About pane.
+func NewTVDisplay(sessChan chan *eidc32proxy.Session, version string) *TVDisplay {
 	var d TVDisplay
+	d.about = newAbout(version)
 	d.aggregator = aggregator.NewAggregator(sessChan)
 	d.app = createApplication(d.createMainFlex())
 	d.err = make(chan error)
 	d.newSess, d.quitNewSess = d.aggregator.SubscribeToSessionAlerts()
 	d.clearDuration = func() {}
+	d.statusGrid = newStatusGrid()
+	d.clearStatusGrid = func() {}
+	d.pointsPane = newPointsPane()
+	d.clearPointsPane = func() {}
+	d.messageLog = newMessageLog()
+	d.clearMessageLog = func() {}
+	d.msgTypeMenu = newMsgTypeMenu(func(t eidc32proxy.MsgType) {
+		d.messageLog.setMsgType(t)
+		d.messageLog.refresh() // List callbacks run on the event loop
+		d.rightFlex.setContents(d.messageLog.tv, false)
+		d.app.SetFocus(d.list)
+	})
 	return &d
 }
 
+// SetDebug enables display debugging hacks, like the up arrow adding an
+// empty session to the aggregator.
+func (o *TVDisplay) SetDebug(enable bool) {
+	o.debug = enable
+}
+
 // ErrChan returns the TVDisplay's error channel
 func (o TVDisplay) ErrChan() chan error {
 	return o.err
@@ -274,15 +317,23 @@ func (o TVDisplay) Stop() {
 func (o *TVDisplay) x(event *tcell.EventKey) *tcell.EventKey {
 	switch event.Key() {
 	case tcell.KeyRight:
-		o.currentConnection = getNext(o.currentConnection, o.aggregator.Size(), next)
+		o.currentConnection = getNextValid(o.currentConnection, o.aggregator.Size(), next, o.haveSession)
 	case tcell.KeyLeft:
-		o.currentConnection = getNext(o.currentConnection, o.aggregator.Size(), previous)
+		o.currentConnection = getNextValid(o.currentConnection, o.aggregator.Size(), previous, o.haveSession)
 	case tcell.KeyUp:
-		o.aggregator.AddGarbage()
+		if o.debug {
+			o.aggregator.AddGarbage()
+		}
 	}
 	return event
 }
 
+// haveSession returns false for aggregator entries with no session behind
+// them (see aggregator.AddGarbage()).
+func (o TVDisplay) haveSession(i int) bool {
+	return o.aggregator.GetSession(i) != nil
+}
+
 func (o TVDisplay) waitForFirstConn() {
 	o.titleXofY.render(o.app, o.currentConnection, o.aggregator.Size())
 	for o.aggregator.Size() < 1 {
@@ -299,34 +350,10 @@ func (o TVDisplay) updateTitle(i int) {
 func (o TVDisplay) runClock() {
 	for {
 		time.Sleep(250 * time.Millisecond)
-		//updateText(o.app, o.rightFlex.flex, currentTimeString(false))
 		o.titleXofY.render(o.app, o.currentConnection, o.aggregator.Size())
 	}
 }
 
-func updateClock(app *tview.Application, clock *tview.TextView, twelvehour bool) {
-	for {
-		time.Sleep(100 * time.Millisecond)
-		updateText(app, clock, currentTimeString(twelvehour))
-	}
-}
-
-func messWithLargePane(app *tview.Application, rf rightFlex) {
-	clock1 := tview.NewTextView().SetTextAlign(tview.AlignLeft)
-	go updateClock(app, clock1, false)
-	clock2 := tview.NewTextView().SetTextAlign(tview.AlignRight)
-	go updateClock(app, clock2, true)
-
-	go func() {
-		for {
-			rf.setContents(clock1, true)
-			time.Sleep(3 * time.Second)
-			rf.setContents(clock2, true)
-			time.Sleep(3 * time.Second)
-		}
-	}()
-}
-
 // Run starts the TVDisplay's rivo/tview appliation
 func (o TVDisplay) Run() {
 	go func() {
@@ -341,7 +368,7 @@ func (o TVDisplay) Run() {
 	o.aggregator.GetSession(o.currentConnection).BeginRelaying()
 	o.switchTo(o.currentConnection)
 
-	go messWithLargePane(o.app, o.rightFlex)
+	o.rightFlex.setContents(o.messageLog.tv, false)
 
 	hbSub := eidc32proxy.SubInfo{
 		MsgTypes: []eidc32proxy.MsgType{eidc32proxy.MsgTypeHeartbeatResponse},
@@ -349,18 +376,21 @@ func (o TVDisplay) Run() {
 
 	// loop forever
 	for {
-		hb, stopHb := o.aggregator.GetSession(o.currentConnection).Pager.Subscribe(hbSub)
+		var hb <-chan eidc32proxy.Message
+		stopHb := func() {}
+		if sess := o.aggregator.GetSession(o.currentConnection); sess != nil {
+			hb, stopHb = sess.Pager.Subscribe(hbSub)
+		}
 		select {
 		case new := <-o.newSess: // New session has connected
 			o.titleXofY.render(o.app, o.currentConnection, o.aggregator.Size()) // fix title
 			o.aggregator.GetSession(new).BeginRelaying()                        // start new session
-		case <-hb: // heartbeat message
+		case <-hb: // heartbeat message (never, without a session)
 			o.heartBeat.beat(o.app, o.aggregator.GetSession(o.currentConnection).HeartBeats())
 		}
 		go stopHb()
 	}
 
-	//messWithLargePane(o.app, o.rightFlex)
 	//o.app.SetFocus(o.list)
 }
 
@@ -370,14 +400,6 @@ func updateText(app *tview.Application, tv *tview.TextView, s string) {
 	})
 }
 
-func currentTimeString(twelveHour bool) string {
-	t := time.Now()
-	if twelveHour == true {
-		return fmt.Sprintf(t.Format(" Current time is \n 3:04:05 "))
-	}
-	return fmt.Sprintf(t.Format(" Current time is \n 15:04:05 "))
-}
-
 // next facilitates incrementing "x of n" displays. it deals strictly with
 // zero-indexed things. Input and output value match slice indexing. Need to
 // add one for pretty user output (to get "1 of 2" instead of "0 of 2")
@@ -411,12 +433,41 @@ func getNext(current int, outOf int, n nextType) int {
 	return (current + 1) % outOf
 }
 
+// getNextValid is getNext, but skips over indexes for which valid() returns
+// false. If none of the others are valid, it returns current.
+func getNextValid(current int, outOf int, n nextType, valid func(int) bool) int {
+	i := current
+	for tries := 0; tries < outOf; tries++ {
+		i = getNext(i, outOf, n)
+		if valid(i) {
+			return i
+		}
+	}
+	return current
+}
+
 type paneMgr struct {
 	pane *tview.Flex
 }
 
-func (o TVDisplay) switchTo(i int) {
+func (o *TVDisplay) switchTo(i int) {
 	o.updateTitle(i)
 	o.clearDuration()
-	o.clearDuration = o.duration.runForSession(o.app, o.aggregator.GetSession(i))
+	o.clearStatusGrid()
+	o.clearPointsPane()
+	o.clearMessageLog()
+
+	sess := o.aggregator.GetSession(i)
+	if sess == nil {
+		// nothing to show
+		o.clearDuration = func() {}
+		o.clearStatusGrid = func() {}
+		o.clearPointsPane = func() {}
+		o.clearMessageLog = func() {}
+		return
+	}
+	o.clearDuration = o.duration.runForSession(o.app, sess)
+	o.clearStatusGrid = o.statusGrid.runForSession(o.app, sess)
+	o.clearPointsPane = o.pointsPane.runForSession(o.app, sess)
+	o.clearMessageLog = o.messageLog.runForSession(o.app, sess)
 }
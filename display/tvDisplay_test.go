@@ -1,6 +1,8 @@
 package display
 
 import (
+	"github.com/chrismarget/eidc32proxy"
+	"github.com/gdamore/tcell"
 	"github.com/rivo/tview"
 	"testing"
 	"time"
@@ -126,3 +128,70 @@ func TestHb(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGetNextValid(t *testing.T) {
+	// index 1 and 2 are nil sessions
+	valid := func(i int) bool { return i != 1 && i != 2 }
+
+	testData := []struct {
+		current  int
+		outOf    int
+		n        nextType
+		expected int
+	}{
+		{0, 4, next, 3},
+		{3, 4, next, 0},
+		{0, 4, previous, 3},
+		{3, 4, previous, 0},
+		{-1, 4, next, 0},
+		{0, 1, next, 0},
+		{0, 0, next, 0},
+	}
+	for _, td := range testData {
+		result := getNextValid(td.current, td.outOf, td.n, valid)
+		if result != td.expected {
+			t.Fatalf("getNextValid(%d, %d, %t): expected %d, got %d",
+				td.current, td.outOf, td.n, td.expected, result)
+		}
+	}
+
+	// nothing else valid: stay put
+	result := getNextValid(0, 3, next, func(i int) bool { return i == 0 })
+	if result != 0 {
+		t.Fatalf("expected 0, got %d", result)
+	}
+	result = getNextValid(1, 3, next, func(int) bool { return false })
+	if result != 1 {
+		t.Fatalf("expected 1, got %d", result)
+	}
+}
+
+// responsive fails the test if the application's event loop doesn't get
+// around to running an update within a second.
+func responsive(t *testing.T, app *tview.Application) {
+	done := make(chan struct{})
+	go app.QueueUpdate(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the application's event loop is stuck")
+	}
+}
+
+func TestTVDisplay_FilterKey(t *testing.T) {
+	d := NewTVDisplay(make(chan *eidc32proxy.Session), "1.2.3")
+	d.app.SetScreen(simulationScreen(t))
+	go d.app.Run()
+	defer d.app.Stop()
+
+	// the key press is handled on the event loop, like a real one
+	d.app.QueueEvent(tcell.NewEventKey(tcell.KeyRune, 'f', tcell.ModNone))
+	responsive(t, d.app)
+
+	d.messageLog.mu.Lock()
+	filter := d.messageLog.filter
+	d.messageLog.mu.Unlock()
+	if filter != logFilterAll.next() {
+		t.Fatalf("expected filter %s after 'f', got %s", logFilterAll.next(), filter)
+	}
+}
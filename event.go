@@ -94,11 +94,21 @@ const (
 
 type EventType uint16
 
+// IsBuffered returns true if the event was buffered by the eIDC32 (rather than
+// reported live).
+func (o EventType) IsBuffered() bool {
+	return o&BufferedEventFlag == BufferedEventFlag
+}
+
+// Base returns the event type with the buffered flag stripped, for comparison
+// with the Event* constants.
+func (o EventType) Base() EventType {
+	return o & ^BufferedEventFlag
+}
+
 func (o EventType) String() string {
-	// strip the buffered flag
-	evtType := o & ^BufferedEventFlag
 	var result string
-	switch evtType {
+	switch o.Base() {
 	case 1:
 		result = "DeviceStartup"
 	case 2:
@@ -262,9 +272,74 @@ func (o EventType) String() string {
 	default:
 		result = "Unknown_Event_Type"
 	}
-	buffered := o&BufferedEventFlag == BufferedEventFlag
-	if buffered {
+	if o.IsBuffered() {
 		result = fmt.Sprintf("(%s)", result)
 	}
 	return result
 }
+
+// EventCategory is a coarse grouping of EventTypes, see EventType.Category().
+type EventCategory uint8
+
+const (
+	EventCategoryUnknown EventCategory = iota
+	EventCategoryAccess
+	EventCategoryAlarm
+	EventCategoryPower
+	EventCategoryTamper
+	EventCategoryConnection
+	EventCategorySystem
+	EventCategoryElevator
+)
+
+func (o EventCategory) String() string {
+	switch o {
+	case EventCategoryAccess:
+		return "Access"
+	case EventCategoryAlarm:
+		return "Alarm"
+	case EventCategoryPower:
+		return "Power"
+	case EventCategoryTamper:
+		return "Tamper"
+	case EventCategoryConnection:
+		return "Connection"
+	case EventCategorySystem:
+		return "System"
+	case EventCategoryElevator:
+		return "Elevator"
+	}
+	return "Unknown"
+}
+
+// Category returns the group an event belongs to. The eIDC32 numbers its
+// events in blocks by subject, so this is mostly a matter of ranges. Buffered
+// events are categorized like their live counterparts.
+func (o EventType) Category() EventCategory {
+	evtType := o.Base()
+	switch {
+	case evtType == EventDeviceCommunicationEstablish || evtType == EventDeviceCommunicationLost:
+		return EventCategoryConnection
+	case evtType >= EventPowerNormal && evtType <= EventBatteryLost:
+		return EventCategoryPower
+	case evtType >= EventTamperAbnormal && evtType <= EventSupervisionNormal:
+		return EventCategoryTamper
+	case evtType >= EventDeviceStartup && evtType <= EventOutputUnOverridden:
+		return EventCategorySystem // startup, reflash, download, bypass/override
+	case evtType >= EventUnrecognizedCardFormat && evtType <= EventAccessEvent_DoorOpenTooLong:
+		return EventCategoryAccess
+	case evtType >= EventAlarm_InAlarm && evtType <= EventArming_Disarmed:
+		return EventCategoryAlarm
+	case evtType >= EventServiceActivated && evtType <= EventServiceDeactivated:
+		return EventCategorySystem
+	case evtType >= EventElevatorAccessGranted && evtType <= EventElevatorAccessRestricted:
+		return EventCategoryElevator
+	case evtType >= EventLOW_VOLTAGE && evtType <= EventDC2_POWER_RESTORED:
+		return EventCategoryPower
+	case evtType >= EventReboot && evtType <= EventReflashFirmware:
+		return EventCategorySystem
+	case evtType >= EventCONNECTION_START && evtType <= EventCONNECTION_NO_DNS_SERVER:
+		return EventCategoryConnection
+	}
+	return EventCategoryUnknown
+}
@@ -18,3 +18,47 @@ func TestString(t *testing.T) {
 		}
 	}
 }
+
+func TestEventType_Category(t *testing.T) {
+	testData := map[EventType]EventCategory{
+		EventAccessGranted:                     EventCategoryAccess,
+		EventAuthentication_UnknownCard:        EventCategoryAccess,
+		EventAccessGranted | BufferedEventFlag: EventCategoryAccess,
+		EventAlarm_InAlarm:                     EventCategoryAlarm,
+		EventArming_Disarmed:                   EventCategoryAlarm,
+		EventPowerLost:                         EventCategoryPower,
+		EventDC1_POWER_TROUBLE:                 EventCategoryPower,
+		EventTamperAbnormal:                    EventCategoryTamper,
+		EventDeviceCommunicationLost:           EventCategoryConnection,
+		EventCONNECTION_FAILED:                 EventCategoryConnection,
+		EventDeviceStartup:                     EventCategorySystem,
+		EventReboot:                            EventCategorySystem,
+		EventElevatorAccessGranted:             EventCategoryElevator,
+		EventElevatorAccessRestricted:          EventCategoryElevator,
+		0:                                      EventCategoryUnknown,
+		200:                                    EventCategoryUnknown,
+	}
+	for et, expected := range testData {
+		if result := et.Category(); result != expected {
+			t.Fatalf("event %d (%s): expected %s, got %s", et, et, expected, result)
+		}
+	}
+}
+
+func TestEventType_IsBufferedBase(t *testing.T) {
+	for _, base := range []EventType{EventDeviceStartup, EventAccessGranted, EventCONNECTION_NO_DNS_SERVER} {
+		buffered := base | BufferedEventFlag
+		if base.IsBuffered() {
+			t.Fatalf("live event %s reported as buffered", base)
+		}
+		if !buffered.IsBuffered() {
+			t.Fatalf("buffered event %s reported as live", buffered)
+		}
+		if base.Base() != base {
+			t.Fatalf("expected base of live %d to be %d, got %d", base, base, base.Base())
+		}
+		if buffered.Base() != base {
+			t.Fatalf("expected base of buffered %d to be %d, got %d", buffered, base, buffered.Base())
+		}
+	}
+}
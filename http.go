@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 )
 
@@ -23,17 +24,70 @@ const (
 
 // Various HTTP headers by name only. Does not include colon or space chars.
 const (
-	hostHeader             = "host:"
-	crlf                   = "\r\n"
-	crlfcrlf               = "\r\n\r\n"
-	contentLengthWithColon = "content-length:"
-	UAeIDCListener         = "eIDCListener"
-	serverHeaderName       = "Server"
-	cacheControlHeaderName = "Cache-Control"
-	contentTypeHeaderName  = "Content-Type"
-	serverKeyHeaderName    = "ServerKey"
+	hostHeader                = "host:"
+	crlf                      = "\r\n"
+	crlfcrlf                  = "\r\n\r\n"
+	contentLengthWithColon    = "content-length:"
+	transferEncodingWithColon = "transfer-encoding:"
+	UAeIDCListener            = "eIDCListener"
+	serverHeaderName          = "Server"
+	cacheControlHeaderName    = "Cache-Control"
+	contentTypeHeaderName     = "Content-Type"
+	serverKeyHeaderName       = "ServerKey"
 )
 
+// userAgents holds the User-Agent sent by IntelliM's eIDCListener and the
+// Server header sent by the eIDC32's web server, see SetListenerUA() and
+// SetWebServerUA().
+var userAgents = struct {
+	mu        sync.Mutex
+	listener  string
+	webServer string
+}{
+	listener:  UAeIDCListener,
+	webServer: UAeIDCWebServer,
+}
+
+// SetListenerUA sets the User-Agent header used by southbound (IntelliM to
+// eIDC32) requests built by this package, and recognized by the
+// impersonation logic which adds eIDCListener's stray newline to empty GET
+// requests. Use it to match a particular IntelliM release. An empty string
+// restores the default, UAeIDCListener.
+func SetListenerUA(ua string) {
+	if ua == "" {
+		ua = UAeIDCListener
+	}
+	userAgents.mu.Lock()
+	userAgents.listener = ua
+	userAgents.mu.Unlock()
+}
+
+// SetWebServerUA sets the Server header of responses built by
+// EIDCHTTPResponse(). Use it to match a particular eIDC32 firmware. An empty
+// string restores the default, UAeIDCWebServer.
+func SetWebServerUA(ua string) {
+	if ua == "" {
+		ua = UAeIDCWebServer
+	}
+	userAgents.mu.Lock()
+	userAgents.webServer = ua
+	userAgents.mu.Unlock()
+}
+
+// listenerUA returns the User-Agent set by SetListenerUA().
+func listenerUA() string {
+	userAgents.mu.Lock()
+	defer userAgents.mu.Unlock()
+	return userAgents.listener
+}
+
+// webServerUA returns the Server header set by SetWebServerUA().
+func webServerUA() string {
+	userAgents.mu.Lock()
+	defer userAgents.mu.Unlock()
+	return userAgents.webServer
+}
+
 var (
 	crlfBytes     = []byte(crlf)
 	crlfCRLFBytes = []byte(crlfcrlf)
@@ -48,6 +102,8 @@ var (
 		[]byte("CONNECT "),
 	}
 	httpCLHeader = []byte(contentLengthWithColon)
+	httpTEHeader = []byte(transferEncodingWithColon)
+	chunked      = []byte("chunked")
 )
 
 // isRequest returns true if the passed reader looks like
@@ -72,12 +128,29 @@ func isResponse(b []byte) bool {
 	return re.Match(b[:i])
 }
 
+// ErrPartialMessage is returned by SplitHttpMsg (and so by the scanner's
+// Err()) when the stream ends part way through a message.
+type ErrPartialMessage struct {
+	Len int // bytes of the incomplete message received before EOF
+}
+
+func (o *ErrPartialMessage) Error() string {
+	return fmt.Sprintf("stream ended mid-message after %d bytes", o.Len)
+}
+
 // SplitHttpMsg is a scanner split function. It causes the scanner parse out
 // individual http messages. A message ends at CRLF+CRLF unless a
 // "Content-Length:" header appears, in which case the message ends
-// Content-Length bytes after the CRLF+CRLF.
+// Content-Length bytes after the CRLF+CRLF. If the message has a
+// "Transfer-Encoding: chunked" header, the message ends after the terminating
+// zero-length chunk (and trailer, if any). If the stream ends part way
+// through a message, the error is an *ErrPartialMessage. Stray whitespace at
+// the end of the stream is discarded.
 func SplitHttpMsg(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	//todo need to do something with atEOF
+	if atEOF && len(bytes.TrimSpace(data)) == 0 {
+		return len(data), nil, nil
+	}
+
 	var headerSize int
 	var contentLength int
 	// Look for CRLF+CRLF
@@ -87,14 +160,26 @@ func SplitHttpMsg(data []byte, atEOF bool) (advance int, token []byte, err error
 		// we already know the newline characters are there.
 		headerSize += len(crlfCRLFBytes)
 
-		contentLength, err = getContentLength(data[:headerSize])
-		if err != nil {
-			return 0, nil, err
-		}
+		if isChunked(data[:headerSize]) {
+			var complete bool
+			contentLength, complete, err = getChunkedLength(data[headerSize:])
+			if err != nil {
+				return 0, nil, err
+			}
+			// ask for more data if we don't have the last chunk yet
+			if !complete {
+				return partial(data, atEOF)
+			}
+		} else {
+			contentLength, err = getContentLength(data[:headerSize])
+			if err != nil {
+				return 0, nil, err
+			}
 
-		// ask for more data if we don't have the whole body yet
-		if headerSize+contentLength > len(data) {
-			return 0, nil, nil
+			// ask for more data if we don't have the whole body yet
+			if headerSize+contentLength > len(data) {
+				return partial(data, atEOF)
+			}
 		}
 
 		// eIDCListener bug: It sends a bogus CRLF with GET messages. Check for
@@ -114,24 +199,144 @@ func SplitHttpMsg(data []byte, atEOF bool) (advance int, token []byte, err error
 	}
 
 	// crlfcrlf not available - ask for more data
+	return partial(data, atEOF)
+}
+
+// partial is SplitHttpMsg's result when data doesn't contain a whole
+// message: a request for more data, or an error if there's no more to come.
+func partial(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF {
+		return 0, nil, &ErrPartialMessage{Len: len(data)}
+	}
 	return 0, nil, nil
 }
 
 // getContentLength extracts the content-length value from an http header.
-// If not present in the header return value will be -1
+// If not present in the header return value will be -1. The eIDC32 and its
+// peers are sloppy about header formatting, so whitespace around the colon
+// (including tabs) and obs-fold continuation lines are tolerated. A
+// Content-Length header with no value is treated as absent.
 func getContentLength(in []byte) (int, error) {
 	contentLength := -1
-	var err error
+	for _, value := range headerValues(in, httpCLHeader) {
+		// Extract the Content Length
+		digits := value
+		for i, b := range value {
+			if b < '0' || b > '9' {
+				digits = value[:i]
+				break
+			}
+		}
+		if len(digits) == 0 {
+			if len(value) == 0 {
+				continue
+			}
+			return -1, fmt.Errorf("bad content-length value '%s'", value)
+		}
+		var err error
+		contentLength, err = strconv.Atoi(string(digits))
+		if err != nil {
+			return -1, err
+		}
+	}
+	return contentLength, nil
+}
+
+// isChunked returns true if the http header includes a "Transfer-Encoding:"
+// header specifying chunked encoding.
+func isChunked(in []byte) bool {
+	for _, value := range headerValues(in, httpTEHeader) {
+		if bytes.Contains(bytes.ToLower(value), chunked) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerValues returns the whitespace-trimmed values of every header line in
+// the http header whose name (lowercase, colon included) matches name.
+// Whitespace between the header name and the colon is ignored, and obs-fold
+// continuation lines (beginning with a space or tab) are joined to the line
+// they continue. The start line is never matched.
+func headerValues(in []byte, name []byte) [][]byte {
+	var lines [][]byte
 	scanner := bufio.NewScanner(bytes.NewReader(in))
-	for scanner.Scan() {
-		// Find the line beginning with "Content-Length:"
-		if bytes.HasPrefix(bytes.ToLower(scanner.Bytes()), httpCLHeader) {
-			// Extract the Content Length
-			re := regexp.MustCompile("[0-9]+")
-			contentLength, err = strconv.Atoi(string(re.Find(scanner.Bytes())))
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Bytes()
+		switch {
+		case first:
+			continue
+		case len(line) == 0:
+			// end of the header
+		case (line[0] == ' ' || line[0] == '\t') && len(lines) > 0:
+			// obs-fold: continuation of the previous line
+			last := len(lines) - 1
+			lines[last] = append(append(lines[last], ' '), bytes.TrimSpace(line)...)
+			continue
+		default:
+			lines = append(lines, append([]byte{}, line...))
+			continue
+		}
+		break
+	}
+
+	var result [][]byte
+	for _, line := range lines {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		lineName := append(bytes.ToLower(bytes.TrimSpace(line[:colon])), ':')
+		if bytes.Equal(lineName, name) {
+			result = append(result, bytes.TrimSpace(line[colon+1:]))
+		}
+	}
+	return result
+}
+
+// getChunkedLength walks the chunks of a chunked message body, returning the
+// length of the encoded body (chunk size lines, chunk data, terminating
+// zero-length chunk and trailer). complete is false if the body hasn't been
+// completely received yet.
+func getChunkedLength(in []byte) (length int, complete bool, err error) {
+	var pos int
+	for {
+		// find the end of the chunk size line
+		eol := bytes.Index(in[pos:], crlfBytes)
+		if eol < 0 {
+			return 0, false, nil
+		}
+		sizeField := in[pos : pos+eol]
+		if i := bytes.IndexByte(sizeField, ';'); i >= 0 {
+			sizeField = sizeField[:i] // discard chunk extensions
+		}
+		size, err := strconv.ParseInt(string(bytes.TrimSpace(sizeField)), 16, 32)
+		if err != nil || size < 0 {
+			return 0, false, fmt.Errorf("bad chunk size line '%s'", in[pos:pos+eol])
+		}
+		pos += eol + len(crlf)
+
+		if size == 0 {
+			// last chunk: the trailer (usually empty) ends with an empty line
+			if bytes.HasPrefix(in[pos:], crlfBytes) {
+				return pos + len(crlf), true, nil
+			}
+			if len(in[pos:]) < len(crlf) {
+				return 0, false, nil
+			}
+			end := bytes.Index(in[pos:], crlfCRLFBytes)
+			if end < 0 {
+				return 0, false, nil
+			}
+			return pos + end + len(crlfCRLFBytes), true, nil
+		}
+
+		// skip the chunk data and its trailing CRLF
+		pos += int(size) + len(crlf)
+		if pos > len(in) {
+			return 0, false, nil
 		}
 	}
-	return contentLength, err
 }
 
 // peekHttpHeader assumes input is an HTTP request/response, will have a
@@ -215,15 +420,22 @@ func peekLoginInfo(in *bufio.Reader) (*LoginInfo, error) {
 		return nil, err
 	}
 
+	return ParseLoginInfo(httpMsgBytes)
+}
+
+// ParseLoginInfo extracts LoginInfo data from the raw bytes of an eIDC32's
+// first message to a server (a POST to /eidc/connected), say from a packet
+// capture. It returns an error if raw isn't a controller login.
+func ParseLoginInfo(raw []byte) (*LoginInfo, error) {
 	// Ultimately we're looking to construct a LoginInfo. This info is sent as
 	// the first HTTP request in an eIDC32 session. It HAS TO BE a request.
-	if !isRequest(httpMsgBytes) {
+	if !isRequest(raw) {
 		return nil, fmt.Errorf("initial message not an HTTP request:'%s'",
-			string(httpMsgBytes))
+			string(raw))
 	}
 
 	// Parse the request
-	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(httpMsgBytes)))
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(raw)))
 	if err != nil {
 		return nil, err
 	}
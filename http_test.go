@@ -3,6 +3,7 @@ package eidc32proxy
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"strings"
 	"testing"
 )
@@ -99,18 +100,19 @@ func TestPeekHostHeader(t *testing.T) {
 	}
 }
 
-func TestPeekLoginInfo(t *testing.T) {
-	testData := "POST /eidc/connected HTTP/1.1\r\n" +
-		"Host: production-webhal-xxxxxxxxxxxxxxxx.elb.us-east-1.amazonaws.com:18800\r\n" +
-		"Content-Type: application/json\r\n" +
-		"Content-Length: 217\r\n" +
-		"ServerKey: xxxxxxxxxxxxxxxx\r\n\r\n" +
-		`{"serialNumber":"0x000000123456", "firmwareVersion":"3.4.20", ` +
-		`"ipAddress":"172.16.1.50", "macAddress":"00:14:E4:12:34:56", ` +
-		`"siteKey":"xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", ` +
-		`"configurationKey":"", "cardFormat":"short"}`
+// loginInfoTestData is an eIDC32's first message to a server.
+const loginInfoTestData = "POST /eidc/connected HTTP/1.1\r\n" +
+	"Host: production-webhal-xxxxxxxxxxxxxxxx.elb.us-east-1.amazonaws.com:18800\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 217\r\n" +
+	"ServerKey: xxxxxxxxxxxxxxxx\r\n\r\n" +
+	`{"serialNumber":"0x000000123456", "firmwareVersion":"3.4.20", ` +
+	`"ipAddress":"172.16.1.50", "macAddress":"00:14:E4:12:34:56", ` +
+	`"siteKey":"xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", ` +
+	`"configurationKey":"", "cardFormat":"short"}`
 
-	br := bufio.NewReader(strings.NewReader(testData))
+func TestPeekLoginInfo(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(loginInfoTestData))
 	li, err := peekLoginInfo(br)
 	if err != nil {
 		t.Fatal(err)
@@ -121,6 +123,37 @@ func TestPeekLoginInfo(t *testing.T) {
 	}
 }
 
+func TestParseLoginInfo(t *testing.T) {
+	li, err := ParseLoginInfo([]byte(loginInfoTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHost := "production-webhal-xxxxxxxxxxxxxxxx.elb.us-east-1.amazonaws.com:18800"
+	if li.Host != expectedHost {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s\n", expectedHost, li.Host)
+	}
+	if li.ServerKey != "xxxxxxxxxxxxxxxx" {
+		t.Fatalf("expected server key xxxxxxxxxxxxxxxx, got %s", li.ServerKey)
+	}
+	if li.ConnectedReq.SerialNumber != "0x000000123456" {
+		t.Fatalf("expected serial number 0x000000123456, got %s", li.ConnectedReq.SerialNumber)
+	}
+
+	// not a controller login
+	_, err = ParseLoginInfo([]byte("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"))
+	if err == nil {
+		t.Fatal("expected an error parsing a heartbeat as a login")
+	}
+
+	// not a request
+	_, err = ParseLoginInfo(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err == nil {
+		t.Fatal("expected an error parsing a response as a login")
+	}
+}
+
 func TestSplitHttpMsg(t *testing.T) {
 	testData := "" +
 		"foo1\r\n" +
@@ -148,3 +181,229 @@ func TestSplitHttpMsg(t *testing.T) {
 		t.Fatalf("expected %s, got %s", expected2, string(s.Bytes()))
 	}
 }
+
+func TestSplitHttpMsgChunked(t *testing.T) {
+	chunkedResponse := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"d\r\n" +
+		`{"serverKey":` + "\r\n" +
+		"13;ext=1\r\n" +
+		`"xxxxxxxxxxxxxxxx"}` + "\r\n" +
+		"0\r\n" +
+		"\r\n"
+	nextMsg := "GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+
+	// feed the scanner a few bytes at a time to exercise the
+	// incomplete chunk paths.
+	s := bufio.NewScanner(&slowReader{r: strings.NewReader(chunkedResponse + nextMsg), n: 5})
+	s.Split(SplitHttpMsg)
+
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	if string(s.Bytes()) != chunkedResponse {
+		t.Fatalf("expected:\n%q\ngot:\n%q", chunkedResponse, s.Bytes())
+	}
+
+	msg, err := ReadMsg(append([]byte{}, s.Bytes()...), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBody := `{"serverKey":"xxxxxxxxxxxxxxxx"}`
+	if string(msg.Body) != expectedBody {
+		t.Fatalf("expected body %s, got %s", expectedBody, msg.Body)
+	}
+	if msg.Type != MsgTypeConnectedResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeConnectedResponse, msg.Type)
+	}
+
+	// framing must not desync: the next message comes out intact
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	if string(s.Bytes()) != nextMsg {
+		t.Fatalf("expected:\n%q\ngot:\n%q", nextMsg, s.Bytes())
+	}
+}
+
+func TestGetChunkedLength(t *testing.T) {
+	testData := []struct {
+		body     string
+		length   int
+		complete bool
+	}{
+		{body: "0\r\n\r\n", length: 5, complete: true},
+		{body: "3\r\nabc\r\n0\r\n\r\nextra", length: 13, complete: true},
+		{body: "3\r\nabc\r\n0\r\nTrailer: x\r\n\r\n", length: 25, complete: true},
+		{body: "3\r\nabc\r\n", complete: false},
+		{body: "3\r\nab", complete: false},
+		{body: "3\r\nabc\r\n0\r\nTrailer: x\r\n", complete: false},
+		{body: "A", complete: false},
+	}
+
+	for _, td := range testData {
+		length, complete, err := getChunkedLength([]byte(td.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complete != td.complete || length != td.length {
+			t.Fatalf("%q: expected length %d complete %t, got %d %t",
+				td.body, td.length, td.complete, length, complete)
+		}
+	}
+
+	_, _, err := getChunkedLength([]byte("zz\r\n"))
+	if err == nil {
+		t.Fatal("expected an error for a bogus chunk size")
+	}
+}
+
+func TestGetContentLengthMalformed(t *testing.T) {
+	testData := []struct {
+		header string
+		length int
+	}{
+		{header: "POST / HTTP/1.1\r\nContent-Length: 123\r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\nContent-Length:\t123\r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\ncontent-length :123\r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\nCONTENT-LENGTH \t:  123  \r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\nContent-Length:\r\n 123\r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\nContent-Length:\r\n\t123\r\nHost: x\r\n\r\n", length: 123},
+		{header: "POST / HTTP/1.1\r\nHost: x\r\nContent-Length:\r\n\r\n", length: -1},
+		{header: "POST / HTTP/1.1\r\nX-Content-Length: 5\r\n\r\n", length: -1},
+		{header: "POST / HTTP/1.1\r\nX-Foo: bar\r\n Content-Length: 5\r\n\r\n", length: -1},
+	}
+
+	for _, td := range testData {
+		length, err := getContentLength([]byte(td.header))
+		if err != nil {
+			t.Fatalf("%q: %s", td.header, err)
+		}
+		if length != td.length {
+			t.Fatalf("%q: expected %d, got %d", td.header, td.length, length)
+		}
+	}
+
+	_, err := getContentLength([]byte("POST / HTTP/1.1\r\nContent-Length: abc\r\n\r\n"))
+	if err == nil {
+		t.Fatal("expected an error for a bogus content-length")
+	}
+}
+
+func TestSplitHttpMsgMalformedContentLength(t *testing.T) {
+	body := `{"foo":"bar"}`
+	heartbeat := "GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+
+	for _, clHeader := range []string{
+		"Content-Length:\t13\r\n",
+		"content-length :13\r\n",
+		"Content-Length:\r\n 13\r\n",
+		"Content-Length:\r\n\t13\r\n",
+	} {
+		msg := "POST /eidc/event HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			clHeader +
+			"Content-Type: application/json\r\n\r\n" +
+			body
+
+		s := bufio.NewScanner(&slowReader{r: strings.NewReader(msg + heartbeat), n: 7})
+		s.Split(SplitHttpMsg)
+		for _, expected := range []string{msg, heartbeat} {
+			if !s.Scan() {
+				t.Fatalf("%q: %v", clHeader, s.Err())
+			}
+			if string(s.Bytes()) != expected {
+				t.Fatalf("%q: expected:\n%q\ngot:\n%q", clHeader, expected, s.Bytes())
+			}
+		}
+	}
+}
+
+func TestSplitHttpMsgAtEOF(t *testing.T) {
+	heartbeat := "GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+	event := "POST /eidc/event HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 13\r\n\r\n" +
+		`{"foo":"bar"}`
+	chunked := "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n"
+
+	testData := []struct {
+		trailer string
+		partial int
+	}{
+		{trailer: "", partial: 0},
+		{trailer: "\r\n", partial: 0},
+		{trailer: heartbeat[:20], partial: 20},
+		{trailer: event[:len(event)-5], partial: len(event) - 5},
+		{trailer: chunked, partial: len(chunked)},
+	}
+
+	for _, td := range testData {
+		s := bufio.NewScanner(&slowReader{r: strings.NewReader(heartbeat + td.trailer), n: 7})
+		s.Split(SplitHttpMsg)
+		if !s.Scan() {
+			t.Fatalf("%q: %v", td.trailer, s.Err())
+		}
+		// stray whitespace may be swallowed along with the heartbeat
+		if strings.TrimSpace(string(s.Bytes())) != strings.TrimSpace(heartbeat) {
+			t.Fatalf("%q: expected:\n%q\ngot:\n%q", td.trailer, heartbeat, s.Bytes())
+		}
+		if s.Scan() {
+			t.Fatalf("%q: expected the scan to end, got %q", td.trailer, s.Bytes())
+		}
+
+		err := s.Err()
+		if td.partial == 0 {
+			if err != nil {
+				t.Fatalf("%q: expected a clean end of stream, got %v", td.trailer, err)
+			}
+			continue
+		}
+		pm, ok := err.(*ErrPartialMessage)
+		if !ok {
+			t.Fatalf("%q: expected ErrPartialMessage, got %v", td.trailer, err)
+		}
+		if pm.Len != td.partial {
+			t.Fatalf("%q: expected %d partial bytes, got %d", td.trailer, td.partial, pm.Len)
+		}
+	}
+}
+
+func TestIsChunkedFolded(t *testing.T) {
+	for _, header := range []string{
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n",
+		"HTTP/1.1 200 OK\r\ntransfer-encoding :\tChunked\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding:\r\n chunked\r\n\r\n",
+	} {
+		if !isChunked([]byte(header)) {
+			t.Fatalf("%q: expected chunked", header)
+		}
+	}
+	if isChunked([]byte("HTTP/1.1 200 OK\r\nX-Foo: chunked\r\n\r\n")) {
+		t.Fatal("unexpected chunked")
+	}
+}
+
+// slowReader returns at most n bytes per Read()
+type slowReader struct {
+	r io.Reader
+	n int
+}
+
+func (o *slowReader) Read(p []byte) (int, error) {
+	if len(p) > o.n {
+		p = p[:o.n]
+	}
+	return o.r.Read(p)
+}
@@ -304,7 +304,7 @@ func impersonateServerRequest(in []byte) ([]byte, error) {
 	switch {
 	case req.Method != http.MethodGet:
 		break
-	case req.UserAgent() != UAeIDCListener:
+	case req.UserAgent() != listenerUA():
 		break
 	case req.ContentLength != 0:
 		break
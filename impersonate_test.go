@@ -314,3 +314,62 @@ func TestDoServerQueryParamSort(t *testing.T) {
 		t.Fatalf("strings don't match:\n>%s<\n>%s<", expected6, result6)
 	}
 }
+
+func TestSetUAs(t *testing.T) {
+	defer SetListenerUA("")
+	defer SetWebServerUA("")
+
+	listener := "eIDCListener/4.2"
+	webServer := "eIDC32 WebServer 3.4.20"
+	SetListenerUA(listener)
+	SetWebServerUA(webServer)
+
+	// requests built by this package use the listener UA
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hb.Request.UserAgent() != listener {
+		t.Fatalf("expected User-Agent %s, got %s", listener, hb.Request.UserAgent())
+	}
+
+	// the stray newline is added to empty GETs from the configured listener
+	raw, err := hb.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := impersonateServerRequest(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(impostor, []byte("\r\n\r\n\r\n")) {
+		t.Fatalf("expected a stray newline after the header:\n%q", impostor)
+	}
+
+	// ...but not to those from the default listener
+	SetListenerUA("")
+	impostor, err = impersonateServerRequest(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasSuffix(impostor, []byte("\r\n\r\n\r\n")) {
+		t.Fatalf("unexpected stray newline after the header:\n%q", impostor)
+	}
+
+	// responses built by this package use the web server UA
+	resp, err := EIDCHTTPResponse(&EIDCHTTPResponseData{StatusCode: http.StatusOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(serverHeaderName) != webServer {
+		t.Fatalf("expected Server %s, got %s", webServer, resp.Header.Get(serverHeaderName))
+	}
+	SetWebServerUA("")
+	resp, err = EIDCHTTPResponse(&EIDCHTTPResponseData{StatusCode: http.StatusOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(serverHeaderName) != UAeIDCWebServer {
+		t.Fatalf("expected Server %s, got %s", UAeIDCWebServer, resp.Header.Get(serverHeaderName))
+	}
+}
@@ -12,11 +12,10 @@ import (
 )
 
 const (
-	user        = "username"
-	pass        = "password"
-	methodHttp  = "http"
-	host        = "192.168.6.40"
-	eidcListner = "eIDCListener"
+	user       = "username"
+	pass       = "password"
+	methodHttp = "http"
+	host       = "192.168.6.40"
 )
 
 func NewHeartbeatMsg(username string, password string) (*Message, error) {
@@ -35,7 +34,7 @@ func NewHeartbeatMsg(username string, password string) (*Message, error) {
 		return nil, err
 	}
 
-	req.Header.Set(ua, eidcListner)
+	req.Header.Set(ua, listenerUA())
 	msg := &Message{
 		direction: Southbound,
 		Request:   req,
@@ -240,17 +239,26 @@ func EIDCHTTPResponse(responseData *EIDCHTTPResponseData) (*http.Response, error
 		}
 	}
 
+	proto := responseData.Proto
+	if proto == "" {
+		proto = http10Proto
+	}
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		return nil, fmt.Errorf("invalid http response protocol version '%s'", proto)
+	}
+
 	resp := &http.Response{
 		Status:     http.StatusText(responseData.StatusCode),
 		StatusCode: responseData.StatusCode,
-		Proto:      http10Proto,
-		ProtoMajor: 1,
-		ProtoMinor: 0,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
 		Header:     make(http.Header),
 	}
 
 	resp.Header.Add(cacheControlHeaderName, noCache)
-	resp.Header.Add(serverHeaderName, UAeIDCWebServer)
+	resp.Header.Add(serverHeaderName, webServerUA())
 
 	if len(jsonBodyRaw) > 0 {
 		resp.ContentLength = int64(len(jsonBodyRaw))
@@ -274,6 +282,11 @@ type EIDCHTTPResponseData struct {
 	// StatusCode is the HTTP status code to include in the response.
 	StatusCode int
 
+	// Proto is the HTTP version to put in the response's status line,
+	// e.g. "HTTP/1.1". The default is "HTTP/1.0", which is what real
+	// eIDC32s send.
+	Proto string
+
 	// Headers are optional HTTP headers to apply to the response's
 	// headers. If this field contains a header already present in
 	// the new response's headers, the response's header value is
@@ -317,28 +330,81 @@ func ReplaceHTTPHeaderValue(headerBytes []byte, newValue []byte, rawHTTPMessage
 
 	eolInfex = headerStartIndex + headerLen + eolInfex
 
+	// cap headerBytes so that the appends below don't share (and clobber)
+	// its spare capacity
+	headerBytes = headerBytes[:headerLen:headerLen]
+
 	return bytes.Replace(rawHTTPMessage,
 		append(headerBytes, rawHTTPMessage[headerStartIndex+headerLen:eolInfex]...),
 		append(headerBytes, newValue...),
 		1), nil
 }
 
-func NewEventAckMsg(username string, password string, id int) (*Message, error) {
-	// todo use intellimUrl()
-	values := url.Values{}
-	values.Set(user, username)
-	values.Set(pass, password)
-	values.Set(serverRequestSequenceParam, "0")
+// HeaderOversizedLength is a MutateHeader mutation which replaces the value
+// with a number too big for a 64-bit integer.
+func HeaderOversizedLength(string) string {
+	return "18446744073709551616"
+}
 
-	eventAckUrl := url.URL{
-		Scheme:   methodHttp,
-		Host:     host,
-		Path:     eventAckRequestURI,
-		RawQuery: values.Encode(),
+// HeaderNegativeLength is a MutateHeader mutation which negates the value.
+func HeaderNegativeLength(value string) string {
+	return "-" + value
+}
+
+// HeaderMissingValue is a MutateHeader mutation which removes the value,
+// leaving the header name in place.
+func HeaderMissingValue(string) string {
+	return ""
+}
+
+// MutateHeader returns one variant of the raw HTTP message per mutation, each
+// with the value of the named header (e.g. "Content-Length") replaced by the
+// mutation's result. Mutations receive the header's current value. With no
+// mutations, HeaderOversizedLength, HeaderNegativeLength and
+// HeaderMissingValue are applied.
+//
+// Like ReplaceHTTPHeaderValue, this is for exploring how IntelliM (and our
+// own parser) cope with headers that Go's http library won't produce.
+func MutateHeader(raw []byte, header string, mutations ...func(string) string) ([][]byte, error) {
+	if len(mutations) == 0 {
+		mutations = []func(string) string{HeaderOversizedLength, HeaderNegativeLength, HeaderMissingValue}
+	}
+
+	headerBytes := []byte(header + ": ")
+	start := bytes.Index(raw, headerBytes)
+	if start < 0 {
+		return nil, fmt.Errorf("failed to find header '%s' in provided message", header)
+	}
+	start += len(headerBytes)
+	end := bytes.IndexAny(raw[start:], "\r\n")
+	if end < 0 {
+		return nil, fmt.Errorf("failed to find end of line after header '%s' value", header)
+	}
+	value := string(bytes.TrimSpace(raw[start : start+end]))
+
+	var result [][]byte
+	for _, mutate := range mutations {
+		variant, err := ReplaceHTTPHeaderValue(headerBytes, []byte(mutate(value)), raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, variant)
 	}
+	return result, nil
+}
+
+// NewEventAckMsg returns a southbound message acknowledging a single event.
+func NewEventAckMsg(username string, password string, id int) (*Message, error) {
+	return NewEventAckMsgMulti(username, password, []int{id})
+}
+
+// NewEventAckMsgMulti returns a southbound message acknowledging several
+// events at once, the way IntelliM batches its acks.
+func NewEventAckMsgMulti(username string, password string, ids []int) (*Message, error) {
+	eventAckUrl := intellimUrl(eventAckRequestURI, username, password)
 
 	ear := EventAckRequest{
-		EventIds: []int{id},
+		EventIds: ids,
 	}
 
 	body, err := json.Marshal(ear)
@@ -351,7 +417,7 @@ func NewEventAckMsg(username string, password string, id int) (*Message, error)
 		return nil, err
 	}
 
-	req.Header.Set(ua, eidcListner)
+	req.Header.Set(ua, listenerUA())
 
 	msg := &Message{
 		direction: Southbound,
@@ -379,7 +445,7 @@ func NewLockStatusMsg(username string, password string, status lockstatus) (*Mes
 		return nil, err
 	}
 
-	req.Header.Set(ua, eidcListner)
+	req.Header.Set(ua, listenerUA())
 
 	msg := &Message{
 		direction: Southbound,
@@ -391,6 +457,98 @@ func NewLockStatusMsg(username string, password string, status lockstatus) (*Mes
 	return msg, nil
 }
 
+// NewServerCommandMsg returns a southbound message carrying an arbitrary
+// IntelliM command, for probing the eIDC32 with requests the other
+// constructors don't cover. path is the request URI (e.g. "/eidc/reboot").
+// The request is a GET if body is nil, otherwise body is marshaled to JSON
+// and POSTed.
+func NewServerCommandMsg(path string, username string, password string, body interface{}) (*Message, error) {
+	imUrl := intellimUrl(path, username, password)
+
+	method := http.MethodGet
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, imUrl.String(), bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(ua, listenerUA())
+
+	msg := &Message{
+		direction: Southbound,
+		Request:   req,
+		Body:      bodyBytes,
+		lock:      &sync.Mutex{},
+	}
+
+	return msg, nil
+}
+
+// NewEventMsg returns a northbound message reporting event to IntelliM at
+// host, the way an eIDC32 does. The event is sent as-is: see
+// Session.NewEventMsg() for one with a plausible EventID and Time.
+func NewEventMsg(host string, serverKey string, event EventRequest) (*Message, error) {
+	eventUrl := url.URL{
+		Scheme: methodHttp,
+		Host:   host,
+		Path:   EventRequestURI,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, eventUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(contentTypeHeaderName, ApplicationJSON)
+	if serverKey != "" {
+		req.Header.Set(serverKeyHeaderName, serverKey)
+	}
+
+	msg := &Message{
+		direction: Northbound,
+		Request:   req,
+		Body:      body,
+		lock:      &sync.Mutex{},
+	}
+
+	return msg, nil
+}
+
+// InjectForcedOpen forges a door alarm, to see how IntelliM's alerting reacts:
+// it reports an EventAccessEvent_DoorOpenTooLong event to IntelliM as though
+// the eIDC32 had raised it. IntelliM acknowledges events by POSTing their IDs
+// to /eidc/eventack, so a mangler is installed to keep the acknowledgement
+// away from the eIDC32, which knows nothing of the event. Don't call it from a
+// Mangler: it waits for the session to accept the event.
+func InjectForcedOpen(s *Session) error {
+	msg, err := s.NewEventMsg(EventRequest{EventType: EventAccessEvent_DoorOpenTooLong})
+	if err != nil {
+		return err
+	}
+
+	event, err := msg.ParseEventRequest()
+	if err != nil {
+		return err
+	}
+
+	s.Inject(*msg, []Mangler{dropEventAck{eventID: event.EventID, session: s}})
+	return nil
+}
+
 func intellimUrl(path string, username string, password string) url.URL {
 	v := url.Values{}
 	v.Set(user, username)
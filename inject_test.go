@@ -2,8 +2,12 @@ package eidc32proxy
 
 import (
 	"bytes"
+	"fmt"
 	"log"
+	"net/http"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestHeartBeat(t *testing.T) {
@@ -25,3 +29,312 @@ func TestHeartBeat(t *testing.T) {
 		t.Fatalf("unexpected result")
 	}
 }
+
+func TestNewEventAckMsgMulti(t *testing.T) {
+	testData := [][]int{
+		{894},
+		{894, 895, 896},
+	}
+	for _, ids := range testData {
+		var msg *Message
+		var err error
+		if len(ids) == 1 {
+			msg, err = NewEventAckMsg("admin", "admin", ids[0])
+		} else {
+			msg, err = NewEventAckMsgMulti("admin", "admin", ids)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := msg.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ReadMsg(raw, Southbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Type != MsgTypeEventAckRequest {
+			t.Fatalf("expected %s, got %s", MsgTypeEventAckRequest, parsed.Type)
+		}
+
+		ear, err := parsed.ParseEventAckRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ear.EventIds) != len(ids) {
+			t.Fatalf("expected %d event IDs, got %d", len(ids), len(ear.EventIds))
+		}
+		for i := range ids {
+			if ear.EventIds[i] != ids[i] {
+				t.Fatalf("expected event ID %d, got %d", ids[i], ear.EventIds[i])
+			}
+		}
+	}
+}
+
+func TestParseEventAckRequestMulti(t *testing.T) {
+	body := `{"eventIds":[894, 895, 896, 897]}`
+	testData := fmt.Sprintf(""+
+		"POST /eidc/eventack?username=admin&password=admin&seq=40 HTTP/1.1\r\n"+
+		"Host: 192.168.6.40\r\n"+
+		"User-Agent: eIDCListener\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", len(body), body)
+	expected := []int{894, 895, 896, 897}
+
+	msg, err := ReadMsg([]byte(testData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ear, err := msg.ParseEventAckRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ear.EventIds) != len(expected) {
+		t.Fatalf("expected %d event IDs, got %d", len(expected), len(ear.EventIds))
+	}
+	for i := range expected {
+		if ear.EventIds[i] != expected[i] {
+			t.Fatalf("expected event ID %d, got %d", expected[i], ear.EventIds[i])
+		}
+	}
+}
+
+func TestInjectForcedOpen(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	expect := func(c <-chan *Message, msgType MsgType) *Message {
+		select {
+		case msg := <-c:
+			if msg.Type != msgType {
+				t.Fatalf("expected %s, got %s", msgType, msg.Type)
+			}
+			return msg
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", msgType)
+		}
+		return nil
+	}
+
+	forge := func() int {
+		err := InjectForcedOpen(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		er, err := expect(toServer, MsgTypeEventRequest).ParseEventRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if er.EventType != EventAccessEvent_DoorOpenTooLong {
+			t.Fatalf("expected %s, got %s", EventAccessEvent_DoorOpenTooLong, er.EventType)
+		}
+
+		session.mangleLock.Lock()
+		defer session.mangleLock.Unlock()
+		for _, m := range session.manglers {
+			if dea, ok := m.(dropEventAck); ok && dea.eventID == er.EventID {
+				return er.EventID
+			}
+		}
+		t.Fatalf("no ack suppression mangler installed for event %d", er.EventID)
+		return 0
+	}
+
+	ack := func(ids ...int) {
+		msg, err := NewEventAckMsgMulti("admin", "admin", ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := msg.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an ack of just the forged event never reaches the eIDC32, but IntelliM
+	// gets a response
+	id := forge()
+	ack(id)
+	expect(toServer, MsgTypeEventAckResponse)
+
+	// a batched ack reaches the eIDC32 without the forged event
+	id = forge()
+	ack(7, id, 8)
+	ear, err := expect(fromProxy, MsgTypeEventAckRequest).ParseEventAckRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ear.EventIds) != 2 || ear.EventIds[0] != 7 || ear.EventIds[1] != 8 {
+		t.Fatalf("expected event IDs [7 8], got %v", ear.EventIds)
+	}
+
+	// nothing else got through
+	select {
+	case msg := <-fromProxy:
+		t.Fatalf("unexpected southbound %s", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEIDCHTTPResponseProto(t *testing.T) {
+	data := EIDCHTTPResponseData{
+		StatusCode: http.StatusOK,
+		WrapperBody: &EIDCSimpleResponse{
+			Cmd:    HeartbeatResponseCmd,
+			Result: true,
+		},
+	}
+	http10, err := EIDCHTTPResponseBytes(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data.Proto = "HTTP/1.1"
+	http11, err := EIDCHTTPResponseBytes(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the status line differs, the impersonated headers are the same
+	if !bytes.HasPrefix(http10, []byte("HTTP/1.0 200 OK\r\nServer: ")) {
+		t.Fatalf("unexpected default response:\n%s", http10)
+	}
+	if !bytes.HasPrefix(http11, []byte("HTTP/1.1 200 OK\r\nServer: ")) {
+		t.Fatalf("unexpected HTTP/1.1 response:\n%s", http11)
+	}
+	if !bytes.Equal(http10[len("HTTP/1.0"):], http11[len("HTTP/1.1"):]) {
+		t.Fatalf("expected responses to differ only in version:\n%s\n%s", http10, http11)
+	}
+
+	msg, err := ReadMsg(http11, Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Response.ProtoMinor != 1 {
+		t.Fatalf("expected HTTP/1.1 response, got %s", msg.Response.Proto)
+	}
+	if msg.Type != MsgTypeHeartbeatResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeHeartbeatResponse, msg.Type)
+	}
+
+	data.Proto = "HTTP/one"
+	if _, err = EIDCHTTPResponseBytes(&data); err == nil {
+		t.Fatal("expected an error for an invalid protocol version")
+	}
+}
+
+func TestNewServerCommandMsg(t *testing.T) {
+	// GET: no body
+	msg, err := NewServerCommandMsg(enableEventsRequestURI, "admin", "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "GET /eidc/enableevents?password=admin&seq=0&username=admin HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+	if string(raw) != expected {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, raw)
+	}
+	parsed, err := ReadMsg(raw, Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Type != MsgTypeEnableEventsRequest {
+		t.Fatalf("expected %s, got %s", MsgTypeEnableEventsRequest, parsed.Type)
+	}
+
+	// POST: body marshaled to JSON
+	str := SetTimeRequest{
+		Time:          "2020-07-04T12:00:00",
+		DstObservance: "US",
+		DstStart:      SetTimeRequestDSTData{Month: 3, WeekInMonth: 2, DayOfWeek: 0, Hour: 2},
+		DstEnd:        SetTimeRequestDSTData{Month: 11, WeekInMonth: 1, DayOfWeek: 0, Hour: 2},
+	}
+	msg, err = NewServerCommandMsg(setTimeRequestURI, "admin", "admin", str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Request.Method != http.MethodPost {
+		t.Fatalf("expected %s, got %s", http.MethodPost, msg.Request.Method)
+	}
+	raw, err = msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = ReadMsg(raw, Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Type != MsgTypeSetTimeRequest {
+		t.Fatalf("expected %s, got %s", MsgTypeSetTimeRequest, parsed.Type)
+	}
+	if parsed.Request.URL.Query().Get(serverRequestSequenceParam) != "0" {
+		t.Fatalf("expected a %s parameter, got %s", serverRequestSequenceParam, parsed.Request.URL.RawQuery)
+	}
+	result, err := parsed.ParseSetTimeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Time != str.Time || result.DstStart.Month != 3 || result.DstEnd.Month != 11 {
+		t.Fatalf("set time request didn't survive the round trip: %+v", result)
+	}
+}
+
+func TestMutateHeader(t *testing.T) {
+	raw := eidcResponseBytes(HeartbeatResponseCmd, "")
+
+	variants, err := MutateHeader(raw, "Content-Length")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 3 {
+		t.Fatalf("expected 3 variants, got %d", len(variants))
+	}
+
+	length := strconv.Itoa(len(raw) - bytes.Index(raw, []byte("\r\n\r\n")) - 4)
+	expected := []string{
+		"Content-Length: 18446744073709551616\r\n",
+		"Content-Length: -" + length + "\r\n",
+		"Content-Length: \r\n",
+	}
+	for i, variant := range variants {
+		if !bytes.Contains(variant, []byte(expected[i])) {
+			t.Fatalf("variant %d: expected to find %q in %q", i, expected[i], variant)
+		}
+		if bytes.Equal(variant, raw) {
+			t.Fatalf("variant %d is unchanged", i)
+		}
+		for j := 0; j < i; j++ {
+			if bytes.Equal(variant, variants[j]) {
+				t.Fatalf("variants %d and %d are the same", j, i)
+			}
+		}
+	}
+
+	// custom mutations, and a missing header
+	variants, err = MutateHeader(raw, "Content-Length", func(v string) string { return v + v })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 1 || !bytes.Contains(variants[0], []byte("Content-Length: "+length+length+"\r\n")) {
+		t.Fatalf("expected a doubled length, got %q", variants)
+	}
+	_, err = MutateHeader(raw, "X-Missing")
+	if err == nil {
+		t.Fatal("expected an error for a missing header")
+	}
+}
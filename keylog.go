@@ -0,0 +1,77 @@
+package eidc32proxy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	keyLogFile = ".eidc32proxy.keys"
+)
+
+// keyLog holds the TLS key log configuration shared by the Server and by
+// the client side of each session (see ConnectUsingTerribleTLSContext()).
+// Files are opened once and kept open, because every connection writes to
+// them.
+var keyLog = struct {
+	mu    sync.Mutex
+	path  string              // path set by SetKeyLogPath()
+	set   bool                // SetKeyLogPath() has been called
+	files map[string]*os.File // open key log files by path
+}{
+	files: make(map[string]*os.File),
+}
+
+// SetKeyLogPath directs the TLS session keys of both the proxy's server
+// (eIDC32-facing) and client (IntelliM-facing) connections to path, in NSS
+// key log format, so that Wireshark can decrypt captured traffic. An empty
+// path disables key logging. By default only the server's keys are logged,
+// to ~/.eidc32proxy.keys. Call it before NewServer().
+func SetKeyLogPath(path string) {
+	keyLog.mu.Lock()
+	keyLog.path = path
+	keyLog.set = true
+	keyLog.mu.Unlock()
+}
+
+// keyLogWriter returns the io.Writer for a terribletls.Config's KeyLogWriter,
+// or nil if key logging is disabled. useDefault determines whether keys get
+// logged to ~/.eidc32proxy.keys when SetKeyLogPath() hasn't been called.
+func keyLogWriter(useDefault bool) (io.Writer, error) {
+	keyLog.mu.Lock()
+	defer keyLog.mu.Unlock()
+
+	path := keyLog.path
+	if !keyLog.set {
+		if !useDefault {
+			return nil, nil
+		}
+		keyLogDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(keyLogDir, keyLogFile)
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	if f, ok := keyLog.files[path]; ok {
+		return f, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	keyLog.files[path] = f
+	return f, nil
+}
@@ -0,0 +1,79 @@
+package eidc32proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// resetKeyLog restores the default key log configuration.
+func resetKeyLog() {
+	keyLog.mu.Lock()
+	for path, f := range keyLog.files {
+		f.Close()
+		delete(keyLog.files, path)
+	}
+	keyLog.path = ""
+	keyLog.set = false
+	keyLog.mu.Unlock()
+}
+
+func TestSetKeyLogPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eidc32proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetKeyLog()
+
+	// by default, only the server logs keys
+	w, err := keyLogWriter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != nil {
+		t.Fatal("expected no client key log by default")
+	}
+
+	path := filepath.Join(dir, "keys", "sslkeylog.txt")
+	SetKeyLogPath(path)
+
+	lines := []string{
+		"CLIENT_RANDOM 0123 4567\n",
+		"CLIENT_RANDOM 89ab cdef\n",
+	}
+	for i, useDefault := range []bool{true, false} {
+		w, err := keyLogWriter(useDefault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w == nil {
+			t.Fatal("expected a key log writer")
+		}
+		if _, err = w.Write([]byte(lines[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != lines[0]+lines[1] {
+		t.Fatalf("expected %q, got %q", lines[0]+lines[1], result)
+	}
+
+	// disabled
+	resetKeyLog()
+	SetKeyLogPath("")
+	for _, useDefault := range []bool{true, false} {
+		w, err := keyLogWriter(useDefault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != nil {
+			t.Fatal("expected key logging to be disabled")
+		}
+	}
+}
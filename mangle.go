@@ -1,10 +1,15 @@
 package eidc32proxy
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 type (
@@ -23,9 +28,18 @@ const (
 	ManglerNoop                MangleResult = 1 << 4
 )
 
+// seqMangler renumbers southbound sequenced requests so that messages
+// injected by the proxy don't leave gaps or repeats in the sequence seen by
+// the eIDC32. If IntelliM restarts its own sequence at 1 (as it does when it
+// reconnects), that's a reset: the renumbering starts over rather than
+// rewriting every subsequent message. Only a restart at 1 counts. A lower
+// seq is otherwise expected, because IntelliM doesn't know about injected
+// messages. onReset, if set, is called with the old lastSeq on each reset.
 type seqMangler struct {
-	lastSeq int
-	log     bool
+	lastSeq   int
+	lastSeqIn int // last seq received from IntelliM (not injected)
+	log       bool
+	onReset   func(lastSeq int)
 }
 
 func (o *seqMangler) Mangle(msg *Message) (MangleResult, error) {
@@ -61,6 +75,20 @@ func (o *seqMangler) Mangle(msg *Message) (MangleResult, error) {
 		return ManglerErr, err
 	}
 
+	// IntelliM starting over at 1 means it has started a new sequence.
+	if !msg.Injected {
+		if seqIn == 1 && o.lastSeqIn > 0 {
+			if o.log {
+				log.Printf("Sequence reset by server, was at %d", o.lastSeq)
+			}
+			if o.onReset != nil {
+				o.onReset(o.lastSeq)
+			}
+			o.lastSeq = 0
+		}
+		o.lastSeqIn = seqIn
+	}
+
 	// at this point we can be confident that a sequenced command
 	// has arrived. No matter what, this message is getting sent,
 	// so lastSeq will need to be incremented. Do that now.
@@ -106,25 +134,81 @@ func (o *DropMessageByType) Mangle(msg *Message) (MangleResult, error) {
 	return ManglerNoop, nil
 }
 
+// HeartbeatRateLimitMangler simulates a flaky link by dropping all but one of
+// every KeepEveryN heartbeats. Direction selects which half of the exchange
+// gets dropped: Southbound drops IntelliM's heartbeat requests (so the eIDC32
+// never sees them), Northbound drops the eIDC32's responses. The first
+// heartbeat of each group of KeepEveryN is kept. Dropped requests never reach
+// the sequence number fixup, so the surviving requests are still sequenced
+// consecutively. KeepEveryN < 2 keeps everything.
+type HeartbeatRateLimitMangler struct {
+	KeepEveryN int
+	Direction  Direction
+	count      int
+}
+
+func (o *HeartbeatRateLimitMangler) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != o.Direction {
+		return ManglerNoop, nil
+	}
+
+	switch {
+	case o.Direction == Southbound && msg.Type != MsgTypeHeartbeatRequest:
+		return ManglerNoop, nil
+	case o.Direction == Northbound && msg.Type != MsgTypeHeartbeatResponse:
+		return ManglerNoop, nil
+	}
+
+	keep := o.KeepEveryN < 2 || o.count%o.KeepEveryN == 0
+	o.count++
+	if keep {
+		return ManglerNoop, nil
+	}
+	return ManglerDrop, nil
+}
+
+// PrintMangler logs every message with the log package. It's LogMangler
+// writing to log.Writer().
 type PrintMangler struct{}
 
 func (o PrintMangler) Mangle(msg *Message) (MangleResult, error) {
-	log.Printf("%s %s message", msg.direction.String(), msg.Type.String())
-	switch msg.Type {
-	case MsgTypeEventRequest:
-		eventReq, err := msg.ParseEventRequest()
+	return LogMangler{Writer: log.Writer()}.Mangle(msg)
+}
+
+// LogMangler writes messages to Writer in the format produced by
+// Message.PrintableLines(). MsgTypes selects the messages to write, nil
+// writes all of them. It never alters or drops traffic, so it's safe to add
+// anywhere in the mangler chain: it sees messages as modified by the
+// manglers added before it.
+type LogMangler struct {
+	Writer   io.Writer
+	MsgTypes []MsgType
+}
+
+func (o LogMangler) Mangle(msg *Message) (MangleResult, error) {
+	if o.MsgTypes != nil && !containsMsgType(o.MsgTypes, msg.Type) {
+		return ManglerNoop, nil
+	}
+
+	lines, err := msg.PrintableLines()
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	for _, l := range lines {
+		_, err = io.WriteString(o.Writer, l)
 		if err != nil {
-			return ManglerNoop, err
+			return ManglerNoop | ManglerErr, err
 		}
-		log.Printf("  event type %s", eventReq.EventType)
 	}
 	return ManglerNoop, nil
 }
 
 // dropEidcResponse is a mangler that drops a single instance of an eIDC32 WebServer
 // HTTP response message. These messages come in response to IntelliM commands, and
-// include an EIDCSimpleResponse{} or EIDCBodyResponse{} as payload.
-// It's a one-shot mangler, so it removes itself after dropping a single message.
+// include an EIDCSimpleResponse{} or EIDCBodyResponse{} as payload, or an
+// EIDCErrorsResponse{} if the command failed. It's a one-shot mangler, so it
+// removes itself after dropping a single message.
 // msgType is used to match the message we'd like to suppress.
 // log controls whether we print to stderr.
 type dropEidcResponse struct {
@@ -141,7 +225,7 @@ func (o dropEidcResponse) Mangle(msg *Message) (MangleResult, error) {
 		return ManglerNoop, nil
 	}
 
-	if msg.Type != o.msgType {
+	if !isResponseTo(msg, o.msgType) {
 		return ManglerNoop, nil
 	}
 
@@ -152,12 +236,139 @@ func (o dropEidcResponse) Mangle(msg *Message) (MangleResult, error) {
 	return ManglerDrop | ManglerDone, nil
 }
 
+// isResponseTo returns true if msg is a response of type msgType, or the
+// eIDC32's failure response (MsgTypeEIDCError) to the same command.
+func isResponseTo(msg *Message, msgType MsgType) bool {
+	if msg.Type == msgType {
+		return true
+	}
+	return msg.Type == MsgTypeEIDCError && msg.failedResponseType() == msgType
+}
+
+// captureResponse is a one-shot mangler used by Session.Request(). It drops
+// the first response of msgType (or failure response to the same command, see
+// isResponseTo()) travelling in direction and hands it over on c, so that the
+// side which didn't send the request never sees the response.
+type captureResponse struct {
+	direction Direction
+	msgType   MsgType
+	c         chan *Message
+}
+
+func (o *captureResponse) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != o.direction {
+		return ManglerNoop, nil
+	}
+
+	if msg.Response == nil {
+		return ManglerNoop, nil
+	}
+
+	if !isResponseTo(msg, o.msgType) {
+		return ManglerNoop, nil
+	}
+
+	// hand over a copy: the relay keeps working on msg after we return.
+	captured := *msg
+	captured.Dropped = true
+	select {
+	case o.c <- &captured:
+	default:
+	}
+
+	return ManglerDrop | ManglerDone, nil
+}
+
+// patchJSONFields returns the JSON object body with each of the named fields
+// set to (the JSON encoding of) its value. The object is unmarshaled to a map
+// rather than to the struct describing the message so that fields the struct
+// doesn't know about survive the round trip.
+func patchJSONFields(body []byte, fields map[string]interface{}) ([]byte, error) {
+	m := make(map[string]json.RawMessage)
+	err := json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		m[k], err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(m)
+}
+
+// dropEventAck keeps IntelliM's acknowledgement of a forged event (see
+// InjectForcedOpen()) away from the eIDC32, which never raised the event. If
+// the southbound eventack carries only eventID, it's dropped and a fake
+// EVENTACK response is injected northbound in its place. If IntelliM batched
+// eventID with real events, only eventID is removed from the request. It's a
+// one-shot mangler. session is required so the fake response can be injected.
+type dropEventAck struct {
+	eventID int
+	session *Session
+}
+
+func (o dropEventAck) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != Southbound {
+		return ManglerNoop, nil
+	}
+
+	if msg.Type != MsgTypeEventAckRequest {
+		return ManglerNoop, nil
+	}
+
+	ear, err := msg.ParseEventAckRequest()
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	var remaining []int
+	for _, id := range ear.EventIds {
+		if id != o.eventID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == len(ear.EventIds) {
+		return ManglerNoop, nil
+	}
+
+	if len(remaining) > 0 {
+		payload, err := patchJSONFields(msg.Body, map[string]interface{}{"eventIds": remaining})
+		if err != nil {
+			return ManglerNoop | ManglerErr, err
+		}
+		msg.SetBody(payload)
+		return ManglerSuccess | ManglerDone, nil
+	}
+
+	if o.session == nil {
+		return ManglerDrop | ManglerDone, fmt.Errorf("dropped ack of event %d but cannot fake a response without session info", o.eventID)
+	}
+
+	resp, err := EIDCHTTPResponseMsg(&EIDCHTTPResponseData{
+		StatusCode:  http.StatusOK,
+		WrapperBody: &EIDCSimpleResponse{Cmd: EventAckResponseCmd, Result: true},
+	})
+	if err != nil {
+		return ManglerDrop | ManglerDone, err
+	}
+	resp.direction = Northbound
+	resp.Type = MsgTypeEventAckResponse
+
+	go o.session.Inject(*resp, nil)
+
+	return ManglerDrop | ManglerDone, nil
+}
+
 // DropEidcEvent mangler suppresses northbound eIDC32 event messages.
 // Doing so requres 3 distinct operations:
 //  1) Match the event message, suppress it so it doesn't reach the server.
 //  2) Generate a fake server ACK message (POST event ID to /eidc/eventack)
 //  3) Match the eIDC HTTP response to the POST above, suppres it.
 // EventType, OnlyBuffered and OnlyLive are filters used to select the event.
+// PointIDs and SiteCodes, if not empty, select events whose PointID (or
+// SiteCode) appears in the list.
 // FilterFunc() is optional, can be used for more granular event filtering. Return
 // true to indicate whether an event should be suppressed.
 // OneShot indicates the mangler should remove itself after the first match.
@@ -168,6 +379,8 @@ type DropEidcEvent struct {
 	EventType    EventType
 	OnlyBuffered bool
 	OnlyLive     bool
+	PointIDs     []int
+	SiteCodes    []int
 	OneShot      bool
 	FilterFunc   func(event *EventRequest) bool
 	PostFunc     func(session *Session) error
@@ -197,21 +410,29 @@ func (o DropEidcEvent) Mangle(msg *Message) (MangleResult, error) {
 	}
 
 	// check for mandatory buffered (or not) event types
-	if o.OnlyBuffered && event.EventType&BufferedEventFlag != BufferedEventFlag {
+	if o.OnlyBuffered && !event.EventType.IsBuffered() {
 		return ManglerNoop, nil
 	}
-	if o.OnlyLive && event.EventType&BufferedEventFlag != 0 {
+	if o.OnlyLive && event.EventType.IsBuffered() {
 		return ManglerNoop, nil
 	}
 
 	// strip the buffered event flag
-	event.EventType = event.EventType & ^BufferedEventFlag
+	event.EventType = event.EventType.Base()
 
 	// check for the required event type (if any)
 	if o.EventType != 0 && event.EventType != o.EventType {
 		return ManglerNoop, nil
 	}
 
+	// check for the required point IDs and site codes (if any)
+	if len(o.PointIDs) > 0 && !containsInt(o.PointIDs, event.PointID) {
+		return ManglerNoop, nil
+	}
+	if len(o.SiteCodes) > 0 && !containsInt(o.SiteCodes, event.SiteCode) {
+		return ManglerNoop, nil
+	}
+
 	// run FilterFunc if it exists
 	if o.FilterFunc != nil {
 		dropIt := o.FilterFunc(&event)
@@ -220,7 +441,8 @@ func (o DropEidcEvent) Mangle(msg *Message) (MangleResult, error) {
 		}
 	}
 
-	eventAckRequest, err := NewEventAckMsg(o.Session.apiCreds.username, o.Session.apiCreds.password, event.EventID)
+	creds := o.Session.apiCredentials()
+	eventAckRequest, err := NewEventAckMsg(creds.username, creds.password, event.EventID)
 	if err != nil {
 		return ManglerNoop, err
 	}
@@ -241,6 +463,158 @@ func (o DropEidcEvent) Mangle(msg *Message) (MangleResult, error) {
 	return result, err
 }
 
+// containsInt returns true if list contains i.
+func containsInt(list []int, i int) bool {
+	for _, v := range list {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMsgType returns true if list contains t.
+func containsMsgType(list []MsgType, t MsgType) bool {
+	for _, v := range list {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// SpoofLockStatusResponse rewrites the "status" field of northbound
+// Door0x2fLockStatusResponse messages so that IntelliM sees Status no matter
+// what the eIDC32 actually reported. It's the response counterpart to the
+// stealth option of SetLockStatus(): use it when the door has been unlocked
+// (or locked) behind IntelliM's back and IntelliM asks about it. Any other
+// fields in the response body are preserved. OneShot indicates the mangler
+// should remove itself after rewriting one response.
+type SpoofLockStatusResponse struct {
+	Status  lockstatus
+	OneShot bool
+}
+
+func (o SpoofLockStatusResponse) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != Northbound {
+		return ManglerNoop, nil
+	}
+
+	if msg.Response == nil {
+		return ManglerNoop, nil
+	}
+
+	if msg.Type != MsgTypeDoor0x2fLockStatusResponse {
+		return ManglerNoop, nil
+	}
+
+	eidcBR, err := msg.parseEIDCBodyResponse()
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	eidcBR.Body, err = patchJSONFields(eidcBR.Body, map[string]interface{}{"status": o.Status.String()})
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	payload, err := json.Marshal(eidcBR)
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	msg.SetBody(payload)
+
+	result := ManglerSuccess
+	if o.OneShot {
+		result = result | ManglerDone
+	}
+	return result, nil
+}
+
+// RewriteGetOutboundMangler rewrites the primary and secondary host addresses
+// in northbound getoutbound responses to Host, and the ports to Port, so that
+// the outbound configuration the eIDC32 reports points back at the proxy
+// rather than the real IntelliM host. Port 0 leaves the ports alone. Any other
+// fields in the response body are preserved, and Content-Length is fixed up.
+type RewriteGetOutboundMangler struct {
+	Host string
+	Port int
+}
+
+func (o RewriteGetOutboundMangler) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != Northbound {
+		return ManglerNoop, nil
+	}
+
+	if msg.Response == nil {
+		return ManglerNoop, nil
+	}
+
+	if msg.Type != MsgTypeGetoutboundResponse {
+		return ManglerNoop, nil
+	}
+
+	eidcBR, err := msg.parseEIDCBodyResponse()
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	rewrite := map[string]interface{}{
+		"primaryHostAddress":   o.Host,
+		"secondaryHostAddress": o.Host,
+	}
+	if o.Port != 0 {
+		rewrite["primaryPort"] = o.Port
+		rewrite["secondaryPort"] = o.Port
+	}
+	eidcBR.Body, err = patchJSONFields(eidcBR.Body, rewrite)
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	payload, err := json.Marshal(eidcBR)
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	msg.SetBody(payload)
+
+	return ManglerSuccess, nil
+}
+
+// ClampLockDurationMangler rewrites the Duration in southbound door lock
+// status requests to Duration, so that (for example) an unlock IntelliM meant
+// to be indefinite (-1) becomes a timed one which the eIDC32 relocks itself.
+// Any other fields in the request body are preserved, and Content-Length is
+// fixed up.
+type ClampLockDurationMangler struct {
+	Duration int
+}
+
+func (o ClampLockDurationMangler) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != Southbound {
+		return ManglerNoop, nil
+	}
+
+	if msg.Request == nil {
+		return ManglerNoop, nil
+	}
+
+	if msg.Type != MsgTypeDoor0x2fLockStatusRequest {
+		return ManglerNoop, nil
+	}
+
+	payload, err := patchJSONFields(msg.Body, map[string]interface{}{"duration": o.Duration})
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	msg.SetBody(payload)
+
+	return ManglerSuccess, nil
+}
+
 type DropEidcPointStatusRequest struct {
 	point point
 }
@@ -263,3 +637,159 @@ func (o DropEidcPointStatusRequest) Mangle(msg *Message) (MangleResult, error) {
 	}
 	return ManglerNoop, nil
 }
+
+// DumpFirmwareMangler writes the body of firmware download and upload
+// requests to the file at Path. Firmware images travel as raw bytes with an
+// "application/binary" Content-Type, so the body is written verbatim rather
+// than being treated like the JSON payloads found everywhere else. Each
+// image overwrites the previous one. The message is not modified.
+type DumpFirmwareMangler struct {
+	Path string
+}
+
+func (o DumpFirmwareMangler) Mangle(msg *Message) (MangleResult, error) {
+	var image []byte
+	switch msg.Type {
+	case MsgTypeDownloadRequest:
+		image = msg.ParseDownloadRequest()
+	case MsgTypeUploadRequest:
+		image = msg.ParseUploadRequest()
+	default:
+		return ManglerNoop, nil
+	}
+
+	err := ioutil.WriteFile(o.Path, image, 0600)
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	log.Printf("wrote %d byte %s image to %s", len(image), msg.Type, o.Path)
+	return ManglerNoop, nil
+}
+
+// DelayMangler delays messages of type MsgType travelling in Direction by
+// Delay. Manglers run with the session's relay and mangler locks held, so
+// sleeping in Mangle() would stall every message in both directions (and any
+// injections). Instead, the matching message is dropped and a copy of it is
+// re-injected after Delay, which means it bypasses any remaining manglers and
+// is marked as Injected. Messages which arrive in the meantime are not held
+// up. A message whose delay outlasts the session is discarded. Session is
+// required because re-injection happens via the session.
+type DelayMangler struct {
+	MsgType   MsgType
+	Direction Direction
+	Delay     time.Duration
+	Session   *Session
+}
+
+func (o DelayMangler) Mangle(msg *Message) (MangleResult, error) {
+	if o.Session == nil {
+		return ManglerNoop, fmt.Errorf("cannot delay message without session info")
+	}
+
+	if msg.direction != o.Direction || msg.Type != o.MsgType {
+		return ManglerNoop, nil
+	}
+
+	delayed := *msg
+	time.AfterFunc(o.Delay, func() {
+		// gives up, rather than blocking, if the session has ended
+		o.Session.inject(delayed, nil)
+	})
+
+	return ManglerDrop, nil
+}
+
+// AutoAckEidcEvent mangler acknowledges northbound eIDC32 events on behalf
+// of the server by POSTing the event ID to /eidc/eventack, then suppresses the
+// eIDC's response to that POST. The event itself is passed along unmodified.
+// It's meant for sessions where nothing on the IntelliM side consumes the
+// events. Point status messages (the other northbound "no response" message)
+// don't get acknowledged by IntelliM, so this mangler leaves them alone.
+// Session is required because the ack needs the session's API credentials.
+type AutoAckEidcEvent struct {
+	Session *Session
+}
+
+func (o AutoAckEidcEvent) Mangle(msg *Message) (MangleResult, error) {
+	if o.Session == nil {
+		return ManglerNoop, fmt.Errorf("cannot ack eidc event without session info")
+	}
+
+	if msg.direction != Northbound || msg.Type != MsgTypeEventRequest {
+		return ManglerNoop, nil
+	}
+
+	event, err := msg.ParseEventRequest()
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	creds := o.Session.apiCredentials()
+	eventAckRequest, err := NewEventAckMsg(creds.username, creds.password, event.EventID)
+	if err != nil {
+		return ManglerNoop | ManglerErr, err
+	}
+
+	dropMangler := dropEidcResponse{msgType: MsgTypeEventAckResponse}
+	go o.Session.Inject(*eventAckRequest, []Mangler{dropMangler})
+
+	return ManglerNoop, nil
+}
+
+// BlockDangerousMangler protects real hardware during testing. It drops
+// southbound commands which could wipe or brick the eIDC32 (reflash, firmware
+// download, database reset and factory default config), logs each one, and
+// injects a fake success response northbound so that IntelliM doesn't retry.
+// Session is required so that the fake response can be injected.
+type BlockDangerousMangler struct {
+	Session *Session
+}
+
+func (o BlockDangerousMangler) Mangle(msg *Message) (MangleResult, error) {
+	if msg.direction != Southbound {
+		return ManglerNoop, nil
+	}
+
+	if msg.Request == nil {
+		return ManglerNoop, nil
+	}
+
+	var respType MsgType
+	var cmd string
+	var body interface{}
+	switch msg.Type {
+	case MsgTypeReflashRequest:
+		respType, cmd = MsgTypeReflashResponse, ReflashResponseCmd
+	case MsgTypeDownloadRequest:
+		respType, cmd = MsgTypeDownloadResponse, DownloadResponseCmd
+		body = DownloadResponse{FileSize: len(msg.ParseDownloadRequest())}
+	case MsgTypeResetDBRequest:
+		respType, cmd = MsgTypeResetDBResponse, ResetDBResponseCmd
+	case MsgTypeDefaultConfigRequest:
+		respType, cmd = MsgTypeDefaultConfigResponse, DefaultConfigResponseCmd
+	default:
+		return ManglerNoop, nil
+	}
+
+	if o.Session == nil {
+		return ManglerDrop, fmt.Errorf("blocked %s but cannot fake a response without session info", msg.Type)
+	}
+
+	log.Printf("Blocking dangerous %s, faking %s", msg.Type, respType)
+
+	resp, err := EIDCHTTPResponseMsg(&EIDCHTTPResponseData{
+		StatusCode:  http.StatusOK,
+		WrapperBody: &EIDCSimpleResponse{Cmd: cmd, Result: true},
+		Body:        body,
+	})
+	if err != nil {
+		return ManglerDrop, err
+	}
+	resp.direction = Northbound
+	resp.Type = respType
+
+	go o.Session.Inject(*resp, nil)
+
+	return ManglerDrop, nil
+}
@@ -0,0 +1,662 @@
+package eidc32proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpFirmwareMangler(t *testing.T) {
+	// binary image including bytes which aren't valid UTF-8 or JSON
+	image := []byte{0x7f, 'E', 'L', 'F', 0x00, 0xff, 0xfe, '\r', '\n', '{', 0x80}
+	testData := append([]byte(""+
+		"POST /eidc/download?username=admin&password=admin&seq=24 HTTP/1.1\r\n"+
+		"Host: 192.168.6.40\r\n"+
+		"User-Agent: eIDCListener\r\n"+
+		"Content-Type: application/binary\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"), image...)
+
+	msg, err := ReadMsg(testData, Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "eidc32proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := DumpFirmwareMangler{Path: filepath.Join(dir, "firmware.img")}
+	result, err := m.Mangle(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != ManglerNoop {
+		t.Fatalf("expected ManglerNoop, got %d", result)
+	}
+
+	written, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, image) {
+		t.Fatalf("expected %x, got %x", image, written)
+	}
+
+	// other message types must be ignored
+	msg.Type = MsgTypeHeartbeatRequest
+	err = os.Remove(m.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Mangle(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(m.Path); !os.IsNotExist(err) {
+		t.Fatal("mangler wrote a file for a non-firmware message")
+	}
+}
+
+func TestDropEidcEventAcksOnlyDroppedEvent(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	session.AddMangler(DropEidcEvent{EventType: EventAccessGranted, Session: session, OneShot: true})
+
+	// the first event gets dropped and acked, the second one (a different
+	// event type) must reach the server without being acked by the proxy.
+	_, err := eidc.Write(eidcEventBytes(200, EventAccessGranted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = eidc.Write(eidcEventBytes(201, EventAccessRestricted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-fromProxy:
+		ear, err := msg.ParseEventAckRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ear.EventIds) != 1 || ear.EventIds[0] != 200 {
+			t.Fatalf("expected ack for event 200 only, got %v", ear.EventIds)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event ack")
+	}
+
+	select {
+	case msg := <-toServer:
+		er, err := msg.ParseEventRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if er.EventID != 201 {
+			t.Fatalf("expected event 201 to reach the server, got %d", er.EventID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for relayed event")
+	}
+
+	select {
+	case msg := <-fromProxy:
+		t.Fatalf("unexpected %s sent to the eIDC32", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDropEidcEventPointIDsSiteCodes(t *testing.T) {
+	eventBytes := func(eventID int, pointID int, siteCode int) []byte {
+		payload := fmt.Sprintf(`{"eventId":%d,"eventType":%d,"time":0,"pointId":%d,"siteCode":%d}`,
+			eventID, EventAccessGranted, pointID, siteCode)
+		return []byte(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+			"Host: intellim.example.com\r\n"+
+			"Content-Type: application/json\r\n"+
+			"Content-Length: %d\r\n\r\n%s", EventRequestURI, len(payload), payload))
+	}
+
+	testData := []struct {
+		pointID  int
+		siteCode int
+		dropped  bool
+	}{
+		{pointID: 1, siteCode: 100, dropped: true},
+		{pointID: 3, siteCode: 100, dropped: true},
+		{pointID: 2, siteCode: 100, dropped: false}, // point not listed
+		{pointID: 1, siteCode: 101, dropped: false}, // site code not listed
+	}
+
+	for i, td := range testData {
+		session, eidc, server := newPipeSession(t)
+		fromProxy := pipeMsgChan(eidc, Southbound)
+		toServer := pipeMsgChan(server, Northbound)
+		session.AddMangler(DropEidcEvent{
+			PointIDs:  []int{1, 3},
+			SiteCodes: []int{100},
+			Session:   session,
+		})
+
+		_, err := eidc.Write(eventBytes(300+i, td.pointID, td.siteCode))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if td.dropped {
+			select {
+			case msg := <-fromProxy:
+				if msg.Type != MsgTypeEventAckRequest {
+					t.Fatalf("expected %s, got %s", MsgTypeEventAckRequest, msg.Type)
+				}
+			case msg := <-toServer:
+				t.Fatalf("point %d site %d: expected the event to be dropped, server got %s",
+					td.pointID, td.siteCode, msg.Type)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for event ack")
+			}
+		} else {
+			select {
+			case msg := <-toServer:
+				if msg.Type != MsgTypeEventRequest {
+					t.Fatalf("expected %s, got %s", MsgTypeEventRequest, msg.Type)
+				}
+			case msg := <-fromProxy:
+				t.Fatalf("point %d site %d: expected the event to pass, eIDC32 got %s",
+					td.pointID, td.siteCode, msg.Type)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for event")
+			}
+		}
+
+		eidc.Close()
+		server.Close()
+	}
+}
+
+func TestDelayMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	delay := 300 * time.Millisecond
+	session.AddMangler(DelayMangler{
+		MsgType:   MsgTypeHeartbeatRequest,
+		Direction: Southbound,
+		Delay:     delay,
+		Session:   session,
+	})
+
+	heartbeat := "GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+	enableEvents := "GET /eidc/enableevents?username=admin&password=admin&seq=2 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+
+	// play the part of IntelliM: the heartbeat goes first, but should be
+	// overtaken by the (undelayed) enableevents request.
+	start := time.Now()
+	_, err := server.Write([]byte(heartbeat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Write([]byte(enableEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arrival := make(map[MsgType]time.Duration)
+	var order []MsgType
+	for len(order) < 2 {
+		select {
+		case msg := <-fromProxy:
+			arrival[msg.Type] = time.Since(start)
+			order = append(order, msg.Type)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out, got only %v", order)
+		}
+	}
+
+	if order[0] != MsgTypeEnableEventsRequest || order[1] != MsgTypeHeartbeatRequest {
+		t.Fatalf("expected enableevents then heartbeat, got %v", order)
+	}
+	if arrival[MsgTypeHeartbeatRequest] < delay {
+		t.Fatalf("heartbeat arrived after %s, expected at least %s",
+			arrival[MsgTypeHeartbeatRequest], delay)
+	}
+	if arrival[MsgTypeEnableEventsRequest] >= delay {
+		t.Fatalf("undelayed message arrived after %s, relay was stalled",
+			arrival[MsgTypeEnableEventsRequest])
+	}
+}
+
+func TestDelayMangler_SessionEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	session, eidc, server := newPipeSessionContext(t, ctx)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	delay := 100 * time.Millisecond
+	session.AddMangler(DelayMangler{
+		MsgType:   MsgTypeHeartbeatRequest,
+		Direction: Southbound,
+		Delay:     delay,
+		Session:   session,
+	})
+
+	heartbeat := "GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"
+	_, err := server.Write([]byte(heartbeat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// end the session while the heartbeat is held
+	cancel()
+	<-session.Done()
+	time.Sleep(2 * delay)
+
+	// the session closed the pipe, so anything sent is followed by close
+	for msg := range fromProxy {
+		t.Fatalf("delayed %s was sent after the session ended", msg.Type)
+	}
+
+	// a re-injection stuck on the ended session would still hold the relay
+	// lock, and this would block
+	locked := make(chan struct{})
+	go func() {
+		session.relayMutex.Lock()
+		session.relayMutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("delayed re-injection hung after the session ended")
+	}
+}
+
+func TestHeartbeatRateLimitMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	keepEveryN := 3
+	heartbeats := 9
+	session.AddMangler(&HeartbeatRateLimitMangler{
+		KeepEveryN: keepEveryN,
+		Direction:  Southbound,
+	})
+
+	for i := 1; i <= heartbeats; i++ {
+		heartbeat := fmt.Sprintf("GET /eidc/heartbeat?username=admin&password=admin&seq=%d HTTP/1.1\r\n"+
+			"Host: 192.168.6.40\r\n"+
+			"User-Agent: eIDCListener\r\n\r\n", i)
+		_, err := server.Write([]byte(heartbeat))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seqs []string
+	for {
+		select {
+		case msg := <-fromProxy:
+			if msg.Type != MsgTypeHeartbeatRequest {
+				t.Fatalf("expected %s, got %s", MsgTypeHeartbeatRequest, msg.Type)
+			}
+			seqs = append(seqs, msg.Request.URL.Query().Get(serverRequestSequenceParam))
+			continue
+		case <-time.After(200 * time.Millisecond):
+		}
+		break
+	}
+
+	if len(seqs) != heartbeats/keepEveryN {
+		t.Fatalf("expected %d of %d heartbeats, got %d", heartbeats/keepEveryN, heartbeats, len(seqs))
+	}
+
+	// survivors must be sequenced consecutively
+	for i, seq := range seqs {
+		if seq != strconv.Itoa(i+1) {
+			t.Fatalf("expected surviving heartbeat %d to have seq %d, got %s", i, i+1, seq)
+		}
+	}
+}
+
+func TestSpoofLockStatusResponse(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	session.AddMangler(SpoofLockStatusResponse{Status: Locked, OneShot: true})
+
+	expectStatus := func(expected string) {
+		select {
+		case msg := <-toServer:
+			if msg.Type != MsgTypeDoor0x2fLockStatusResponse {
+				t.Fatalf("expected %s, got %s", MsgTypeDoor0x2fLockStatusResponse, msg.Type)
+			}
+			if msg.Response.ContentLength != int64(len(msg.Body)) {
+				t.Fatalf("Content-Length %d doesn't match %d byte body",
+					msg.Response.ContentLength, len(msg.Body))
+			}
+			dlsr, err := msg.ParseDoor0x2fLockStatusResponse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dlsr.Status != expected {
+				t.Fatalf("expected server to see %s, got %s", expected, dlsr.Status)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for lock status response")
+		}
+	}
+
+	// the eIDC32 says "Unlocked", the server sees "Locked"
+	_, err := eidc.Write(eidcResponseBytes(Door0x2fLockStatusResponseCmd, `, "body":{"status":"Unlocked"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(Locked.String())
+
+	// one-shot: the next response passes unmodified
+	_, err = eidc.Write(eidcResponseBytes(Door0x2fLockStatusResponseCmd, `, "body":{"status":"Unlocked"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(Unlocked.String())
+}
+
+func TestRewriteGetOutboundMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	session.AddMangler(RewriteGetOutboundMangler{Host: "proxy.example.com", Port: 18801})
+
+	body := `, "body":{"siteKey":"xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", ` +
+		`"primaryHostAddress":"intellim.example.com", "primaryPort":18800, ` +
+		`"secondaryHostAddress":"11.22.33.44", "secondaryPort":18800, ` +
+		`"primarySsl":1, "secondarySsl":1, "retryInterval":1, "maxRandomRetryInterval":60, ` +
+		`"enabled":1, "unfamiliar":"field"}`
+	_, err := eidc.Write(eidcResponseBytes(GetoutboundResponseCmd, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-toServer:
+		if msg.Type != MsgTypeGetoutboundResponse {
+			t.Fatalf("expected %s, got %s", MsgTypeGetoutboundResponse, msg.Type)
+		}
+		if msg.Response.ContentLength != int64(len(msg.Body)) {
+			t.Fatalf("Content-Length %d doesn't match %d byte body",
+				msg.Response.ContentLength, len(msg.Body))
+		}
+		gor, err := msg.ParseGetOutboundResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gor.PrimaryHostAddress != "proxy.example.com" || gor.PrimaryPort != 18801 ||
+			gor.SecondaryHostAddress != "proxy.example.com" || gor.SecondaryPort != 18801 {
+			t.Fatalf("expected hosts rewritten to proxy.example.com:18801, got %s:%d and %s:%d",
+				gor.PrimaryHostAddress, gor.PrimaryPort, gor.SecondaryHostAddress, gor.SecondaryPort)
+		}
+		if gor.SiteKey != "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx" || gor.Enabled != 1 {
+			t.Fatalf("expected other fields to be preserved, got %+v", gor)
+		}
+		if !strings.Contains(string(msg.Body), `"unfamiliar":"field"`) {
+			t.Fatalf("expected unfamiliar fields to be preserved, got %s", msg.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for getoutbound response")
+	}
+}
+
+func TestClampLockDurationMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	session.AddMangler(ClampLockDurationMangler{Duration: 5})
+
+	lsr, err := NewLockStatusMsg("admin", "admin", Unlocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := lsr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Write(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-fromProxy:
+		if msg.Type != MsgTypeDoor0x2fLockStatusRequest {
+			t.Fatalf("expected %s, got %s", MsgTypeDoor0x2fLockStatusRequest, msg.Type)
+		}
+		if msg.Request.ContentLength != int64(len(msg.Body)) {
+			t.Fatalf("Content-Length %d doesn't match %d byte body",
+				msg.Request.ContentLength, len(msg.Body))
+		}
+		dlsr, err := msg.ParseDoor0x2fLockStatusRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dlsr.Duration != 5 {
+			t.Fatalf("expected duration 5, got %d", dlsr.Duration)
+		}
+		if dlsr.Status != Unlocked.String() {
+			t.Fatalf("expected status %s to be preserved, got %s", Unlocked, dlsr.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for lock status request")
+	}
+}
+
+func TestBlockDangerousMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	session.AddMangler(BlockDangerousMangler{Session: session})
+
+	firmware := "not really a firmware image"
+	testData := []struct {
+		request  string
+		respType MsgType
+	}{
+		{
+			request: "GET /eidc/reflash?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+				"Host: 192.168.6.40\r\n" +
+				"User-Agent: eIDCListener\r\n\r\n\r\n",
+			respType: MsgTypeReflashResponse,
+		},
+		{
+			request: fmt.Sprintf("POST /eidc/download?username=admin&password=admin&seq=2 HTTP/1.1\r\n"+
+				"Host: 192.168.6.40\r\n"+
+				"User-Agent: eIDCListener\r\n"+
+				"Content-Length: %d\r\n\r\n%s", len(firmware), firmware),
+			respType: MsgTypeDownloadResponse,
+		},
+		{
+			request: "GET /eidc/resetdb?username=admin&password=admin&seq=3 HTTP/1.1\r\n" +
+				"Host: 192.168.6.40\r\n" +
+				"User-Agent: eIDCListener\r\n\r\n\r\n",
+			respType: MsgTypeResetDBResponse,
+		},
+		{
+			request: "GET /eidc/defaultconfig?username=admin&password=admin&seq=4 HTTP/1.1\r\n" +
+				"Host: 192.168.6.40\r\n" +
+				"User-Agent: eIDCListener\r\n\r\n\r\n",
+			respType: MsgTypeDefaultConfigResponse,
+		},
+	}
+
+	for _, td := range testData {
+		_, err := server.Write([]byte(td.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case msg := <-toServer:
+			if msg.Type != td.respType {
+				t.Fatalf("expected %s, got %s", td.respType, msg.Type)
+			}
+			if td.respType == MsgTypeDownloadResponse {
+				dr, err := msg.ParseDownloadResponse()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if dr.FileSize != len(firmware) {
+					t.Fatalf("expected fileSize %d, got %d", len(firmware), dr.FileSize)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", td.respType)
+		}
+	}
+
+	// heartbeats are harmless and pass through
+	_, err := server.Write([]byte("GET /eidc/heartbeat?username=admin&password=admin&seq=5 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-fromProxy:
+		if msg.Type != MsgTypeHeartbeatRequest {
+			t.Fatalf("dangerous %s reached the eIDC32", msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for heartbeat")
+	}
+}
+
+func TestLogMangler(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	out := &bytes.Buffer{}
+	session.AddMangler(LogMangler{Writer: out, MsgTypes: []MsgType{MsgTypeEventRequest}})
+
+	// the heartbeat response isn't logged, the event is
+	if _, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, "")); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	if _, err := eidc.Write(eidcEventBytes(400, EventAccessGranted)); err != nil {
+		t.Fatal(err)
+	}
+	relayed := <-toServer
+
+	// output is complete once the message has been relayed
+	logged := out.String()
+	if strings.Contains(logged, HeartbeatResponseCmd) {
+		t.Fatalf("expected the heartbeat response to be filtered out, got:\n%s", logged)
+	}
+	if !strings.Contains(logged, EventRequestURI) || !strings.Contains(logged, `"eventId":400`) {
+		t.Fatalf("expected the event to be logged, got:\n%s", logged)
+	}
+
+	// LogMangler never alters traffic
+	if _, err := relayed.ParseEventRequest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeqManglerReset(t *testing.T) {
+	var resets []int
+	sm := &seqMangler{onReset: func(lastSeq int) { resets = append(resets, lastSeq) }}
+
+	heartbeat := func(seq int, injected bool) *Message {
+		msg, err := ReadMsg([]byte(fmt.Sprintf("GET /eidc/heartbeat?username=admin&password=admin&seq=%d HTTP/1.1\r\n"+
+			"Host: 192.168.6.40\r\n"+
+			"User-Agent: eIDCListener\r\n\r\n", seq)), Southbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Injected = injected
+		return msg
+	}
+
+	testData := []struct {
+		seqIn    int
+		injected bool
+		seqOut   int
+	}{
+		{seqIn: 1, seqOut: 1}, // the first 1 isn't a reset
+		{seqIn: 2, seqOut: 2},
+		{seqIn: 0, injected: true, seqOut: 3},
+		{seqIn: 3, seqOut: 4}, // renumbered around the injected message
+		{seqIn: 1, seqOut: 1}, // the server reconnected
+		{seqIn: 2, seqOut: 2},
+		{seqIn: 1, injected: true, seqOut: 3}, // injected messages never reset
+	}
+
+	for i, td := range testData {
+		msg := heartbeat(td.seqIn, td.injected)
+		if _, err := sm.Mangle(msg); err != nil {
+			t.Fatal(err)
+		}
+		seqOut := msg.Request.URL.Query().Get(serverRequestSequenceParam)
+		if seqOut != strconv.Itoa(td.seqOut) {
+			t.Fatalf("message %d: expected seq %d to become %d, got %s", i, td.seqIn, td.seqOut, seqOut)
+		}
+	}
+
+	if len(resets) != 1 || resets[0] != 4 {
+		t.Fatalf("expected one reset from lastSeq 4, got %v", resets)
+	}
+	if sm.lastSeq != 3 {
+		t.Fatalf("expected lastSeq 3, got %d", sm.lastSeq)
+	}
+}
+
+func TestPatchJSONFields(t *testing.T) {
+	result, err := patchJSONFields([]byte(`{"status":"Locked", "unfamiliar":[1,2]}`),
+		map[string]interface{}{"status": "Unlocked", "duration": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"duration":5,"status":"Unlocked","unfamiliar":[1,2]}`
+	if string(result) != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	_, err = patchJSONFields([]byte(`[]`), map[string]interface{}{"status": "Unlocked"})
+	if err == nil {
+		t.Fatal("expected an error patching something other than an object")
+	}
+}
@@ -3,10 +3,13 @@ package eidc32proxy
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -79,9 +82,88 @@ const (
 	MsgTypeDownloadResponse                   // Northbound
 	MsgTypeReflashRequest                     // Southbound via GET
 	MsgTypeReflashResponse                    // Northbound
+	MsgTypeRebootRequest                      // Southbound via GET
+	MsgTypeRebootResponse                     // Northbound EIDCSimpleResponse
+	MsgTypeResetDBRequest                     // Southbound via GET
+	MsgTypeResetDBResponse                    // Northbound EIDCSimpleResponse
+	MsgTypeDefaultConfigRequest               // Southbound via GET
+	MsgTypeDefaultConfigResponse              // Northbound EIDCSimpleResponse
+	MsgTypeVersionRequest                     // Southbound via GET
+	MsgTypeVersionResponse                    // Northbound EIDCBodyResponse
+	MsgTypePointOverrideRequest               // Southbound via POST
+	MsgTypePointOverrideResponse              // Northbound EIDCSimpleResponse
+	MsgTypeUploadRequest                      // Southbound via POST
+	MsgTypeUploadResponse                     // Northbound EIDCSimpleResponse
+	MsgTypeGetCardFormatRequest               // Southbound via GET
+	MsgTypeGetCardFormatResponse              // Northbound EIDCBodyResponse
+	MsgTypeSetCardFormatRequest               // Southbound via POST
+	MsgTypeSetCardFormatResponse              // Northbound EIDCSimpleResponse
+	MsgTypeGetOutboundStatusRequest           // Southbound via GET
+	MsgTypeGetOutboundStatusResponse          // Northbound EIDCBodyResponse
+	MsgTypeGetWebEnableRequest                // Southbound via GET
+	MsgTypeGetWebEnableResponse               // Northbound EIDCBodyResponse
+	MsgTypeHostedModeRequest                  // Southbound via GET
+	MsgTypeHostedModeResponse                 // Northbound EIDCBodyResponse
+	MsgTypeSinglePointStatusRequest           // Southbound via POST
+	MsgTypeSinglePointStatusResponse          // Northbound EIDCBodyResponse
+	MsgTypeSetFtpUserRequest                  // Southbound via POST
+	MsgTypeSetFtpUserResponse                 // Northbound EIDCSimpleResponse
+	MsgTypeSchedMetricsRequest                // Southbound via GET
+	MsgTypeSchedMetricsResponse               // Northbound EIDCBodyResponse
+	MsgTypeEvent0x2fReceiverRequest           // Southbound via GET
+	MsgTypeEvent0x2fReceiverResponse          // Northbound EIDCSimpleResponse
+	MsgTypeDeleteCardsRequest                 // Southbound via POST
+	MsgTypeDeleteCardsResponse                // Northbound EIDCSimpleResponse
+	MsgTypeDeleteFormatsRequest               // Southbound via GET
+	MsgTypeDeleteFormatsResponse              // Northbound EIDCSimpleResponse
+	MsgTypeDeleteHolidaysRequest              // Southbound via GET
+	MsgTypeDeleteHolidaysResponse             // Northbound EIDCSimpleResponse
+	MsgTypeDeletePointsRequest                // Southbound via POST
+	MsgTypeDeletePointsResponse               // Northbound EIDCSimpleResponse
+	MsgTypeDeletePrivilegesRequest            // Southbound via POST
+	MsgTypeDeletePrivilegesResponse           // Northbound EIDCSimpleResponse
+	MsgTypeDeleteSchedulesRequest             // Southbound via GET
+	MsgTypeDeleteSchedulesResponse            // Northbound EIDCSimpleResponse
+	MsgTypeGetPointsRequest                   // Southbound via GET
+	MsgTypeGetPointsResponse                  // Northbound EIDCBodyResponse
+	MsgTypeGetFormatsRequest                  // Southbound via GET
+	MsgTypeGetFormatsResponse                 // Northbound EIDCBodyResponse
+	MsgTypeGetPrivilegesRequest               // Southbound via GET
+	MsgTypeGetPrivilegesResponse              // Northbound EIDCBodyResponse
+	MsgTypeGetSchedulesRequest                // Southbound via GET
+	MsgTypeGetSchedulesResponse               // Northbound EIDCBodyResponse
+	MsgTypeGetHolidaysRequest                 // Southbound via GET
+	MsgTypeGetHolidaysResponse                // Northbound EIDCBodyResponse
+	MsgTypeGetConfigKeyRequest                // Southbound via GET
+	MsgTypeGetConfigKeyResponse               // Northbound EIDCBodyResponse
+	MsgTypeGetDeviceIDRequest                 // Southbound via GET
+	MsgTypeGetDeviceIDResponse                // Northbound EIDCBodyResponse
+	MsgTypeEIDCError                          // Northbound EIDCErrorsResponse to any command
+	msgTypeCount                              // not a type, add new types above
 )
 
 type MsgType int
+
+// AllMsgTypes returns every defined MsgType, in order, excluding
+// MsgTypeUnknown.
+func AllMsgTypes() []MsgType {
+	var result []MsgType
+	for t := MsgTypeUnknown + 1; t < msgTypeCount; t++ {
+		result = append(result, t)
+	}
+	return result
+}
+
+// msgTypeNamed returns the MsgType whose String() is name, or MsgTypeUnknown.
+func msgTypeNamed(name string) MsgType {
+	for _, t := range AllMsgTypes() {
+		if t.String() == name {
+			return t
+		}
+	}
+	return MsgTypeUnknown
+}
+
 type Direction bool
 type Message struct {
 	direction Direction
@@ -92,6 +174,7 @@ type Message struct {
 	origBytes []byte
 	Injected  bool
 	Dropped   bool
+	modified  bool // changed by a mangler since it was read, see Session.SetVerbatim()
 	lock      *sync.Mutex
 }
 
@@ -103,11 +186,130 @@ func (o Message) Send(s *Session) {
 	s.Inject(o, nil)
 }
 
+// Clone returns a deep copy of the message: its request or response headers,
+// URL and body are copied, so changes to the clone don't show up in the
+// original. Copying a Message by value isn't enough for that, because the
+// copies share *http.Request, *http.Response and the Body slice. That matters
+// to manglers: the *Message a mangler modifies in place is the one later
+// handed to the session's Pager and on to subscribers, so a mangler which
+// wants to work on a modified copy (say, to inject it) without changing what
+// subscribers see should Clone() first.
+func (o *Message) Clone() *Message {
+	clone := *o
+	clone.lock = &sync.Mutex{}
+	clone.Body = append([]byte(nil), o.Body...)
+	clone.origBytes = append([]byte(nil), o.origBytes...)
+
+	if o.Request != nil {
+		clone.Request = o.Request.Clone(o.Request.Context())
+	}
+
+	if o.Response != nil {
+		resp := *o.Response
+		resp.Header = o.Response.Header.Clone()
+		resp.Trailer = o.Response.Trailer.Clone()
+		resp.TransferEncoding = append([]string(nil), o.Response.TransferEncoding...)
+		clone.Response = &resp
+	}
+
+	return &clone
+}
+
+// SetBody replaces the message body with b, and fixes up ContentLength and
+// the Content-Length header of the request or response to match. Manglers
+// which rewrite bodies should use it rather than setting Body directly. If
+// the message type was already determined, it's worked out again in case the
+// new body carries a different command.
+func (o *Message) SetBody(b []byte) {
+	o.lock.Lock()
+	o.Body = b
+	o.modified = true
+	switch {
+	case o.Request != nil:
+		o.Request.ContentLength = int64(len(b))
+		o.Request.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	case o.Response != nil:
+		o.Response.ContentLength = int64(len(b))
+		o.Response.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	}
+	o.lock.Unlock()
+
+	if o.Type != 0 {
+		o.Type = 0
+		o.Type = o.GetType()
+	}
+}
+
+// ErrNotHTTP is returned by ReadMsg when its input is neither an HTTP request
+// nor an HTTP response.
+type ErrNotHTTP struct{}
+
+func (o *ErrNotHTTP) Error() string {
+	return "data submitted to ReadMsg neither a request nor response"
+}
+
+// ErrMalformedHeader is returned by ReadMsg when the start line or headers of
+// an HTTP message can't be parsed. Err is the error from net/http.
+type ErrMalformedHeader struct {
+	Err error
+}
+
+func (o *ErrMalformedHeader) Error() string {
+	return fmt.Sprintf("malformed HTTP message header - %s", o.Err)
+}
+
+func (o *ErrMalformedHeader) Unwrap() error {
+	return o.Err
+}
+
+// ErrTruncatedBody is returned by ReadMsg when an HTTP message's body is
+// shorter than its Content-Length (or chunked encoding) says it should be.
+type ErrTruncatedBody struct {
+	ContentLength int64 // -1 when the body is chunked
+	Err           error
+}
+
+func (o *ErrTruncatedBody) Error() string {
+	if o.ContentLength < 0 {
+		return fmt.Sprintf("truncated chunked HTTP message body - %s", o.Err)
+	}
+	return fmt.Sprintf("HTTP message body shorter than Content-Length %d - %s", o.ContentLength, o.Err)
+}
+
+func (o *ErrTruncatedBody) Unwrap() error {
+	return o.Err
+}
+
+// ErrMalformedBody is returned by ReadMsg when an HTTP message's body can't
+// be read for any reason other than truncation, e.g. bad chunked encoding.
+type ErrMalformedBody struct {
+	Err error
+}
+
+func (o *ErrMalformedBody) Error() string {
+	return fmt.Sprintf("malformed HTTP message body - %s", o.Err)
+}
+
+func (o *ErrMalformedBody) Unwrap() error {
+	return o.Err
+}
+
+// bodyError classifies an error encountered while reading a message body of
+// length contentLength.
+func bodyError(err error, contentLength int64) error {
+	if err == io.ErrUnexpectedEOF {
+		return &ErrTruncatedBody{ContentLength: contentLength, Err: err}
+	}
+	return &ErrMalformedBody{Err: err}
+}
+
 // ReadMsg parses a byte slice containing an entire HTTP message including
 // headers and body. It also takes a direction. ReadMsg returns a *Message
 // with appropriate fields populated. Note that the Message structure contains
 // both *http.Request and *http.Response elements. Exactly one of these will be
-// populated, depending on what's found in the []byte.
+// populated, depending on what's found in the []byte. Errors are one of
+// *ErrNotHTTP, *ErrMalformedHeader, *ErrTruncatedBody or *ErrMalformedBody,
+// none of which affect messages which follow in the same stream.
 func ReadMsg(in []byte, dir Direction) (*Message, error) {
 	var err error
 	msg := &Message{
@@ -119,27 +321,27 @@ func ReadMsg(in []byte, dir Direction) (*Message, error) {
 	case isRequest(in):
 		msg.Request, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(in)))
 		if err != nil {
-			return msg, err
+			return msg, &ErrMalformedHeader{Err: err}
 		}
-		if msg.Request.ContentLength > 0 {
+		if msg.Request.ContentLength > 0 || len(msg.Request.TransferEncoding) > 0 {
 			msg.Body, err = ioutil.ReadAll(msg.Request.Body)
 			if err != nil {
-				return msg, err
+				return msg, bodyError(err, msg.Request.ContentLength)
 			}
 		}
 	case isResponse(in):
 		msg.Response, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(in)), nil)
 		if err != nil {
-			return msg, err
+			return msg, &ErrMalformedHeader{Err: err}
 		}
-		if msg.Response.ContentLength > 0 {
+		if msg.Response.ContentLength > 0 || len(msg.Response.TransferEncoding) > 0 {
 			msg.Body, err = ioutil.ReadAll(msg.Response.Body)
 			if err != nil {
-				return msg, err
+				return msg, bodyError(err, msg.Response.ContentLength)
 			}
 		}
 	default:
-		return msg, errors.New("data submitted to ReadMsg neither a request nor response")
+		return msg, &ErrNotHTTP{}
 	}
 	msg.Type = msg.GetType()
 	return msg, nil
@@ -321,12 +523,140 @@ func (o MsgType) String() string {
 		return "Reflash Request"
 	case MsgTypeReflashResponse:
 		return "Reflash Response"
+	case MsgTypeRebootRequest:
+		return "Reboot Request"
+	case MsgTypeRebootResponse:
+		return "Reboot Response"
+	case MsgTypeResetDBRequest:
+		return "ResetDB Request"
+	case MsgTypeResetDBResponse:
+		return "ResetDB Response"
+	case MsgTypeDefaultConfigRequest:
+		return "DefaultConfig Request"
+	case MsgTypeDefaultConfigResponse:
+		return "DefaultConfig Response"
+	case MsgTypeVersionRequest:
+		return "Version Request"
+	case MsgTypeVersionResponse:
+		return "Version Response"
+	case MsgTypePointOverrideRequest:
+		return "PointOverride Request"
+	case MsgTypePointOverrideResponse:
+		return "PointOverride Response"
+	case MsgTypeUploadRequest:
+		return "Upload Request"
+	case MsgTypeUploadResponse:
+		return "Upload Response"
+	case MsgTypeGetCardFormatRequest:
+		return "GetCardFormat Request"
+	case MsgTypeGetCardFormatResponse:
+		return "GetCardFormat Response"
+	case MsgTypeSetCardFormatRequest:
+		return "SetCardFormat Request"
+	case MsgTypeSetCardFormatResponse:
+		return "SetCardFormat Response"
+	case MsgTypeGetOutboundStatusRequest:
+		return "GetOutboundStatus Request"
+	case MsgTypeGetOutboundStatusResponse:
+		return "GetOutboundStatus Response"
+	case MsgTypeGetWebEnableRequest:
+		return "GetWebEnable Request"
+	case MsgTypeGetWebEnableResponse:
+		return "GetWebEnable Response"
+	case MsgTypeHostedModeRequest:
+		return "HostedMode Request"
+	case MsgTypeHostedModeResponse:
+		return "HostedMode Response"
+	case MsgTypeSinglePointStatusRequest:
+		return "SinglePointStatus Request"
+	case MsgTypeSinglePointStatusResponse:
+		return "SinglePointStatus Response"
+	case MsgTypeSetFtpUserRequest:
+		return "SetFtpUser Request"
+	case MsgTypeSetFtpUserResponse:
+		return "SetFtpUser Response"
+	case MsgTypeSchedMetricsRequest:
+		return "SchedMetrics Request"
+	case MsgTypeSchedMetricsResponse:
+		return "SchedMetrics Response"
+	case MsgTypeEvent0x2fReceiverRequest:
+		return "Event/Receiver Request"
+	case MsgTypeEvent0x2fReceiverResponse:
+		return "Event/Receiver Response"
+	case MsgTypeDeleteCardsRequest:
+		return "DeleteCards Request"
+	case MsgTypeDeleteCardsResponse:
+		return "DeleteCards Response"
+	case MsgTypeDeleteFormatsRequest:
+		return "DeleteFormats Request"
+	case MsgTypeDeleteFormatsResponse:
+		return "DeleteFormats Response"
+	case MsgTypeDeleteHolidaysRequest:
+		return "DeleteHolidays Request"
+	case MsgTypeDeleteHolidaysResponse:
+		return "DeleteHolidays Response"
+	case MsgTypeDeletePointsRequest:
+		return "DeletePoints Request"
+	case MsgTypeDeletePointsResponse:
+		return "DeletePoints Response"
+	case MsgTypeDeletePrivilegesRequest:
+		return "DeletePrivileges Request"
+	case MsgTypeDeletePrivilegesResponse:
+		return "DeletePrivileges Response"
+	case MsgTypeDeleteSchedulesRequest:
+		return "DeleteSchedules Request"
+	case MsgTypeDeleteSchedulesResponse:
+		return "DeleteSchedules Response"
+	case MsgTypeGetPointsRequest:
+		return "GetPoints Request"
+	case MsgTypeGetPointsResponse:
+		return "GetPoints Response"
+	case MsgTypeGetFormatsRequest:
+		return "GetFormats Request"
+	case MsgTypeGetFormatsResponse:
+		return "GetFormats Response"
+	case MsgTypeGetPrivilegesRequest:
+		return "GetPrivileges Request"
+	case MsgTypeGetPrivilegesResponse:
+		return "GetPrivileges Response"
+	case MsgTypeGetSchedulesRequest:
+		return "GetSchedules Request"
+	case MsgTypeGetSchedulesResponse:
+		return "GetSchedules Response"
+	case MsgTypeGetHolidaysRequest:
+		return "GetHolidays Request"
+	case MsgTypeGetHolidaysResponse:
+		return "GetHolidays Response"
+	case MsgTypeGetConfigKeyRequest:
+		return "GetConfigKey Request"
+	case MsgTypeGetConfigKeyResponse:
+		return "GetConfigKey Response"
+	case MsgTypeGetDeviceIDRequest:
+		return "GetDeviceID Request"
+	case MsgTypeGetDeviceIDResponse:
+		return "GetDeviceID Response"
+	case MsgTypeEIDCError:
+		return "EIDC Error"
 	default:
 		return fmt.Sprintf("Event type %d has no string value", o)
 	}
 
 }
 
+// IsDestructive returns true for message types which reboot, wipe or reflash
+// the eIDC32. These are worth flagging loudly when they show up in a session.
+func (o MsgType) IsDestructive() bool {
+	switch o {
+	case MsgTypeRebootRequest, MsgTypeRebootResponse,
+		MsgTypeResetDBRequest, MsgTypeResetDBResponse,
+		MsgTypeDefaultConfigRequest, MsgTypeDefaultConfigResponse,
+		MsgTypeReflashRequest, MsgTypeReflashResponse:
+		return true
+	default:
+		return false
+	}
+}
+
 func (o Message) contentType() string {
 	switch {
 	case o.Request != nil:
@@ -341,12 +671,13 @@ func (o Message) OrigBytes() []byte {
 	return o.origBytes
 }
 
+// String renders the message the way the proxy writes it on the wire, with
+// impersonation applied (see Session.SetImpersonation()).
 func (o Message) String() (string, error) {
 	b, err := o.Marshal()
 	if err != nil {
 		return "", err
 	}
-	_ = b
 
 	i, err := impersonate(b, o.direction)
 	if err != nil {
@@ -356,6 +687,16 @@ func (o Message) String() (string, error) {
 	return string(i), nil
 }
 
+// RawString renders the message exactly as Go's http library produces it,
+// without impersonation.
+func (o Message) RawString() (string, error) {
+	b, err := o.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 func (o Message) PrintableLines() ([]string, error){
 	now := time.Now().Format("01/02 15:04:05")
 
@@ -392,4 +733,71 @@ func (o Message) PrintableLines() ([]string, error){
 		}
 	}
 	return lines, nil
-}
\ No newline at end of file
+}
+
+// messageJSON is the serialized form of a Message, see MarshalJSON(). The
+// type is carried by name: MsgType values shift whenever types are added.
+type messageJSON struct {
+	Direction string `json:"direction"`
+	TypeName  string `json:"typeName,omitempty"`
+	Bytes     []byte `json:"bytes"`
+	Injected  bool   `json:"injected,omitempty"`
+	Dropped   bool   `json:"dropped,omitempty"`
+}
+
+// MarshalJSON encodes the message's direction, type, flags and original
+// bytes (base64), so messages can be passed between processes, say as
+// NDJSON. Messages built in-process (injected ones) have no original bytes,
+// so their marshaled form is encoded instead.
+func (o Message) MarshalJSON() ([]byte, error) {
+	raw := o.origBytes
+	if len(raw) == 0 {
+		var err error
+		raw, err = o.Marshal()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(messageJSON{
+		Direction: o.direction.String(),
+		TypeName:  o.GetType().String(),
+		Bytes:     raw,
+		Injected:  o.Injected,
+		Dropped:   o.Dropped,
+	})
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON(), rebuilding its
+// Request or Response with ReadMsg().
+func (o *Message) UnmarshalJSON(data []byte) error {
+	var mj messageJSON
+	err := json.Unmarshal(data, &mj)
+	if err != nil {
+		return err
+	}
+
+	var dir Direction
+	switch mj.Direction {
+	case Northbound.String():
+		dir = Northbound
+	case Southbound.String():
+		dir = Southbound
+	default:
+		return fmt.Errorf("unknown message direction '%s'", mj.Direction)
+	}
+
+	msg, err := ReadMsg(mj.Bytes, dir)
+	if err != nil {
+		return err
+	}
+	// ReadMsg() has typed the message from its bytes if it can. Some types
+	// (e.g. IntelliM's responses) depend on what came before in the session,
+	// so fall back on the recorded name.
+	if msg.Type == MsgTypeUnknown {
+		msg.Type = msgTypeNamed(mj.TypeName)
+	}
+	msg.Injected = mj.Injected
+	msg.Dropped = mj.Dropped
+	*o = *msg
+	return nil
+}
@@ -1,8 +1,12 @@
 package eidc32proxy
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"strconv"
 	"testing"
 )
 
@@ -462,6 +466,69 @@ func TestReadMsg(t *testing.T) {
 	}
 }
 
+func TestReadMsgErrors(t *testing.T) {
+	testData := []struct {
+		name  string
+		in    string
+		check func(error) bool
+	}{
+		{
+			name:  "not http",
+			in:    "hello there\r\n\r\n",
+			check: func(err error) bool { _, ok := err.(*ErrNotHTTP); return ok },
+		},
+		{
+			name: "malformed request header",
+			in: "GET /eidc/heartbeat HTTP/1.1\r\n" +
+				"this is not a header\r\n\r\n",
+			check: func(err error) bool { _, ok := err.(*ErrMalformedHeader); return ok },
+		},
+		{
+			name: "malformed response status",
+			in: "HTTP/1.0 OK\r\n" +
+				"Content-Length: 0\r\n\r\n",
+			check: func(err error) bool { _, ok := err.(*ErrMalformedHeader); return ok },
+		},
+		{
+			name: "truncated request body",
+			in: "POST /eidc/setTime HTTP/1.1\r\n" +
+				"Content-Length: 20\r\n\r\n" +
+				`{"time":""}`,
+			check: func(err error) bool {
+				e, ok := err.(*ErrTruncatedBody)
+				return ok && e.ContentLength == 20
+			},
+		},
+		{
+			name: "truncated chunked response body",
+			in: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"10\r\n{\"result\"",
+			check: func(err error) bool {
+				e, ok := err.(*ErrTruncatedBody)
+				return ok && e.ContentLength == -1
+			},
+		},
+		{
+			name: "malformed chunked response body",
+			in: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"zz\r\n{}\r\n0\r\n\r\n",
+			check: func(err error) bool { _, ok := err.(*ErrMalformedBody); return ok },
+		},
+	}
+
+	for _, td := range testData {
+		_, err := ReadMsg([]byte(td.in), Northbound)
+		if err == nil {
+			t.Fatalf("%s: expected an error", td.name)
+		}
+		if !td.check(err) {
+			t.Fatalf("%s: unexpected error type %T: %s", td.name, err, err)
+		}
+	}
+}
+
 func TestMessage_ParseEnableEventsResponse(t *testing.T) {
 	testData := "HTTP/1.0 200 OK\r\n" +
 		"Server: eIDC32 WebServer\r\n" +
@@ -487,3 +554,847 @@ func TestMessage_ParseEnableEventsResponse(t *testing.T) {
 		t.Fatalf("expected 'true', got %s", result.Cmd)
 	}
 }
+
+func TestSouthboundRebootRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/reboot?username=admin&password=admin&seq=12 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeRebootRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestSouthboundResetDBRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/resetdb?username=admin&password=admin&seq=13 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeResetDBRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundRebootResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  31\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"REBOOT"}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeRebootResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundResetDBResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  32\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"RESETDB"}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeResetDBResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestMsgType_IsDestructive(t *testing.T) {
+	destructive := []MsgType{
+		MsgTypeRebootRequest,
+		MsgTypeRebootResponse,
+		MsgTypeResetDBRequest,
+		MsgTypeResetDBResponse,
+		MsgTypeDefaultConfigRequest,
+		MsgTypeDefaultConfigResponse,
+		MsgTypeReflashRequest,
+		MsgTypeReflashResponse,
+	}
+	for _, mt := range destructive {
+		if !mt.IsDestructive() {
+			t.Fatalf("%s should be destructive", mt)
+		}
+	}
+
+	harmless := []MsgType{
+		MsgTypeUnknown,
+		MsgTypeHeartbeatRequest,
+		MsgTypeHeartbeatResponse,
+		MsgTypeDoor0x2fLockStatusRequest,
+		MsgTypeDownloadRequest,
+	}
+	for _, mt := range harmless {
+		if mt.IsDestructive() {
+			t.Fatalf("%s should not be destructive", mt)
+		}
+	}
+}
+
+func TestSouthboundDefaultConfigRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/defaultconfig?username=admin&password=admin&seq=14 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeDefaultConfigRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestSouthboundVersionRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/version?username=admin&password=admin&seq=15 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeVersionRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestSouthboundGetCardFormatRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/getCardFormat?username=admin&password=admin&seq=16 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetCardFormatRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundGetCardFormatResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  68\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"GETCARDFORMAT", "body":{"cardFormat":"long"}}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetCardFormatResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	gcfr, err := msg.ParseGetCardFormatResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcfr.CardFormat != "long" {
+		t.Fatalf("expected card format long, got %s", gcfr.CardFormat)
+	}
+}
+
+func TestSouthboundSetCardFormatRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"POST /eidc/setCardFormat?username=admin&password=admin&seq=17 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Length: 21\r\n\r\n" +
+			`{"cardFormat":"long"}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSetCardFormatRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	scfr, err := msg.ParseSetCardFormatRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scfr.CardFormat != "long" {
+		t.Fatalf("expected card format long, got %s", scfr.CardFormat)
+	}
+}
+
+func TestNorthboundSetCardFormatResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  38\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"SETCARDFORMAT"}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSetCardFormatResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestSouthboundGetOutboundStatusRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/getOutboundStatus?username=admin&password=admin&seq=18 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetOutboundStatusRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundGetOutboundStatusResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  164\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"GETOUTBOUNDSTATUS", "body":{"connected":1,"activeHost":"192.168.6.10","activePort":18800,"activeSsl":1,"retryCount":3,"lastError":"timeout"}}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetOutboundStatusResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	gosr, err := msg.ParseGetOutboundStatusResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gosr.Connected != 1 || gosr.ActiveHost != "192.168.6.10" || gosr.ActivePort != 18800 ||
+		gosr.ActiveSsl != 1 || gosr.RetryCount != 3 || gosr.LastError != "timeout" {
+		t.Fatalf("unexpected GetOutboundStatusResponse: %+v", gosr)
+	}
+}
+
+func TestSouthboundGetWebEnableRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/getWebEnable?username=admin&password=admin&seq=19 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetWebEnableRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundGetWebEnableResponse(t *testing.T) {
+	testData := []struct {
+		body     string
+		expected bool
+	}{
+		{body: `{"enabled":1}`, expected: true},
+		{body: `{"enabled":0}`, expected: false},
+		{body: `{"enabled":true}`, expected: true},
+		{body: `{"enabled":"off"}`, expected: false},
+	}
+
+	for _, td := range testData {
+		payload := fmt.Sprintf(`{"result":true, "cmd":"GETWEBENABLE", "body":%s}`, td.body)
+		testData := fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+			"Server: eIDC32 WebServer\r\n"+
+			"Content-type: application/json\r\n"+
+			"Content-Length:  %d\r\n"+
+			"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload)
+
+		msg, err := ReadMsg([]byte(testData), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := msg.GetType()
+		expected := MsgTypeGetWebEnableResponse
+		if result != expected {
+			t.Fatalf("expected %s, got %s", expected, result)
+		}
+
+		value, err := msg.ParseGetWebEnableResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != td.expected {
+			t.Fatalf("%s: expected %t, got %t", td.body, td.expected, value)
+		}
+	}
+}
+
+func TestSouthboundHostedModeRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/hostedMode?username=admin&password=admin&seq=20 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeHostedModeRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundHostedModeResponse(t *testing.T) {
+	testData := []struct {
+		body     string
+		expected bool
+	}{
+		{body: `{"hostedMode":1}`, expected: true},
+		{body: `{"hostedMode":false}`, expected: false},
+		{body: `{"hostedMode":"Yes"}`, expected: true},
+	}
+
+	for _, td := range testData {
+		payload := fmt.Sprintf(`{"result":true, "cmd":"HOSTEDMODE", "body":%s}`, td.body)
+		testData := fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+			"Server: eIDC32 WebServer\r\n"+
+			"Content-type: application/json\r\n"+
+			"Content-Length:  %d\r\n"+
+			"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload)
+
+		msg, err := ReadMsg([]byte(testData), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := msg.GetType()
+		expected := MsgTypeHostedModeResponse
+		if result != expected {
+			t.Fatalf("expected %s, got %s", expected, result)
+		}
+
+		value, err := msg.ParseHostedModeResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != td.expected {
+			t.Fatalf("%s: expected %t, got %t", td.body, td.expected, value)
+		}
+	}
+}
+
+func TestSouthboundSinglePointStatusRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"POST /eidc/singlePointStatus?username=admin&password=admin&seq=21 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Length: 14\r\n\r\n" +
+			`{"pointId":38}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSinglePointStatusRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	spsr, err := msg.ParseSinglePointStatusRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spsr.PointId != 38 {
+		t.Fatalf("expected point 38, got %d", spsr.PointId)
+	}
+}
+
+func TestNorthboundSinglePointStatusResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  76\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"SINGLEPOINTSTATUS", "body":{"pointId":38,"status":1}}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSinglePointStatusResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	spsr, err := msg.ParseSinglePointStatusResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spsr.PointID != 38 || spsr.Status != 1 {
+		t.Fatalf("unexpected SinglePointStatusResponse: %+v", spsr)
+	}
+}
+
+func TestSouthboundSchedMetricsRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/schedMetrics?username=admin&password=admin&seq=22 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSchedMetricsRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundSchedMetricsResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  93\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"SCHEDMETRICS", "body":{"schedules":4,"holidays":2,"evaluations":1234}}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeSchedMetricsResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	smr, err := msg.ParseSchedMetricsResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(smr) != 3 || smr["schedules"] != 4 || smr["holidays"] != 2 || smr["evaluations"] != 1234 {
+		t.Fatalf("unexpected SchedMetricsResponse: %v", smr)
+	}
+}
+
+func TestSouthboundEvent0x2fReceiverRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/event/receiver?username=admin&password=admin&seq=23 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeEvent0x2fReceiverRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestNorthboundEvent0x2fReceiverResponse(t *testing.T) {
+	testDir := Northbound
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  39\r\n" +
+			"Cache-Control: no-cache\r\n\r\n" +
+			`{"result":true, "cmd":"EVENT/RECEIVER"}`
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeEvent0x2fReceiverResponse
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+
+	ok, err := msg.ParseEvent0x2fReceiverResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected a true result")
+	}
+
+	// the slash must not be mistaken for DOOR/LOCKSTATUS or anything else
+	for _, cmd := range []string{"EVENT", "RECEIVER", "EVENT/RECEIVERS", "event/receiver"} {
+		msg.Body = []byte(fmt.Sprintf(`{"result":true, "cmd":"%s"}`, cmd))
+		if msg.getNorthboundResponseType() != MsgTypeUnknown {
+			t.Fatalf("cmd %s shouldn't be recognized, got %s", cmd, msg.getNorthboundResponseType())
+		}
+	}
+}
+
+func TestNorthboundDeleteResponses(t *testing.T) {
+	testData := map[string]MsgType{
+		DeleteCardsResponseCmd:      MsgTypeDeleteCardsResponse,
+		DeleteFormatsResponseCmd:    MsgTypeDeleteFormatsResponse,
+		DeleteHolidaysResponseCmd:   MsgTypeDeleteHolidaysResponse,
+		DeletePointsResponseCmd:     MsgTypeDeletePointsResponse,
+		DeletePrivilegesResponseCmd: MsgTypeDeletePrivilegesResponse,
+		DeleteSchedulesResponseCmd:  MsgTypeDeleteSchedulesResponse,
+	}
+
+	for cmd, expected := range testData {
+		payload := fmt.Sprintf(`{"result":true, "cmd":"%s"}`, cmd)
+		testData := fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+			"Server: eIDC32 WebServer\r\n"+
+			"Content-type: application/json\r\n"+
+			"Content-Length:  %d\r\n"+
+			"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload)
+
+		msg, err := ReadMsg([]byte(testData), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := msg.GetType()
+		if result != expected {
+			t.Fatalf("%s: expected %s, got %s", cmd, expected, result)
+		}
+	}
+}
+
+func TestSouthboundGetPointsRequest(t *testing.T) {
+	testDir := Southbound
+	testData :=
+		"GET /eidc/getPoints?username=admin&password=admin&seq=29 HTTP/1.1\r\n" +
+			"Host: 192.168.6.40\r\n" +
+			"User-Agent: eIDCListener\r\n\r\n\r\n"
+
+	msg, err := ReadMsg([]byte(testData), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := msg.GetType()
+	expected := MsgTypeGetPointsRequest
+	if result != expected {
+		t.Fatalf("expected %s, got %s", expected, result)
+	}
+}
+
+func TestAllMsgTypes(t *testing.T) {
+	types := AllMsgTypes()
+	if len(types) == 0 || types[0] != MsgTypeConnectedRequest {
+		t.Fatalf("expected types to begin with %s", MsgTypeConnectedRequest)
+	}
+
+	seen := make(map[string]MsgType)
+	for _, mt := range types {
+		if mt == MsgTypeUnknown {
+			t.Fatalf("%s shouldn't be in the list", mt)
+		}
+		name := mt.String()
+		if name == fmt.Sprintf("Event type %d has no string value", mt) {
+			t.Fatalf("MsgType %d has no string value", mt)
+		}
+		if other, ok := seen[name]; ok {
+			t.Fatalf("MsgTypes %d and %d are both named %q", other, mt, name)
+		}
+		seen[name] = mt
+	}
+}
+
+func TestMessage_Clone(t *testing.T) {
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb.Body = []byte("original")
+
+	resp, err := ReadMsg(eidcResponseBytes(HeartbeatResponseCmd, ""), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, original := range []*Message{hb, resp} {
+		before, err := original.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clone := original.Clone()
+		clone.Body[0] = 'X'
+		if clone.Request != nil {
+			clone.Request.Header.Set(ua, "clone")
+			clone.Request.URL.RawQuery = "seq=99"
+		}
+		if clone.Response != nil {
+			clone.Response.Header.Set(contentType, "clone")
+			clone.Response.StatusCode = http.StatusTeapot
+		}
+
+		after, err := original.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Fatalf("modifying the clone changed the original:\n%q\n%q", before, after)
+		}
+
+		cloned, err := clone.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(before, cloned) {
+			t.Fatal("expected the modified clone to differ from the original")
+		}
+	}
+}
+
+func TestMessage_SetBody(t *testing.T) {
+	resp, err := ReadMsg(eidcResponseBytes(HeartbeatResponseCmd, ""), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewEventAckMsg("admin", "admin", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Type = req.GetType()
+
+	testData := []struct {
+		msg      *Message
+		body     string
+		expected MsgType
+	}{
+		{msg: resp, body: `{"result":true, "cmd":"ENABLEEVENTS", "extra":"making it longer"}`, expected: MsgTypeEnableEventsResponse},
+		{msg: req, body: `{"eventIds":[894, 895, 896, 897]}`, expected: MsgTypeEventAckRequest},
+	}
+
+	for _, td := range testData {
+		td.msg.SetBody([]byte(td.body))
+		if td.msg.Type != td.expected {
+			t.Fatalf("expected type %s after SetBody, got %s", td.expected, td.msg.Type)
+		}
+
+		raw, err := td.msg.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ReadMsg(raw, td.msg.Direction())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(parsed.Body) != td.body {
+			t.Fatalf("expected body %q, got %q", td.body, parsed.Body)
+		}
+
+		var header string
+		if parsed.Request != nil {
+			header = parsed.Request.Header.Get("Content-Length")
+		} else {
+			header = parsed.Response.Header.Get("Content-Length")
+		}
+		if header != strconv.Itoa(len(td.body)) {
+			t.Fatalf("expected Content-Length %d, got %q", len(td.body), header)
+		}
+		if parsed.Type != td.expected {
+			t.Fatalf("expected %s, got %s", td.expected, parsed.Type)
+		}
+	}
+}
+
+func TestMessage_JSON(t *testing.T) {
+	resp, err := ReadMsg(eidcResponseBytes(EnableEventsResponseCmd, ""), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Dropped = true
+
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb.Type = MsgTypeHeartbeatRequest
+	hb.Injected = true
+
+	for _, original := range []*Message{resp, hb} {
+		data, err := json.Marshal(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := &Message{}
+		err = json.Unmarshal(data, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Direction() != original.Direction() {
+			t.Fatalf("expected direction %s, got %s", original.Direction(), result.Direction())
+		}
+		if result.Type != original.Type {
+			t.Fatalf("expected type %s, got %s", original.Type, result.Type)
+		}
+		if result.Injected != original.Injected || result.Dropped != original.Dropped {
+			t.Fatalf("flags didn't survive the round trip: %s", data)
+		}
+
+		expected, err := original.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := result.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, got) {
+			t.Fatalf("expected:\n%q\ngot:\n%q", expected, got)
+		}
+	}
+
+	err = json.Unmarshal([]byte(`{"direction":"Sideways","bytes":""}`), &Message{})
+	if err == nil {
+		t.Fatal("expected an error for an unknown direction")
+	}
+
+	// the type comes from the bytes where possible, otherwise from the name
+	untyped := []byte("HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 2\r\n\r\n{}")
+	hbBytes, err := hb.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData := []struct {
+		dir      Direction
+		typeName string
+		raw      []byte
+		expected MsgType
+	}{
+		{dir: Southbound, typeName: MsgTypeConnectedResponse.String(), raw: untyped, expected: MsgTypeConnectedResponse},
+		{dir: Southbound, typeName: MsgTypeConnectedResponse.String(), raw: hbBytes, expected: MsgTypeHeartbeatRequest},
+		{dir: Southbound, typeName: "no such type", raw: untyped, expected: MsgTypeUnknown},
+	}
+	for _, td := range testData {
+		data, err := json.Marshal(messageJSON{Direction: td.dir.String(), TypeName: td.typeName, Bytes: td.raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := &Message{}
+		err = json.Unmarshal(data, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Type != td.expected {
+			t.Fatalf("expected type %s, got %s", td.expected, result.Type)
+		}
+	}
+}
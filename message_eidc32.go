@@ -1,9 +1,11 @@
 package eidc32proxy
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -36,70 +38,69 @@ const (
 //
 //	{"result":true, "cmd":"SETTIME"}
 const (
-	Door0x2fLockStatusResponseCmd = "DOOR/LOCKSTATUS"  // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a Door0x2fLockStatusResponse)
-	EnableEventsResponseCmd       = "ENABLEEVENTS"     // sent as the "cmd" field in an EIDCSimpleResponse
-	EventAckResponseCmd           = "EVENTACK"         // sent as the "cmd" field in an EIDCSimpleResponse
-	GetoutboundResponseCmd        = "GETOUTBOUND"      // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetOutboundResponse)
-	GetPointStatusResponseCmd     = "GETPOINTSTATUS"   // sent as the "cmd" field in an EIDCSimpleResponse (followed by upstream POSTs to /eidc/pointStatus)
-	HeartbeatResponseCmd          = "HEARTBEAT"        // sent as the "cmd" field in an EIDCSimpleResponse
-	SetTimeResponseCmd            = "SETTIME"          // sent as the "cmd" field in an EIDCSimpleResponse
-	SetWebUserResponseCmd         = "SETWEBUSER"       // sent as the "cmd" field in an EIDCSimpleResponse
-	SetOutboundResponseCmd        = "SETOUTBOUND"      // sent as the "cmd" field in an EIDCSimpleResponse
-	ResetEventsResponseCmd        = "RESETEVENTS"      // sent as the "cmd" field in an EIDCSimpleResponse
-	ClearPointsResponseCmd        = "CLEARPOINTS"      // sent as the "cmd" field in an EIDCSimpleResponse
-	ResetPointEngineResponseCmd   = "RESETPOINTENGINE" // sent as the "cmd" field in an EIDCSimpleResponse
-	AddFormatsResponseCmd         = "ADDFORMATS"       // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a AddFormatsResponse)
-	ClearSchedulesResponseCmd     = "CLEARSCHEDULES"   // sent as the "cmd" field in an EIDCSimpleResponse
-	AddSchedulesResponseCmd       = "ADDSCHEDULES"     // sent as the "cmd" field in an EIDCSimpleResponse
-	ClearPrivilegesResponseCmd    = "CLEARPRIVILEGES"  // sent as the "cmd" field in an EIDCSimpleResponse
-	AddPrivilegesResponseCmd      = "ADDPRIVILEGES"    // sent as the "cmd" field in an EIDCSimpleResponse
-	ClearCardsResponseCmd         = "CLEARCARDS"       // sent as the "cmd" field in an EIDCSimpleResponse
-	SetConfigKeyResponseCmd       = "SETCONFIGKEY"     // sent as the "cmd" field in an EIDCSimpleResponse
-	ClearHolidaysResponseCmd      = "CLEARHOLIDAYS"    // sent as the "cmd" field in an EIDCSimpleResponse
-	DownloadResponseCmd           = "DOWNLOAD"         // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a DownloadResponse)
-	ReflashResponseCmd            = "REFLASH"          // sent as the "cmd" field in an EIDCSimpleResponse
-	SetDeviceIDResponseCmd        = "SETDEVICEID"      // sent as the "cmd" field in an EIDCSimpleResponse
-	AddCardsResponseCmd           = "ADDCARDS"         // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a AddCardsResponse)
-	AddPointsResponseCmd          = "ADDPOINTS"        // sent as the "cmd" field in an EIDCSimpleResponse
+	Door0x2fLockStatusResponseCmd = "DOOR/LOCKSTATUS"   // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a Door0x2fLockStatusResponse)
+	EnableEventsResponseCmd       = "ENABLEEVENTS"      // sent as the "cmd" field in an EIDCSimpleResponse
+	EventAckResponseCmd           = "EVENTACK"          // sent as the "cmd" field in an EIDCSimpleResponse
+	GetoutboundResponseCmd        = "GETOUTBOUND"       // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetOutboundResponse)
+	GetPointStatusResponseCmd     = "GETPOINTSTATUS"    // sent as the "cmd" field in an EIDCSimpleResponse (followed by upstream POSTs to /eidc/pointStatus)
+	HeartbeatResponseCmd          = "HEARTBEAT"         // sent as the "cmd" field in an EIDCSimpleResponse
+	SetTimeResponseCmd            = "SETTIME"           // sent as the "cmd" field in an EIDCSimpleResponse
+	SetWebUserResponseCmd         = "SETWEBUSER"        // sent as the "cmd" field in an EIDCSimpleResponse
+	SetOutboundResponseCmd        = "SETOUTBOUND"       // sent as the "cmd" field in an EIDCSimpleResponse
+	ResetEventsResponseCmd        = "RESETEVENTS"       // sent as the "cmd" field in an EIDCSimpleResponse
+	ClearPointsResponseCmd        = "CLEARPOINTS"       // sent as the "cmd" field in an EIDCSimpleResponse
+	ResetPointEngineResponseCmd   = "RESETPOINTENGINE"  // sent as the "cmd" field in an EIDCSimpleResponse
+	AddFormatsResponseCmd         = "ADDFORMATS"        // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a AddFormatsResponse)
+	ClearSchedulesResponseCmd     = "CLEARSCHEDULES"    // sent as the "cmd" field in an EIDCSimpleResponse
+	AddSchedulesResponseCmd       = "ADDSCHEDULES"      // sent as the "cmd" field in an EIDCSimpleResponse
+	ClearPrivilegesResponseCmd    = "CLEARPRIVILEGES"   // sent as the "cmd" field in an EIDCSimpleResponse
+	AddPrivilegesResponseCmd      = "ADDPRIVILEGES"     // sent as the "cmd" field in an EIDCSimpleResponse
+	ClearCardsResponseCmd         = "CLEARCARDS"        // sent as the "cmd" field in an EIDCSimpleResponse
+	SetConfigKeyResponseCmd       = "SETCONFIGKEY"      // sent as the "cmd" field in an EIDCSimpleResponse
+	ClearHolidaysResponseCmd      = "CLEARHOLIDAYS"     // sent as the "cmd" field in an EIDCSimpleResponse
+	DownloadResponseCmd           = "DOWNLOAD"          // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a DownloadResponse)
+	ReflashResponseCmd            = "REFLASH"           // sent as the "cmd" field in an EIDCSimpleResponse
+	SetDeviceIDResponseCmd        = "SETDEVICEID"       // sent as the "cmd" field in an EIDCSimpleResponse
+	AddCardsResponseCmd           = "ADDCARDS"          // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a AddCardsResponse)
+	AddPointsResponseCmd          = "ADDPOINTS"         // sent as the "cmd" field in an EIDCSimpleResponse
+	RebootResponseCmd             = "REBOOT"            // sent as the "cmd" field in an EIDCSimpleResponse
+	ResetDBResponseCmd            = "RESETDB"           // sent as the "cmd" field in an EIDCSimpleResponse
+	DefaultConfigResponseCmd      = "DEFAULTCONFIG"     // sent as the "cmd" field in an EIDCSimpleResponse
+	VersionResponseCmd            = "VERSION"           // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a VersionResponse)
+	PointOverrideResponseCmd      = "POINTOVERRIDE"     // sent as the "cmd" field in an EIDCSimpleResponse
+	UploadResponseCmd             = "UPLOAD"            // sent as the "cmd" field in an EIDCSimpleResponse
+	GetCardFormatResponseCmd      = "GETCARDFORMAT"     // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetCardFormatResponse)
+	SetCardFormatResponseCmd      = "SETCARDFORMAT"     // sent as the "cmd" field in an EIDCSimpleResponse
+	GetOutboundStatusResponseCmd  = "GETOUTBOUNDSTATUS" // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetOutboundStatusResponse)
+	GetWebEnableResponseCmd       = "GETWEBENABLE"      // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetWebEnableResponse)
+	HostedModeResponseCmd         = "HOSTEDMODE"        // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a HostedModeResponse)
+	SinglePointStatusResponseCmd  = "SINGLEPOINTSTATUS" // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a SinglePointStatusResponse)
+	SetFtpUserResponseCmd         = "SETFTPUSER"        // sent as the "cmd" field in an EIDCSimpleResponse
+	SchedMetricsResponseCmd       = "SCHEDMETRICS"      // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a SchedMetricsResponse)
+	Event0x2fReceiverResponseCmd  = "EVENT/RECEIVER"    // sent as the "cmd" field in an EIDCSimpleResponse
+	DeleteCardsResponseCmd        = "DELETECARDS"       // sent as the "cmd" field in an EIDCSimpleResponse
+	DeleteFormatsResponseCmd      = "DELETEFORMATS"     // sent as the "cmd" field in an EIDCSimpleResponse
+	DeleteHolidaysResponseCmd     = "DELETEHOLIDAYS"    // sent as the "cmd" field in an EIDCSimpleResponse
+	DeletePointsResponseCmd       = "DELETEPOINTS"      // sent as the "cmd" field in an EIDCSimpleResponse
+	DeletePrivilegesResponseCmd   = "DELETEPRIVILEGES"  // sent as the "cmd" field in an EIDCSimpleResponse
+	DeleteSchedulesResponseCmd    = "DELETESCHEDULES"   // sent as the "cmd" field in an EIDCSimpleResponse
+	GetPointsResponseCmd          = "GETPOINTS"         // sent as the "cmd" field in an EIDCBodyResponse
+	GetFormatsResponseCmd         = "GETFORMATS"        // sent as the "cmd" field in an EIDCBodyResponse
+	GetPrivilegesResponseCmd      = "GETPRIVILEGES"     // sent as the "cmd" field in an EIDCBodyResponse
+	GetSchedulesResponseCmd       = "GETSCHEDULES"      // sent as the "cmd" field in an EIDCBodyResponse
+	GetHolidaysResponseCmd        = "GETHOLIDAYS"       // sent as the "cmd" field in an EIDCBodyResponse
+	GetConfigKeyResponseCmd       = "GETCONFIGKEY"      // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetConfigKeyResponse)
+	GetDeviceIDResponseCmd        = "GETDEVICEID"       // sent as the "cmd" field in an EIDCBodyResponse (payload also includes a GetDeviceIDResponse)
 	// Other response strings found in firmware image
 	// ADDHOLIDAYS
 	// APBRESET
 	// CARD
 	// CLEARFORMATS
-	// DEFAULTCONFIG
-	// DELETECARDS
-	// DELETEFORMATS
-	// DELETEHOLIDAYS
-	// DELETEPOINTS
-	// DELETEPRIVILEGES
-	// DELETESCHEDULES
-	// EVENT/RECEIVER
 	// FILETEST
-	// GETCARDFORMAT
 	// GETCARDS
-	// GETCONFIGKEY
-	// GETDEVICEID
-	// GETFORMATS
-	// GETHOLIDAYS
-	// GETOUTBOUNDSTATUS
-	// GETPOINTS
-	// GETPRIVILEGES
-	// GETSCHEDULES
 	// GETSITEKEY
 	// GETTIME
-	// GETWEBENABLE
-	// HOSTEDMODE
-	// POINTOVERRIDE
-	// REBOOT
-	// RESETDB
-	// SCHEDMETRICS
-	// SETCARDFORMAT
-	// SETCONFIGKEY
-	// SETFTPUSER
 	// SETSITEKEY
-	// SINGLEPOINTSTATUS
-	// UPLOAD
-	// VERSION
 )
 
 // ConnectedRequest is the payload of eIDC32's
@@ -233,6 +234,67 @@ type DownloadResponse struct {
 	Other    interface{} `json:"-"`
 }
 
+// GetCardFormatResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's getCardFormat command. The format is "short" or "long",
+// like ConnectedRequest.CardFormat.
+type GetCardFormatResponse struct {
+	CardFormat string      `json:"cardFormat"`
+	Other      interface{} `json:"-"`
+}
+
+// GetConfigKeyResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's getConfigKey command. The key is the one set by setConfigKey,
+// like ConnectedRequest.ConfigurationKey.
+type GetConfigKeyResponse struct {
+	ConfigurationKey string      `json:"configurationKey"`
+	Other            interface{} `json:"-"`
+}
+
+// GetDeviceIDResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's getDeviceID command. The ID is the one set by setDeviceID.
+type GetDeviceIDResponse struct {
+	DeviceID int         `json:"deviceID"`
+	Other    interface{} `json:"-"`
+}
+
+// VersionResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's version command.
+type VersionResponse struct {
+	Firmware   string      `json:"firmware"`
+	Bootloader string      `json:"bootloader"`
+	Hardware   string      `json:"hardware"`
+	Other      interface{} `json:"-"`
+}
+
+// GetOutboundStatusResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's getOutboundStatus command. It's the eIDC32's view of its
+// outbound connection: which host it's using and how that's going. Integer
+// flags are 0/1, like GetOutboundResponse.
+type GetOutboundStatusResponse struct {
+	Connected  int         `json:"connected"`
+	ActiveHost string      `json:"activeHost"`
+	ActivePort int         `json:"activePort"`
+	ActiveSsl  int         `json:"activeSsl"`
+	RetryCount int         `json:"retryCount"`
+	LastError  string      `json:"lastError"`
+	Other      interface{} `json:"-"`
+}
+
+// SinglePointStatusResponse is the "body" of a EIDCBodyResponse to
+// Intelli-M's singlePointStatus command. Unlike the batch of PointStatusRequest
+// messages which follow a getPointStatus command, it reports only the point's
+// current status.
+type SinglePointStatusResponse struct {
+	PointID int         `json:"pointId"`
+	Status  int         `json:"status"`
+	Other   interface{} `json:"-"`
+}
+
+// SchedMetricsResponse is the "body" of a EIDCBodyResponse to Intelli-M's
+// schedMetrics command: the eIDC32 scheduler's counters, keyed by name. The
+// names vary between firmware versions, so they're not broken out into fields.
+type SchedMetricsResponse map[string]float64
+
 // isControllerLogin indicates whether an HTTP request is a login attempt from
 // an eIDC32 to its controller software.
 func isControllerLogin(r *http.Request) bool {
@@ -264,6 +326,48 @@ func (o Message) parseEIDCSimpleResponse() (EIDCSimpleResponse, error) {
 	return result, err
 }
 
+// isError returns true if the response reports a failure, rather than just
+// being shaped like an EIDCErrorsResponse.
+func (o EIDCErrorsResponse) isError() bool {
+	errs := bytes.TrimSpace(o.Errors)
+	return !o.Result && len(errs) > 0 && !bytes.Equal(errs, []byte("null"))
+}
+
+// IsEIDCError returns true if the message is an eIDC32 reporting the failure
+// of a command, see ParseEIDCErrorsResponse().
+func (o Message) IsEIDCError() bool {
+	return o.GetType() == MsgTypeEIDCError
+}
+
+// ParseEIDCErrorsResponse parses an eIDC32's failure response (type
+// MsgTypeEIDCError). The Cmd field identifies the failed command, the Errors
+// field holds the device's (unparsed) explanation.
+func (o Message) ParseEIDCErrorsResponse() (EIDCErrorsResponse, error) {
+	var result EIDCErrorsResponse
+	err := json.Unmarshal(o.Body, &result)
+	if err != nil {
+		return result, err
+	}
+	if !result.isError() {
+		return result, fmt.Errorf("not an eIDC32 error response")
+	}
+	return result, nil
+}
+
+// failedResponseType returns the type the response would have had if the
+// command hadn't failed, or MsgTypeUnknown if the message isn't a
+// MsgTypeEIDCError response.
+func (o Message) failedResponseType() MsgType {
+	if o.GetType() != MsgTypeEIDCError {
+		return MsgTypeUnknown
+	}
+	r, err := o.ParseEIDCErrorsResponse()
+	if err != nil {
+		return MsgTypeUnknown
+	}
+	return eidcResponseCmdType(r.Cmd)
+}
+
 func (o Message) parseEIDCBodyResponse() (EIDCBodyResponse, error) {
 	var result EIDCBodyResponse
 	err := json.Unmarshal(o.Body, &result)
@@ -278,7 +382,11 @@ func (o Message) ParseConnectedRequest() (ConnectedRequest, error) {
 
 func (o Message) ParseGetOutboundResponse() (GetOutboundResponse, error) {
 	var result GetOutboundResponse
-	err := json.Unmarshal(o.Body, &result)
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
 	return result, err
 }
 
@@ -305,6 +413,17 @@ func (o Message) ParseDoor0x2fLockStatusResponse() (Door0x2fLockStatusResponse,
 	return result, err
 }
 
+func (o Message) ParseDownloadResponse() (DownloadResponse, error) {
+	var result DownloadResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
 func (o Message) ParseAddFormatsResponse() (AddFormatsResponse, error) {
 	var result AddFormatsResponse
 	var eidcBR EIDCBodyResponse
@@ -327,6 +446,206 @@ func (o Message) ParseAddCardsResponse() (AddCardsResponse, error) {
 	return result, err
 }
 
+func (o Message) ParseGetCardFormatResponse() (GetCardFormatResponse, error) {
+	var result GetCardFormatResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseGetConfigKeyResponse() (GetConfigKeyResponse, error) {
+	var result GetConfigKeyResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseGetDeviceIDResponse() (GetDeviceIDResponse, error) {
+	var result GetDeviceIDResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseVersionResponse() (VersionResponse, error) {
+	var result VersionResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseGetOutboundStatusResponse() (GetOutboundStatusResponse, error) {
+	var result GetOutboundStatusResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseSinglePointStatusResponse() (SinglePointStatusResponse, error) {
+	var result SinglePointStatusResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseSchedMetricsResponse() (SchedMetricsResponse, error) {
+	var result SchedMetricsResponse
+	var eidcBR EIDCBodyResponse
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result, err
+}
+
+// ParseGetPointsResponse returns the points configured on the eIDC32. They
+// look just like the ones sent by IntelliM in an AddPointsRequest.
+func (o Message) ParseGetPointsResponse() ([]NewPoint, error) {
+	var result AddPointsRequest
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result.NewPoints, err
+}
+
+// ParseGetFormatsResponse returns the card formats configured on the eIDC32.
+// We don't know their structure yet, so they're left as raw JSON.
+func (o Message) ParseGetFormatsResponse() ([]json.RawMessage, error) {
+	var result struct {
+		Formats []json.RawMessage `json:"Formats"`
+	}
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result.Formats, err
+}
+
+// ParseGetPrivilegesResponse returns the privileges configured on the eIDC32.
+// They look just like the ones sent by IntelliM in an AddPrivilegesRequest.
+func (o Message) ParseGetPrivilegesResponse() ([]NewPrivilege, error) {
+	var result AddPrivilegesRequest
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result.Privileges, err
+}
+
+// ParseGetSchedulesResponse returns the schedules configured on the eIDC32.
+// They look just like the ones sent by IntelliM in an AddSchedulesRequest.
+func (o Message) ParseGetSchedulesResponse() ([]Schedule, error) {
+	var result AddSchedulesRequest
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result.Schedules, err
+}
+
+// ParseGetHolidaysResponse returns the holidays configured on the eIDC32.
+// We don't know their structure yet, so they're left as raw JSON.
+func (o Message) ParseGetHolidaysResponse() ([]json.RawMessage, error) {
+	var result struct {
+		Holidays []json.RawMessage `json:"Holidays"`
+	}
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	return result.Holidays, err
+}
+
+// ParseGetWebEnableResponse returns whether the eIDC32's web UI is enabled.
+func (o Message) ParseGetWebEnableResponse() (bool, error) {
+	var result struct {
+		Enabled json.RawMessage `json:"enabled"`
+	}
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolish(result.Enabled)
+}
+
+// ParseHostedModeResponse returns whether the eIDC32 is in hosted mode.
+func (o Message) ParseHostedModeResponse() (bool, error) {
+	var result struct {
+		HostedMode json.RawMessage `json:"hostedMode"`
+	}
+	eidcBR, err := o.parseEIDCBodyResponse()
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(eidcBR.Body, &result)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolish(result.HostedMode)
+}
+
+// parseBoolish interprets the various ways the eIDC32 firmware says yes or
+// no: JSON booleans, 0/1 (like GetOutboundResponse.Enabled), and strings
+// like "on" and "false".
+func parseBoolish(raw json.RawMessage) (bool, error) {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b, nil
+	}
+
+	var n float64
+	if json.Unmarshal(raw, &n) == nil {
+		return n != 0, nil
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "1", "true", "on", "yes", "enabled":
+			return true, nil
+		case "0", "false", "off", "no", "disabled":
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("cannot interpret '%s' as a boolean", raw)
+}
+
 func (o Message) ParseEnableEventsResponse() (bool, error) {
 	result, err := o.parseEIDCSimpleResponse()
 	if err != nil {
@@ -355,6 +674,20 @@ func (o Message) ParseSetTimeResponse() (bool, error) {
 	return result.Result, nil
 }
 
+func (o Message) ParseEvent0x2fReceiverResponse() (bool, error) {
+	result, err := o.parseEIDCSimpleResponse()
+	if err != nil {
+		return false, err
+	}
+	if result.Cmd != Event0x2fReceiverResponseCmd {
+		return false, fmt.Errorf("unexpected Cmd value, expected %s, got %s",
+			Event0x2fReceiverResponseCmd,
+			result.Cmd,
+		)
+	}
+	return result.Result, nil
+}
+
 func (o Message) getNorthboundType() MsgType {
 	switch {
 	case o.Request != nil:
@@ -391,13 +724,21 @@ func (o Message) getNorthboundResponseType() MsgType {
 	}
 
 	// todo: test parsing simple responses with this code
-	var result EIDCBodyResponse
+	var result EIDCErrorsResponse
 	err := json.Unmarshal(o.Body, &result)
 	if err != nil {
 		return MsgTypeUnknown
 	}
 
-	switch result.Cmd {
+	if result.isError() {
+		return MsgTypeEIDCError
+	}
+	return eidcResponseCmdType(result.Cmd)
+}
+
+// eidcResponseCmdType returns the type of response with the "cmd" field cmd.
+func eidcResponseCmdType(cmd string) MsgType {
+	switch cmd {
 	case Door0x2fLockStatusResponseCmd:
 		return MsgTypeDoor0x2fLockStatusResponse
 	case EnableEventsResponseCmd:
@@ -443,11 +784,67 @@ func (o Message) getNorthboundResponseType() MsgType {
 	case ReflashResponseCmd:
 		return MsgTypeReflashResponse
 	case SetDeviceIDResponseCmd:
-		return MsgTypeSetDeviceIDRequest
+		return MsgTypeSetDeviceIDResponse
 	case AddCardsResponseCmd:
 		return MsgTypeAddCardsResponse
 	case AddPointsResponseCmd:
 		return MsgTypeAddPointsResponse
+	case RebootResponseCmd:
+		return MsgTypeRebootResponse
+	case ResetDBResponseCmd:
+		return MsgTypeResetDBResponse
+	case DefaultConfigResponseCmd:
+		return MsgTypeDefaultConfigResponse
+	case VersionResponseCmd:
+		return MsgTypeVersionResponse
+	case PointOverrideResponseCmd:
+		return MsgTypePointOverrideResponse
+	case UploadResponseCmd:
+		return MsgTypeUploadResponse
+	case GetCardFormatResponseCmd:
+		return MsgTypeGetCardFormatResponse
+	case SetCardFormatResponseCmd:
+		return MsgTypeSetCardFormatResponse
+	case GetOutboundStatusResponseCmd:
+		return MsgTypeGetOutboundStatusResponse
+	case GetWebEnableResponseCmd:
+		return MsgTypeGetWebEnableResponse
+	case HostedModeResponseCmd:
+		return MsgTypeHostedModeResponse
+	case SinglePointStatusResponseCmd:
+		return MsgTypeSinglePointStatusResponse
+	case SetFtpUserResponseCmd:
+		return MsgTypeSetFtpUserResponse
+	case SchedMetricsResponseCmd:
+		return MsgTypeSchedMetricsResponse
+	case Event0x2fReceiverResponseCmd:
+		return MsgTypeEvent0x2fReceiverResponse
+	case DeleteCardsResponseCmd:
+		return MsgTypeDeleteCardsResponse
+	case DeleteFormatsResponseCmd:
+		return MsgTypeDeleteFormatsResponse
+	case DeleteHolidaysResponseCmd:
+		return MsgTypeDeleteHolidaysResponse
+	case DeletePointsResponseCmd:
+		return MsgTypeDeletePointsResponse
+	case DeletePrivilegesResponseCmd:
+		return MsgTypeDeletePrivilegesResponse
+	case DeleteSchedulesResponseCmd:
+		return MsgTypeDeleteSchedulesResponse
+	case GetPointsResponseCmd:
+		return MsgTypeGetPointsResponse
+	case GetFormatsResponseCmd:
+		return MsgTypeGetFormatsResponse
+	case GetPrivilegesResponseCmd:
+		return MsgTypeGetPrivilegesResponse
+	case GetSchedulesResponseCmd:
+		return MsgTypeGetSchedulesResponse
+	case GetHolidaysResponseCmd:
+		return MsgTypeGetHolidaysResponse
+	case GetConfigKeyResponseCmd:
+		return MsgTypeGetConfigKeyResponse
+	case GetDeviceIDResponseCmd:
+		return MsgTypeGetDeviceIDResponse
 	default:
 		return MsgTypeUnknown
 	}
@@ -471,3 +868,14 @@ func (o ConnectedRequest) String() string {
 		o.CardFormat,
 	)
 }
+
+func (o VersionResponse) String() string {
+	return fmt.Sprintf(""+ // <- empty string stops GoFmt making a mess of the lines below
+		"Firmware:   %s\n"+
+		"Bootloader: %s\n"+
+		"Hardware:   %s\n",
+		o.Firmware,
+		o.Bootloader,
+		o.Hardware,
+	)
+}
@@ -1,6 +1,7 @@
 package eidc32proxy
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -65,3 +66,162 @@ func TestMessage_ParseAddFormatsResponse(t *testing.T) {
 		t.Fatalf("expected %d, got %d", expected, afr.FormatsAdded)
 	}
 }
+
+func TestMessage_ParseVersionResponse(t *testing.T) {
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  111\r\n" +
+			"Cache-Control: no-cache\r\n" +
+			"\r\n" +
+			`{"result":true, "cmd":"VERSION", "body":{"firmware":"3.4.20", "bootloader":"1.0.4", "hardware":"eIDC32 rev C"}}`
+	expected := VersionResponse{
+		Firmware:   "3.4.20",
+		Bootloader: "1.0.4",
+		Hardware:   "eIDC32 rev C",
+	}
+	msg, err := ReadMsg([]byte(testData), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgType := msg.GetType()
+	if msgType != MsgTypeVersionResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeVersionResponse.String(), msgType)
+	}
+	vr, err := msg.ParseVersionResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vr != expected {
+		t.Fatalf("expected %+v, got %+v", expected, vr)
+	}
+}
+
+func TestParseBoolish(t *testing.T) {
+	for _, good := range []string{`true`, `1`, `"1"`, `"ON"`, `"enabled"`} {
+		result, err := parseBoolish([]byte(good))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result {
+			t.Fatalf("expected %s to be true", good)
+		}
+	}
+
+	for _, bad := range []string{`"maybe"`, `{}`, `[1]`, ``} {
+		_, err := parseBoolish([]byte(bad))
+		if err == nil {
+			t.Fatalf("expected an error parsing %s", bad)
+		}
+	}
+}
+
+func TestMessage_ParseGetPointsResponse(t *testing.T) {
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  348\r\n" +
+			"Cache-Control: no-cache\r\n" +
+			"\r\n" +
+			`{"result":true, "cmd":"GETPOINTS", "body":{"Points":[{"Type":"Input","Index":0,"RecordInfo":1,"DeviceId":1,"PointId":12,"PointRefNo":3,"PointDriver":2,"IPointFlag":0,"IPointStatus":1,"IPointTick":0},{"Type":"Output","Index":1,"RecordInfo":1,"DeviceId":1,"PointId":38,"PointRefNo":4,"PointDriver":5,"IPointFlag":0,"IPointStatus":0,"IPointTick":0}]}}`
+	expected := []NewPoint{
+		{Type: "Input", Index: 0, RecordInfo: 1, DeviceID: 1, PointId: 12, PointRefNo: 3, PointDriver: 2, IPointStatus: 1},
+		{Type: "Output", Index: 1, RecordInfo: 1, DeviceID: 1, PointId: 38, PointRefNo: 4, PointDriver: 5},
+	}
+	msg, err := ReadMsg([]byte(testData), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetType() != MsgTypeGetPointsResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeGetPointsResponse, msg.GetType())
+	}
+	points, err := msg.ParseGetPointsResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("expected %d points, got %d", len(expected), len(points))
+	}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected[i], points[i])
+		}
+	}
+}
+
+func TestMessage_ParseGetSchedulesResponse(t *testing.T) {
+	testData :=
+		"HTTP/1.0 200 OK\r\n" +
+			"Server: eIDC32 WebServer\r\n" +
+			"Content-type: application/json\r\n" +
+			"Content-Length:  67\r\n" +
+			"Cache-Control: no-cache\r\n" +
+			"\r\n" +
+			`{"result":true, "cmd":"GETSCHEDULES", "body":{"Schedules":[{},{}]}}`
+	msg, err := ReadMsg([]byte(testData), Northbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetType() != MsgTypeGetSchedulesResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeGetSchedulesResponse, msg.GetType())
+	}
+	schedules, err := msg.ParseGetSchedulesResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 {
+		t.Fatalf("expected 2 schedules, got %d", len(schedules))
+	}
+}
+
+func TestMessage_ParseEIDCErrorsResponse(t *testing.T) {
+	response := func(payload string) *Message {
+		msg, err := ReadMsg([]byte(fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+			"Server: eIDC32 WebServer\r\n"+
+			"Content-type: application/json\r\n"+
+			"Content-Length:  %d\r\n"+
+			"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload)), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	msg := response(`{"result":false, "cmd":"ADDCARDS", "errors":[{"code":3,"message":"card table full"}]}`)
+	if msg.GetType() != MsgTypeEIDCError || !msg.IsEIDCError() {
+		t.Fatalf("expected %s, got %s", MsgTypeEIDCError, msg.GetType())
+	}
+	er, err := msg.ParseEIDCErrorsResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if er.Cmd != AddCardsResponseCmd || er.Result {
+		t.Fatalf("expected a failed %s, got %+v", AddCardsResponseCmd, er)
+	}
+	if string(er.Errors) != `[{"code":3,"message":"card table full"}]` {
+		t.Fatalf("unexpected errors %s", er.Errors)
+	}
+	if msg.failedResponseType() != MsgTypeAddCardsResponse {
+		t.Fatalf("expected the failure to be of %s, got %s", MsgTypeAddCardsResponse, msg.failedResponseType())
+	}
+	if !isResponseTo(msg, MsgTypeAddCardsResponse) || isResponseTo(msg, MsgTypeAddPointsResponse) {
+		t.Fatalf("expected the failure to answer %s only", MsgTypeAddCardsResponse)
+	}
+
+	// a false result without errors, or with null errors, isn't an error response
+	for _, payload := range []string{
+		`{"result":false, "cmd":"ADDCARDS"}`,
+		`{"result":false, "cmd":"ADDCARDS", "errors":null}`,
+	} {
+		msg = response(payload)
+		if msg.GetType() != MsgTypeAddCardsResponse || msg.IsEIDCError() {
+			t.Fatalf("%s: expected %s, got %s", payload, MsgTypeAddCardsResponse, msg.GetType())
+		}
+		_, err = msg.ParseEIDCErrorsResponse()
+		if err == nil {
+			t.Fatalf("%s: expected an error parsing as an error response", payload)
+		}
+	}
+}
@@ -6,32 +6,60 @@ import (
 )
 
 const (
-	heartbeatRequestURI        = "/eidc/heartbeat"        // GET; no body; stray newline
-	getOutboundRequestURI      = "/eidc/getoutbound"      // GET; no body; stray newline
-	enableEventsRequestURI     = "/eidc/enableevents"     // GET; no body; stray newline
-	setTimeRequestURI          = "/eidc/setTime"          // POST; body contains a SetTimeRequest
-	setWebUserRequestURI       = "/eidc/setwebuser"       // POST; body contains a SetWebUserRequest
-	getPointStatusRequestURI   = "/eidc/getPointStatus"   // POST; body contains a GetPointStatusRequest
-	eventAckRequestURI         = "/eidc/eventack"         // POST; body contains a EventAckRequest
-	doorLockStatusRequestURI   = "/eidc/door/lockstatus"  // POST; body contains a Door0x2fLockStatusRequest
-	resetEventsRequestURI      = "/eidc/resetevents"      // GET; no body; stray newline
-	clearPointsRequestURI      = "/eidc/clearPoints"      // GET; no body; stray newline
-	addPointsRequestURI        = "/eidc/addPoints"        // POST; body contains a AddPointsRequest
-	resetPointEngineRequestURI = "/eidc/resetPointEngine" // GET; no body; stray newline
-	clearFormatsRequestURI     = "/eidc/clearformats"     // GET; no body; stray newline
-	addFormatsRequestURI       = "/eidc/addFormats"       // POST; body contains a AddFormatsRequest
-	clearSchedulesRequestURI   = "/eidc/clearSchedules"   // GET; no body; stray newline
-	clearHolidaysRequestURI    = "/eidc/clearHolidays"    // GET; no body; stray newline
-	addSchedulesRequestURI     = "/eidc/addSchedules"     // POST; body contains a // todo: Content-Length: 16\r\n\r\n{"Schedules":[]}HTTP/1.0 200 OK
-	clearPrivilegesRequestURI  = "/eidc/clearPrivileges"  // GET; no body; stray newline
-	addPrivilegesRequestURI    = "/eidc/addPrivileges"    // POST; body contains a AddPrivilegesRequest
-	clearCardsRequestURI       = "/eidc/clearCards"       // GET; no body; stray newline
-	addCardsRequestURI         = "/eidc/addCards"         // POST; body contains a AddCardsRequest
-	setConfigKeyRequestURI     = "/eidc/setConfigKey"     // POST; body contains a SetConfigKeyRequest
-	setDeviceIDRequestURI      = "/eidc/setDeviceID"      // POST; body contains a SetDeviceIDRequest
-	setOutboundRequestURI      = "/eidc/setoutbound"      // POST; body contains a SetOutboundRequest
-	downloadRequestURI         = "/eidc/download"         // POST; body contains software image (unzipped .img not web)
-	reflashRequestURI          = "/eidc/reflash"          // GET; no body; stray newline
+	heartbeatRequestURI         = "/eidc/heartbeat"         // GET; no body; stray newline
+	getOutboundRequestURI       = "/eidc/getoutbound"       // GET; no body; stray newline
+	enableEventsRequestURI      = "/eidc/enableevents"      // GET; no body; stray newline
+	setTimeRequestURI           = "/eidc/setTime"           // POST; body contains a SetTimeRequest
+	setWebUserRequestURI        = "/eidc/setwebuser"        // POST; body contains a SetWebUserRequest
+	getPointStatusRequestURI    = "/eidc/getPointStatus"    // POST; body contains a GetPointStatusRequest
+	eventAckRequestURI          = "/eidc/eventack"          // POST; body contains a EventAckRequest
+	doorLockStatusRequestURI    = "/eidc/door/lockstatus"   // POST; body contains a Door0x2fLockStatusRequest
+	resetEventsRequestURI       = "/eidc/resetevents"       // GET; no body; stray newline
+	clearPointsRequestURI       = "/eidc/clearPoints"       // GET; no body; stray newline
+	addPointsRequestURI         = "/eidc/addPoints"         // POST; body contains a AddPointsRequest
+	resetPointEngineRequestURI  = "/eidc/resetPointEngine"  // GET; no body; stray newline
+	clearFormatsRequestURI      = "/eidc/clearformats"      // GET; no body; stray newline
+	addFormatsRequestURI        = "/eidc/addFormats"        // POST; body contains a AddFormatsRequest
+	clearSchedulesRequestURI    = "/eidc/clearSchedules"    // GET; no body; stray newline
+	clearHolidaysRequestURI     = "/eidc/clearHolidays"     // GET; no body; stray newline
+	addSchedulesRequestURI      = "/eidc/addSchedules"      // POST; body contains a // todo: Content-Length: 16\r\n\r\n{"Schedules":[]}HTTP/1.0 200 OK
+	clearPrivilegesRequestURI   = "/eidc/clearPrivileges"   // GET; no body; stray newline
+	addPrivilegesRequestURI     = "/eidc/addPrivileges"     // POST; body contains a AddPrivilegesRequest
+	clearCardsRequestURI        = "/eidc/clearCards"        // GET; no body; stray newline
+	addCardsRequestURI          = "/eidc/addCards"          // POST; body contains a AddCardsRequest
+	setConfigKeyRequestURI      = "/eidc/setConfigKey"      // POST; body contains a SetConfigKeyRequest
+	setDeviceIDRequestURI       = "/eidc/setDeviceID"       // POST; body contains a SetDeviceIDRequest
+	setOutboundRequestURI       = "/eidc/setoutbound"       // POST; body contains a SetOutboundRequest
+	downloadRequestURI          = "/eidc/download"          // POST; body contains software image (unzipped .img not web)
+	reflashRequestURI           = "/eidc/reflash"           // GET; no body; stray newline
+	rebootRequestURI            = "/eidc/reboot"            // GET; no body; stray newline
+	resetDBRequestURI           = "/eidc/resetdb"           // GET; no body; stray newline
+	defaultConfigRequestURI     = "/eidc/defaultconfig"     // GET; no body; stray newline
+	versionRequestURI           = "/eidc/version"           // GET; no body; stray newline
+	pointOverrideRequestURI     = "/eidc/pointOverride"     // POST; body contains a PointOverrideRequest
+	uploadRequestURI            = "/eidc/upload"            // POST; body contains software image (mirror of download)
+	getCardFormatRequestURI     = "/eidc/getCardFormat"     // GET; no body; stray newline
+	setCardFormatRequestURI     = "/eidc/setCardFormat"     // POST; body contains a SetCardFormatRequest
+	getOutboundStatusRequestURI = "/eidc/getOutboundStatus" // GET; no body; stray newline
+	getWebEnableRequestURI      = "/eidc/getWebEnable"      // GET; no body; stray newline
+	hostedModeRequestURI        = "/eidc/hostedMode"        // GET; no body; stray newline
+	singlePointStatusRequestURI = "/eidc/singlePointStatus" // POST; body contains a SinglePointStatusRequest
+	setFtpUserRequestURI        = "/eidc/setftpuser"        // POST; body contains a SetFtpUserRequest
+	schedMetricsRequestURI      = "/eidc/schedMetrics"      // GET; no body; stray newline
+	eventReceiverRequestURI     = "/eidc/event/receiver"    // GET; no body; stray newline
+	deleteCardsRequestURI       = "/eidc/deleteCards"       // POST; body contains a DeleteCardsRequest
+	deleteFormatsRequestURI     = "/eidc/deleteFormats"     // GET; no body; stray newline
+	deleteHolidaysRequestURI    = "/eidc/deleteHolidays"    // GET; no body; stray newline
+	deletePointsRequestURI      = "/eidc/deletePoints"      // POST; body contains a DeletePointsRequest
+	deletePrivilegesRequestURI  = "/eidc/deletePrivileges"  // POST; body contains a DeletePrivilegesRequest
+	deleteSchedulesRequestURI   = "/eidc/deleteSchedules"   // GET; no body; stray newline
+	getPointsRequestURI         = "/eidc/getPoints"         // GET; no body; stray newline
+	getFormatsRequestURI        = "/eidc/getFormats"        // GET; no body; stray newline
+	getPrivilegesRequestURI     = "/eidc/getPrivileges"     // GET; no body; stray newline
+	getSchedulesRequestURI      = "/eidc/getSchedules"      // GET; no body; stray newline
+	getHolidaysRequestURI       = "/eidc/getHolidays"       // GET; no body; stray newline
+	getConfigKeyRequestURI      = "/eidc/getConfigKey"      // GET; no body; stray newline
+	getDeviceIDRequestURI       = "/eidc/getDeviceID"       // GET; no body; stray newline
 )
 
 const (
@@ -100,12 +128,26 @@ type SetWebUserRequest struct {
 	Other    interface{} `json:"-"`
 }
 
+// Intelli-M POST /eidc/setftpuser sets the credentials the eIDC32 uses for
+// FTP during firmware updates.
+type SetFtpUserRequest struct {
+	Password string      `json:"Password"`
+	User     string      `json:"User"`
+	Other    interface{} `json:"-"`
+}
+
 // Intelli-M GET /eidc/getPointStatus
 type GetPointStatusRequest struct {
 	PointIds []int       `json:"pointIds"`
 	Other    interface{} `json:"-"`
 }
 
+// Intelli-M POST /eidc/singlePointStatus
+type SinglePointStatusRequest struct {
+	PointId int         `json:"pointId"`
+	Other   interface{} `json:"-"`
+}
+
 // Intelli-M POST /eidc/eventack
 type EventAckRequest struct {
 	EventIds []int       `json:"eventIds"`
@@ -119,6 +161,20 @@ type Door0x2fLockStatusRequest struct {
 	Other    interface{} `json:"-"`
 }
 
+// Intelli-M POST /eidc/pointOverride
+type PointOverrideRequest struct {
+	PointID  int         `json:"pointId"`
+	Override int         `json:"override"`
+	Duration int         `json:"duration"`
+	Other    interface{} `json:"-"`
+}
+
+// Intelli-M POST /eidc/setCardFormat
+type SetCardFormatRequest struct {
+	CardFormat string      `json:"cardFormat"`
+	Other      interface{} `json:"-"`
+}
+
 // Intelli-M POST /eidc/addPoints
 type AddPointsRequest struct {
 	NewPoints []NewPoint `json:"Points"`
@@ -180,6 +236,24 @@ type CardHolder struct {
 	Description    string `json:"Description"`
 }
 
+// Intelli-M POST /eidc/deleteCards
+type DeleteCardsRequest struct {
+	CardIDs []int `json:"CardIds"`
+	Other   interface{}
+}
+
+// Intelli-M POST /eidc/deletePoints
+type DeletePointsRequest struct {
+	PointIDs []int `json:"PointIds"`
+	Other    interface{}
+}
+
+// Intelli-M POST /eidc/deletePrivileges
+type DeletePrivilegesRequest struct {
+	PrivilegeIDs []int `json:"PrivilegeIds"`
+	Other        interface{}
+}
+
 // Intelli-M POST /eidc/setConfigKey
 type SetConfigKeyRequest struct {
 	ConfigurationKey string `json:"ConfigurationKey"`
@@ -242,6 +316,46 @@ func (o Message) getSouthboundRequestType() MsgType {
 			return MsgTypeClearCardsRequest
 		case reflashRequestURI:
 			return MsgTypeReflashRequest
+		case rebootRequestURI:
+			return MsgTypeRebootRequest
+		case resetDBRequestURI:
+			return MsgTypeResetDBRequest
+		case defaultConfigRequestURI:
+			return MsgTypeDefaultConfigRequest
+		case versionRequestURI:
+			return MsgTypeVersionRequest
+		case getCardFormatRequestURI:
+			return MsgTypeGetCardFormatRequest
+		case getOutboundStatusRequestURI:
+			return MsgTypeGetOutboundStatusRequest
+		case getWebEnableRequestURI:
+			return MsgTypeGetWebEnableRequest
+		case hostedModeRequestURI:
+			return MsgTypeHostedModeRequest
+		case schedMetricsRequestURI:
+			return MsgTypeSchedMetricsRequest
+		case eventReceiverRequestURI:
+			return MsgTypeEvent0x2fReceiverRequest
+		case deleteFormatsRequestURI:
+			return MsgTypeDeleteFormatsRequest
+		case deleteHolidaysRequestURI:
+			return MsgTypeDeleteHolidaysRequest
+		case deleteSchedulesRequestURI:
+			return MsgTypeDeleteSchedulesRequest
+		case getPointsRequestURI:
+			return MsgTypeGetPointsRequest
+		case getFormatsRequestURI:
+			return MsgTypeGetFormatsRequest
+		case getPrivilegesRequestURI:
+			return MsgTypeGetPrivilegesRequest
+		case getSchedulesRequestURI:
+			return MsgTypeGetSchedulesRequest
+		case getHolidaysRequestURI:
+			return MsgTypeGetHolidaysRequest
+		case getConfigKeyRequestURI:
+			return MsgTypeGetConfigKeyRequest
+		case getDeviceIDRequestURI:
+			return MsgTypeGetDeviceIDRequest
 		default:
 			return MsgTypeUnknown
 		}
@@ -275,6 +389,22 @@ func (o Message) getSouthboundRequestType() MsgType {
 			return MsgTypeAddSchedulesRequest
 		case downloadRequestURI:
 			return MsgTypeDownloadRequest
+		case pointOverrideRequestURI:
+			return MsgTypePointOverrideRequest
+		case uploadRequestURI:
+			return MsgTypeUploadRequest
+		case setCardFormatRequestURI:
+			return MsgTypeSetCardFormatRequest
+		case singlePointStatusRequestURI:
+			return MsgTypeSinglePointStatusRequest
+		case setFtpUserRequestURI:
+			return MsgTypeSetFtpUserRequest
+		case deleteCardsRequestURI:
+			return MsgTypeDeleteCardsRequest
+		case deletePointsRequestURI:
+			return MsgTypeDeletePointsRequest
+		case deletePrivilegesRequestURI:
+			return MsgTypeDeletePrivilegesRequest
 		default:
 			return MsgTypeUnknown
 		}
@@ -327,12 +457,24 @@ func (o Message) ParseSetWebUserRequest() (SetWebUserRequest, error) {
 	return result, err
 }
 
+func (o Message) ParseSetFtpUserRequest() (SetFtpUserRequest, error) {
+	var result SetFtpUserRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
 func (o Message) ParseGetPointStatusRequest() (GetPointStatusRequest, error) {
 	var result GetPointStatusRequest
 	err := json.Unmarshal(o.Body, &result)
 	return result, err
 }
 
+func (o Message) ParseSinglePointStatusRequest() (SinglePointStatusRequest, error) {
+	var result SinglePointStatusRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
 func (o Message) ParseEventAckRequest() (EventAckRequest, error) {
 	var result EventAckRequest
 	err := json.Unmarshal(o.Body, &result)
@@ -345,6 +487,52 @@ func (o Message) ParseDoor0x2fLockStatusRequest() (Door0x2fLockStatusRequest, er
 	return result, err
 }
 
+func (o Message) ParsePointOverrideRequest() (PointOverrideRequest, error) {
+	var result PointOverrideRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseSetConfigKeyRequest() (SetConfigKeyRequest, error) {
+	var result SetConfigKeyRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseSetDeviceIDRequest() (SetDeviceIDRequest, error) {
+	var result SetDeviceIDRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseSetCardFormatRequest() (SetCardFormatRequest, error) {
+	var result SetCardFormatRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
 func (o Message) ParseDownloadRequest() []byte {
 	return o.Body
 }
+
+func (o Message) ParseUploadRequest() []byte {
+	return o.Body
+}
+
+func (o Message) ParseDeleteCardsRequest() (DeleteCardsRequest, error) {
+	var result DeleteCardsRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseDeletePointsRequest() (DeletePointsRequest, error) {
+	var result DeletePointsRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
+
+func (o Message) ParseDeletePrivilegesRequest() (DeletePrivilegesRequest, error) {
+	var result DeletePrivilegesRequest
+	err := json.Unmarshal(o.Body, &result)
+	return result, err
+}
@@ -1,6 +1,9 @@
 package eidc32proxy
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestParseDownloadRequest(t *testing.T) {
 	testData := "" +
@@ -31,3 +34,142 @@ func TestParseDownloadRequest(t *testing.T) {
 			len(expected))
 	}
 }
+
+func TestParsePointOverrideRequest(t *testing.T) {
+	testData := "" +
+		"POST /eidc/pointOverride?username=admin&password=admin&seq=31 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 40\r\n" +
+		"\r\n" +
+		`{"pointId":12,"override":1,"duration":5}`
+	expected := PointOverrideRequest{
+		PointID:  12,
+		Override: 1,
+		Duration: 5,
+	}
+	msg, err := ReadMsg([]byte(testData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypePointOverrideRequest {
+		t.Fatalf("expected %s, got %s",
+			MsgTypePointOverrideRequest.String(),
+			msg.Type.String())
+	}
+	result, err := msg.ParsePointOverrideRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseUploadRequest(t *testing.T) {
+	testData := "" +
+		"POST /eidc/upload?username=admin&password=admin&seq=25 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n" +
+		"Content-Type: application/binary\r\n" +
+		"Content-Length: 17\r\n" +
+		"\r\n" +
+		"this is some data"
+	expected := []byte("this is some data")
+	msg, err := ReadMsg([]byte(testData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypeUploadRequest {
+		t.Fatalf("expected %s, got %s",
+			MsgTypeUploadRequest.String(),
+			msg.Type.String())
+	}
+	result := msg.ParseUploadRequest()
+	if !bytes.Equal(result, expected) {
+		t.Fatalf("expected '%s', got '%s'", expected, result)
+	}
+}
+
+const setFtpUserRequestTestData = "" +
+	"POST /eidc/setftpuser?username=admin&password=admin&seq=12 HTTP/1.1\r\n" +
+	"Host: 192.168.6.40\r\n" +
+	"User-Agent: eIDCListener\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 39\r\n" +
+	"\r\n" +
+	`{"Password":"ftppass","User":"ftpuser"}`
+
+func TestParseSetFtpUserRequest(t *testing.T) {
+	expected := SetFtpUserRequest{
+		Password: "ftppass",
+		User:     "ftpuser",
+	}
+	msg, err := ReadMsg([]byte(setFtpUserRequestTestData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypeSetFtpUserRequest {
+		t.Fatalf("expected %s, got %s",
+			MsgTypeSetFtpUserRequest.String(),
+			msg.Type.String())
+	}
+	result, err := msg.ParseSetFtpUserRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseDeleteCardsRequest(t *testing.T) {
+	testData := "" +
+		"POST /eidc/deleteCards?username=admin&password=admin&seq=27 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 22\r\n" +
+		"\r\n" +
+		`{"CardIds":[12,34,56]}`
+	expected := []int{12, 34, 56}
+	msg, err := ReadMsg([]byte(testData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypeDeleteCardsRequest {
+		t.Fatalf("expected %s, got %s",
+			MsgTypeDeleteCardsRequest.String(),
+			msg.Type.String())
+	}
+	result, err := msg.ParseDeleteCardsRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.CardIDs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result.CardIDs)
+	}
+	for i := range expected {
+		if result.CardIDs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, result.CardIDs)
+		}
+	}
+}
+
+func TestParseDeleteSchedulesRequest(t *testing.T) {
+	testData := "" +
+		"GET /eidc/deleteSchedules?username=admin&password=admin&seq=28 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n" +
+		"\r\n\r\n"
+	msg, err := ReadMsg([]byte(testData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypeDeleteSchedulesRequest {
+		t.Fatalf("expected %s, got %s",
+			MsgTypeDeleteSchedulesRequest.String(),
+			msg.Type.String())
+	}
+}
@@ -0,0 +1,198 @@
+// Package metrics serves operational metrics about proxied sessions in the
+// Prometheus text exposition format, for scraping from an admin port. The
+// format is written by hand rather than with prometheus/client_golang, which
+// would drag protobuf and friends into a proxy that's built for small boxes
+// (Cloud Key, Shark Jack) and a handful of metrics.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chrismarget/eidc32proxy"
+)
+
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+	namespace   = "eidc32proxy"
+)
+
+// Source supplies the counters behind the metrics, and the identity of the
+// session they belong to. *eidc32proxy.Session is a Source.
+type Source interface {
+	Context() context.Context
+	Stats() eidc32proxy.SessionStats
+	Serial() string
+	GetTag() string
+}
+
+// registry is the set of watched sources. Sources are dropped from it once
+// they've ended and their final counts are folded into retired, so counters
+// never go backwards.
+var registry = struct {
+	mu      sync.Mutex
+	sources map[Source]struct{}
+	watched uint64
+	retired eidc32proxy.SessionStats
+}{
+	sources: make(map[Source]struct{}),
+	retired: newStats(),
+}
+
+func newStats() eidc32proxy.SessionStats {
+	return eidc32proxy.SessionStats{
+		BytesRelayed: make(map[eidc32proxy.Direction]uint64),
+		MsgsRelayed:  make(map[eidc32proxy.MsgType]uint64),
+	}
+}
+
+// Watch adds a session (or other Source) to the metrics. Call it for each
+// session announced by the Server. The source is retired when its context
+// is done, so ended sessions aren't held whether or not the metrics are
+// ever scraped.
+func Watch(s Source) {
+	registry.mu.Lock()
+	if _, ok := registry.sources[s]; ok {
+		registry.mu.Unlock()
+		return
+	}
+	registry.sources[s] = struct{}{}
+	registry.watched++
+	registry.mu.Unlock()
+
+	go func() {
+		<-s.Context().Done()
+		registry.mu.Lock()
+		retire(s)
+		registry.mu.Unlock()
+	}()
+}
+
+// retire folds an ended source's final counts into registry.retired and
+// drops it from the registry. Call it with registry.mu held.
+func retire(s Source) {
+	if _, ok := registry.sources[s]; !ok {
+		return // already retired
+	}
+	add(&registry.retired, s.Stats())
+	delete(registry.sources, s)
+}
+
+// Handler returns an http.Handler which serves the metrics of every watched
+// session.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		write(w, gather())
+	})
+}
+
+// snapshot is the aggregate of all sources at one moment.
+type snapshot struct {
+	active  int
+	watched uint64
+	stats   eidc32proxy.SessionStats
+	info    []sessionInfo // of the active sources, sorted
+}
+
+// sessionInfo identifies an active session, so that the metrics of several
+// proxies can be correlated by tag (see Server.SetSessionTagger()).
+type sessionInfo struct {
+	serial string
+	tag    string
+}
+
+// gather totals up the counters of every source, retiring the ones which
+// have ended.
+func gather() snapshot {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	result := snapshot{watched: registry.watched, stats: newStats()}
+	for s := range registry.sources {
+		if s.Context().Err() != nil {
+			retire(s)
+		}
+	}
+	add(&result.stats, registry.retired)
+	for s := range registry.sources {
+		add(&result.stats, s.Stats())
+		result.active++
+		result.info = append(result.info, sessionInfo{serial: s.Serial(), tag: s.GetTag()})
+	}
+	sort.Slice(result.info, func(i, j int) bool {
+		if result.info[i].serial != result.info[j].serial {
+			return result.info[i].serial < result.info[j].serial
+		}
+		return result.info[i].tag < result.info[j].tag
+	})
+	return result
+}
+
+// add adds the counts in src to dst.
+func add(dst *eidc32proxy.SessionStats, src eidc32proxy.SessionStats) {
+	for dir, n := range src.BytesRelayed {
+		dst.BytesRelayed[dir] += n
+	}
+	for t, n := range src.MsgsRelayed {
+		dst.MsgsRelayed[t] += n
+	}
+	dst.MsgsDropped += src.MsgsDropped
+	dst.PagerTimeouts += src.PagerTimeouts
+}
+
+// write renders the snapshot in the Prometheus text exposition format.
+func write(w io.Writer, s snapshot) {
+	metric(w, "sessions_active", "gauge", "Sessions which haven't ended.")
+	fmt.Fprintf(w, "%s_sessions_active %d\n", namespace, s.active)
+
+	metric(w, "sessions_total", "counter", "Sessions seen since the proxy started.")
+	fmt.Fprintf(w, "%s_sessions_total %d\n", namespace, s.watched)
+
+	metric(w, "session_info", "gauge", "Active sessions, by eIDC32 serial number and tag.")
+	for _, i := range s.info {
+		fmt.Fprintf(w, "%s_session_info{serial=\"%s\",tag=\"%s\"} 1\n", namespace, escape(i.serial), escape(i.tag))
+	}
+
+	metric(w, "relayed_bytes_total", "counter", "Bytes written toward the IntelliM (northbound) and eIDC32 (southbound).")
+	for _, dir := range []eidc32proxy.Direction{eidc32proxy.Northbound, eidc32proxy.Southbound} {
+		fmt.Fprintf(w, "%s_relayed_bytes_total{direction=\"%s\"} %d\n",
+			namespace, strings.ToLower(dir.String()), s.stats.BytesRelayed[dir])
+	}
+
+	metric(w, "relayed_messages_total", "counter", "Messages written (relayed or injected), by type.")
+	var types []string
+	counts := make(map[string]uint64)
+	for t, n := range s.stats.MsgsRelayed {
+		if _, ok := counts[t.String()]; !ok {
+			types = append(types, t.String())
+		}
+		counts[t.String()] += n
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "%s_relayed_messages_total{type=\"%s\"} %d\n", namespace, escape(t), counts[t])
+	}
+
+	metric(w, "mangler_dropped_messages_total", "counter", "Messages dropped by manglers.")
+	fmt.Fprintf(w, "%s_mangler_dropped_messages_total %d\n", namespace, s.stats.MsgsDropped)
+
+	metric(w, "pager_timeouts_total", "counter", "Messages the pager gave up delivering to slow subscribers.")
+	fmt.Fprintf(w, "%s_pager_timeouts_total %d\n", namespace, s.stats.PagerTimeouts)
+}
+
+// metric writes the HELP and TYPE lines which introduce a metric.
+func metric(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s_%s %s\n", namespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s_%s %s\n", namespace, name, metricType)
+}
+
+// escape escapes a label value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
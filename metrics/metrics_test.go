@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrismarget/eidc32proxy"
+)
+
+// fakeSource is a Source whose counters are set by the test.
+type fakeSource struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	stats  eidc32proxy.SessionStats
+	serial string
+	tag    string
+}
+
+func newFakeSource(serial string, tag string) *fakeSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeSource{ctx: ctx, cancel: cancel, stats: newStats(), serial: serial, tag: tag}
+}
+
+func (o *fakeSource) Serial() string {
+	return o.serial
+}
+
+func (o *fakeSource) GetTag() string {
+	return o.tag
+}
+
+func (o *fakeSource) Context() context.Context {
+	return o.ctx
+}
+
+func (o *fakeSource) Stats() eidc32proxy.SessionStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := newStats()
+	add(&result, o.stats)
+	return result
+}
+
+// relay counts a message as the session would.
+func (o *fakeSource) relay(msgType eidc32proxy.MsgType, dir eidc32proxy.Direction, n int) {
+	o.mu.Lock()
+	o.stats.BytesRelayed[dir] += uint64(n)
+	o.stats.MsgsRelayed[msgType]++
+	o.mu.Unlock()
+}
+
+// resetRegistry forgets all watched sources.
+func resetRegistry() {
+	registry.mu.Lock()
+	registry.sources = make(map[Source]struct{})
+	registry.watched = 0
+	registry.retired = newStats()
+	registry.mu.Unlock()
+}
+
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != contentType {
+		t.Fatalf("expected Content-Type %s, got %s", contentType, ct)
+	}
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func expectMetric(t *testing.T, body string, line string) {
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Fatalf("expected metric line '%s' in:\n%s", line, body)
+}
+
+func TestHandler(t *testing.T) {
+	defer resetRegistry()
+
+	a := newFakeSource("0x000000123456", "site-a")
+	b := newFakeSource("0x000000654321", "")
+	Watch(a)
+	Watch(b)
+	Watch(a) // watching twice doesn't count twice
+
+	body := scrape(t)
+	expectMetric(t, body, "eidc32proxy_sessions_active 2")
+	expectMetric(t, body, `eidc32proxy_session_info{serial="0x000000123456",tag="site-a"} 1`)
+	expectMetric(t, body, `eidc32proxy_session_info{serial="0x000000654321",tag=""} 1`)
+
+	a.relay(eidc32proxy.MsgTypeHeartbeatRequest, eidc32proxy.Southbound, 100)
+	b.relay(eidc32proxy.MsgTypeHeartbeatRequest, eidc32proxy.Southbound, 50)
+	b.relay(eidc32proxy.MsgTypeHeartbeatResponse, eidc32proxy.Northbound, 75)
+
+	body = scrape(t)
+	expectMetric(t, body, "eidc32proxy_sessions_total 2")
+	expectMetric(t, body, `eidc32proxy_relayed_messages_total{type="Heartbeat Request"} 2`)
+	expectMetric(t, body, `eidc32proxy_relayed_messages_total{type="Heartbeat Response"} 1`)
+	expectMetric(t, body, `eidc32proxy_relayed_bytes_total{direction="southbound"} 150`)
+	expectMetric(t, body, `eidc32proxy_relayed_bytes_total{direction="northbound"} 75`)
+	expectMetric(t, body, "# TYPE eidc32proxy_relayed_messages_total counter")
+
+	// ended sessions stop being active, but their counts remain
+	b.cancel()
+	body = scrape(t)
+	expectMetric(t, body, "eidc32proxy_sessions_active 1")
+	expectMetric(t, body, `eidc32proxy_relayed_messages_total{type="Heartbeat Request"} 2`)
+	if strings.Contains(body, `serial="0x000000654321"`) {
+		t.Fatalf("ended session still has session_info:\n%s", body)
+	}
+	body = scrape(t)
+	expectMetric(t, body, `eidc32proxy_relayed_bytes_total{direction="northbound"} 75`)
+}
+
+func TestWatchRetiresEndedSources(t *testing.T) {
+	defer resetRegistry()
+
+	a := newFakeSource("0x000000123456", "")
+	Watch(a)
+	a.relay(eidc32proxy.MsgTypeHeartbeatRequest, eidc32proxy.Southbound, 100)
+	a.cancel()
+
+	// the source leaves the registry without a scrape
+	deadline := time.Now().Add(time.Second)
+	for {
+		registry.mu.Lock()
+		watched := len(registry.sources)
+		registry.mu.Unlock()
+		if watched == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ended source was not retired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	body := scrape(t)
+	expectMetric(t, body, "eidc32proxy_sessions_active 0")
+	expectMetric(t, body, `eidc32proxy_relayed_bytes_total{direction="southbound"} 100`)
+}
@@ -0,0 +1,123 @@
+package eidc32proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// outboundProxy holds the HTTP proxy used for the proxy's own connections to
+// IntelliM, see SetOutboundProxy().
+var outboundProxy = struct {
+	mu  sync.Mutex
+	url *url.URL
+}{}
+
+// SetOutboundProxy routes the proxy's own TLS connections to IntelliM (see
+// ConnectUsingTerribleTLSContext()) through the HTTP proxy at proxyURL, by way
+// of a CONNECT tunnel. This is handy in segmented networks where the proxy
+// can't reach IntelliM directly. Credentials in proxyURL (user:pass@host) are
+// sent as Basic Proxy-Authorization. Only the "http" scheme is supported. nil
+// (the default) dials IntelliM directly.
+func SetOutboundProxy(proxyURL *url.URL) {
+	outboundProxy.mu.Lock()
+	outboundProxy.url = proxyURL
+	outboundProxy.mu.Unlock()
+}
+
+// dialOutbound connects to addr, either directly or through a tunnel set up
+// by the proxy configured with SetOutboundProxy().
+func dialOutbound(ctx context.Context, transportType string, addr string) (net.Conn, error) {
+	outboundProxy.mu.Lock()
+	proxyURL := outboundProxy.url
+	outboundProxy.mu.Unlock()
+
+	if proxyURL == nil {
+		return (&net.Dialer{}).DialContext(ctx, transportType, addr)
+	}
+	return dialConnect(ctx, proxyURL, transportType, addr)
+}
+
+// dialConnect connects to the HTTP proxy at proxyURL and asks it to CONNECT
+// us to addr. The returned net.Conn is the tunnel.
+func dialConnect(ctx context.Context, proxyURL *url.URL, transportType string, addr string) (net.Conn, error) {
+	if proxyURL.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported outbound proxy scheme '%s'", proxyURL.Scheme)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, transportType, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// close the connection out from under the CONNECT exchange if ctx is
+	// canceled.
+	connectDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-connectDone:
+		}
+	}()
+	defer close(connectDone)
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		creds := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+	}
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, connectErr(ctx, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, connectErr(ctx, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("outbound proxy %s refused CONNECT to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+
+	// the client speaks first in TLS, so there's no reason for anything to
+	// have arrived through the tunnel yet.
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("outbound proxy %s sent unexpected data after CONNECT", proxyURL.Host)
+	}
+
+	return conn, nil
+}
+
+// connectErr prefers ctx's error (if any) over err, which is likely the
+// result of the connection being closed because of ctx.
+func connectErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
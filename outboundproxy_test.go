@@ -0,0 +1,133 @@
+package eidc32proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// connectProxy is a minimal HTTP CONNECT proxy for tests. It records the
+// CONNECT requests it sees, refuses those without the expected
+// Proxy-Authorization (if any), and tunnels the rest.
+type connectProxy struct {
+	ln       net.Listener
+	auth     string
+	requests chan *http.Request
+}
+
+func newConnectProxy(t *testing.T, auth string) *connectProxy {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &connectProxy{ln: ln, auth: auth, requests: make(chan *http.Request, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(c)
+		}
+	}()
+	return p
+}
+
+func (o *connectProxy) handle(c net.Conn) {
+	defer c.Close()
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return
+	}
+	o.requests <- req
+
+	if o.auth != "" && req.Header.Get("Proxy-Authorization") != o.auth {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+
+	target, err := net.Dial("tcp4", req.Host)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(target, c); done <- struct{}{} }()
+	go func() { io.Copy(c, target); done <- struct{}{} }()
+	<-done
+}
+
+func TestDialOutbound(t *testing.T) {
+	defer SetOutboundProxy(nil)
+
+	// the target echoes one line
+	target, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, _ := bufio.NewReader(c).ReadString('\n')
+				io.WriteString(c, line)
+			}()
+		}
+	}()
+
+	proxy := newConnectProxy(t, "Basic dXNlcjpwYXNz") // user:pass
+	defer proxy.ln.Close()
+
+	// tunnel established, bytes flow both ways
+	SetOutboundProxy(&url.URL{Scheme: "http", Host: proxy.ln.Addr().String(), User: url.UserPassword("user", "pass")})
+	conn, err := dialOutbound(context.Background(), "tcp4", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-proxy.requests
+	if req.Method != http.MethodConnect || req.Host != target.Addr().String() {
+		t.Fatalf("expected CONNECT %s, proxy got %s %s", target.Addr(), req.Method, req.Host)
+	}
+	if _, err = io.WriteString(conn, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Fatalf("expected echo through the tunnel, got %q", line)
+	}
+	conn.Close()
+
+	// TLS connections to IntelliM use the proxy, and refusals are errors
+	SetOutboundProxy(&url.URL{Scheme: "http", Host: proxy.ln.Addr().String()})
+	_, err = ConnectUsingTerribleTLSContext(context.Background(), "intellim.example.com", "tcp4")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected the proxy to refuse without credentials, got %v", err)
+	}
+	req = <-proxy.requests
+	if req.Host != "intellim.example.com:443" {
+		t.Fatalf("expected CONNECT intellim.example.com:443, proxy got %s", req.Host)
+	}
+
+	// unsupported schemes
+	SetOutboundProxy(&url.URL{Scheme: "socks5", Host: proxy.ln.Addr().String()})
+	_, err = dialOutbound(context.Background(), "tcp4", target.Addr().String())
+	if err == nil {
+		t.Fatal("expected an error for an unsupported proxy scheme")
+	}
+}
@@ -0,0 +1,45 @@
+package eidc32proxy
+
+import (
+	"net"
+	"sync"
+)
+
+// outboundTLS holds the ClientHello overrides used for the proxy's own
+// connections to IntelliM, see SetOutboundTLS().
+var outboundTLS = struct {
+	mu         sync.Mutex
+	serverName string
+	nextProtos []string
+}{}
+
+// SetOutboundTLS controls the SNI (serverName) and ALPN (nextProtos) values
+// sent in the ClientHello of the proxy's own TLS connections to IntelliM (see
+// ConnectUsingTerribleTLSContext()). Load balancers in front of IntelliM may
+// route on these, so set them to match what the real eIDC32 sends when the
+// dial address differs from the name the device uses. An empty serverName
+// (the default) derives SNI from the dial host; nil nextProtos (the default)
+// sends no ALPN extension.
+func SetOutboundTLS(serverName string, nextProtos []string) {
+	outboundTLS.mu.Lock()
+	outboundTLS.serverName = serverName
+	outboundTLS.nextProtos = append([]string(nil), nextProtos...)
+	outboundTLS.mu.Unlock()
+}
+
+// outboundTLSParams returns the SNI and ALPN values to use when connecting to
+// addr, per SetOutboundTLS().
+func outboundTLSParams(addr string) (string, []string) {
+	outboundTLS.mu.Lock()
+	serverName := outboundTLS.serverName
+	nextProtos := outboundTLS.nextProtos
+	outboundTLS.mu.Unlock()
+
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+	if len(nextProtos) == 0 {
+		nextProtos = nil
+	}
+	return serverName, nextProtos
+}
@@ -0,0 +1,90 @@
+package eidc32proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/chrismarget/terribletls"
+)
+
+// newSNIServer starts a TLS server which completes handshakes only with
+// clients requesting serverName. Each ClientHello it sees is sent on the
+// returned channel.
+func newSNIServer(t *testing.T, serverName string) (net.Listener, chan *terribletls.ClientHelloInfo) {
+	cert, key, err := CertAndKey(InfiniasCertSetup())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hellos := make(chan *terribletls.ClientHelloInfo, 10)
+	conf := &terribletls.Config{
+		Certificates: []terribletls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+		}},
+		CipherSuites: []uint16{terribletls.TLS_RSA_WITH_RC4_128_MD5},
+		MaxVersion:   terribletls.VersionTLS12,
+		GetConfigForClient: func(hello *terribletls.ClientHelloInfo) (*terribletls.Config, error) {
+			hellos <- hello
+			if hello.ServerName != serverName {
+				return nil, fmt.Errorf("unknown server name '%s'", hello.ServerName)
+			}
+			return nil, nil
+		},
+	}
+
+	ln, err := terribletls.Listen("tcp4", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.(*terribletls.Conn).Handshake()
+			}()
+		}
+	}()
+	return ln, hellos
+}
+
+func TestSetOutboundTLS(t *testing.T) {
+	defer SetOutboundTLS("", nil)
+
+	ln, hellos := newSNIServer(t, "intellim.example.com")
+	defer ln.Close()
+
+	// by default SNI comes from the dial host, and the server refuses it
+	_, err := ConnectUsingTerribleTLSContext(context.Background(), ln.Addr().String(), "tcp4")
+	if err == nil {
+		t.Fatal("expected the server to refuse SNI derived from the dial address")
+	}
+	hello := <-hellos
+	if hello.ServerName != "" { // IP addresses aren't sent as SNI
+		t.Fatalf("expected no SNI, got '%s'", hello.ServerName)
+	}
+	if len(hello.SupportedProtos) != 0 {
+		t.Fatalf("expected no ALPN, got %v", hello.SupportedProtos)
+	}
+
+	// overridden SNI and ALPN
+	SetOutboundTLS("intellim.example.com", []string{"http/1.1"})
+	conn, err := ConnectUsingTerribleTLSContext(context.Background(), ln.Addr().String(), "tcp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	hello = <-hellos
+	if hello.ServerName != "intellim.example.com" {
+		t.Fatalf("expected SNI 'intellim.example.com', got '%s'", hello.ServerName)
+	}
+	if len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != "http/1.1" {
+		t.Fatalf("expected ALPN [http/1.1], got %v", hello.SupportedProtos)
+	}
+}
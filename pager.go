@@ -48,18 +48,54 @@ const (
 // both a Category (for subscription to broad categories of messages) and a
 // slice of MsgTypes (for subscription to specific message type(s)). The
 // Category element is only considered if the []MsgType element is empty.
+// Categories subscribes to several categories on one channel (say, northbound
+// requests and southbound responses). When it's non-empty, Category is
+// ignored. Filter is optional. When present, it's run against each message which
+// matches the Category or MsgTypes. Only messages for which it returns true
+// are sent to the subscriber.
 type SubInfo struct {
-	Category SubMsgCat
-	MsgTypes []MsgType
+	Category   SubMsgCat
+	Categories []SubMsgCat
+	MsgTypes   []MsgType
+	Filter     func(*Message) bool
+}
+
+// narrowCategories returns the narrow categories (see msgCategory()) covered
+// by the subscription's Categories, or by its Category if there aren't any.
+func (o SubInfo) narrowCategories() []SubMsgCat {
+	if len(o.Categories) == 0 {
+		return expandCategory(o.Category)
+	}
+	var result []SubMsgCat
+	seen := make(map[SubMsgCat]bool)
+	for _, requested := range o.Categories {
+		for _, msgCat := range expandCategory(requested) {
+			if !seen[msgCat] {
+				seen[msgCat] = true
+				result = append(result, msgCat)
+			}
+		}
+	}
+	return result
 }
 
 // NewMessagePager returns an implementation of MessagePager
 func NewMessagePager() MessagePager {
+	return NewMessagePagerWithHistory(0)
+}
+
+// NewMessagePagerWithHistory returns an implementation of MessagePager which
+// remembers the last n distributed messages. New subscribers immediately
+// receive any remembered messages which match their subscription, so that a
+// late subscriber doesn't miss (say) the ConnectedRequest/ConnectedResponse
+// handshake.
+func NewMessagePagerWithHistory(n int) MessagePager {
 	return &eidcMessagePager{
 		mu:           &sync.Mutex{},
 		timeout:      100 * time.Millisecond,
-		typesToChans: make(map[MsgType]map[chan Message]struct{}),
-		catsToChans:  make(map[SubMsgCat]map[chan Message]struct{}),
+		typesToChans: make(map[MsgType]map[chan Message]func(*Message) bool),
+		catsToChans:  make(map[SubMsgCat]map[chan Message]func(*Message) bool),
+		historySize:  n,
 	}
 }
 
@@ -94,82 +130,148 @@ type MessagePager interface {
 type eidcMessagePager struct {
 	mu           *sync.Mutex
 	timeout      time.Duration
-	typesToChans map[MsgType]map[chan Message]struct{}
-	catsToChans  map[SubMsgCat]map[chan Message]struct{}
+	typesToChans map[MsgType]map[chan Message]func(*Message) bool
+	catsToChans  map[SubMsgCat]map[chan Message]func(*Message) bool
+	history      []Message
+	historySize  int
+	timeouts     uint64
 }
 
 func (o *eidcMessagePager) DistributeMessage(msg *Message) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
-	// Figure out what category matches this message
-	var req, resp bool
-	if msg.Request != nil {
-		req = true
-	}
-	if msg.Response != nil {
-		resp = true
-	}
-	dir := msg.Direction()
-	var thisMsgCategory SubMsgCat
-	switch dir {
-	case Northbound:
-		if req {
-			thisMsgCategory = SubMsgCatAnyNBReq
-		}
-		if resp {
-			thisMsgCategory = SubMsgCatAnyNBResp
-		}
-	case Southbound:
-		if req {
-			thisMsgCategory = SubMsgCatAnySBReq
-		}
-		if resp {
-			thisMsgCategory = SubMsgCatAnySBResp
+	// Remember the message for late subscribers
+	if o.historySize > 0 {
+		if len(o.history) >= o.historySize {
+			o.history = o.history[1:]
 		}
+		o.history = append(o.history, *msg)
 	}
 
-	sendTo := func(c chan Message) {
+	// Figure out what category matches this message
+	thisMsgCategory := msgCategory(msg)
+
+	sendTo := func(c chan Message, filter func(*Message) bool) {
+		if filter != nil && !filter(msg) {
+			return
+		}
 		timer := time.NewTimer(o.timeout)
 		select {
 		case c <- *msg:
 			timer.Stop()
 		case <-timer.C:
+			o.timeouts++
 		}
 	}
 
 	// Send message to all message category channels
-	for c := range o.catsToChans[thisMsgCategory] {
-		sendTo(c)
+	for c, filter := range o.catsToChans[thisMsgCategory] {
+		sendTo(c, filter)
 	}
 
 	// Send message to all type-specific channels
-	for c := range o.typesToChans[msg.GetType()] {
-		sendTo(c)
+	for c, filter := range o.typesToChans[msg.GetType()] {
+		sendTo(c, filter)
 	}
 }
 
+// Timeouts returns the number of messages which weren't delivered because a
+// subscriber wasn't ready for them.
+func (o *eidcMessagePager) Timeouts() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.timeouts
+}
+
 func (o *eidcMessagePager) Subscribe(info SubInfo) (<-chan Message, func()) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
+	c := make(chan Message)
+	var live <-chan Message
+	var unsub func()
 	if len(info.MsgTypes) > 0 {
-		return o.subscribeByType(info.MsgTypes)
+		live, unsub = o.subscribeByType(c, info.MsgTypes, info.Filter)
+	} else {
+		live, unsub = o.subscribeByCategory(c, info.narrowCategories(), info.Filter)
 	}
-	return o.subscribeByCategory(info.Category)
+
+	replay := o.replayFor(info)
+	if len(replay) == 0 {
+		return live, unsub
+	}
+	return withReplay(replay, live, unsub)
 }
 
-func (o *eidcMessagePager) subscribeByType(msgTypes []MsgType) (<-chan Message, func()) {
+// withReplay returns a channel which delivers the replayed messages followed
+// by those arriving on live, and a function which ends the subscription. The
+// delivery happens in a goroutine, so the subscriber's channel is the usual
+// unbuffered one no matter how much history is replayed. Newly distributed
+// messages wait (subject to the pager's timeout) until the replay has been
+// received.
+func withReplay(replay []Message, live <-chan Message, unsubLive func()) (<-chan Message, func()) {
 	c := make(chan Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(c)
+		for _, msg := range replay {
+			select {
+			case c <- msg:
+			case <-done:
+				return
+			}
+		}
+		for msg := range live {
+			select {
+			case c <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return c, func() {
+		close(done)
+		unsubLive()
+	}
+}
+
+// replayFor returns the remembered messages which match the subscription.
+func (o *eidcMessagePager) replayFor(info SubInfo) []Message {
+	var result []Message
+	for i := range o.history {
+		msg := &o.history[i]
+		var match bool
+		if len(info.MsgTypes) > 0 {
+			for _, msgType := range info.MsgTypes {
+				if msg.GetType() == msgType {
+					match = true
+				}
+			}
+		} else {
+			for _, msgCat := range info.narrowCategories() {
+				if msgCategory(msg) == msgCat {
+					match = true
+				}
+			}
+		}
+		if match && (info.Filter == nil || info.Filter(msg)) {
+			result = append(result, *msg)
+		}
+	}
+	return result
+}
+
+func (o *eidcMessagePager) subscribeByType(c chan Message, msgTypes []MsgType, filter func(*Message) bool) (<-chan Message, func()) {
 	for _, msgType := range msgTypes { // Loop over subscriber's message types
 		// Create the map for this type of message if it doesn't already exist
 		chanMapForThisType := o.typesToChans[msgType]
 		if chanMapForThisType == nil {
-			chanMapForThisType = make(map[chan Message]struct{})
+			chanMapForThisType = make(map[chan Message]func(*Message) bool)
 			o.typesToChans[msgType] = chanMapForThisType
 		}
 		// Add the subscriber's channel to the map
-		chanMapForThisType[c] = struct{}{}
+		chanMapForThisType[c] = filter
 	}
 
 	// Create the unsubscribe function for this subscriber,
@@ -188,33 +290,16 @@ func (o *eidcMessagePager) subscribeByType(msgTypes []MsgType) (<-chan Message,
 	}
 }
 
-func (o *eidcMessagePager) subscribeByCategory(requested SubMsgCat) (<-chan Message, func()) {
-	var msgCats []SubMsgCat
-	switch requested {
-	case SubMsgCatAny:
-		msgCats = []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnyNBResp,
-			SubMsgCatAnySBReq, SubMsgCatAnySBResp}
-	case SubMsgCatAnyNB:
-		msgCats = []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnyNBResp}
-	case SubMsgCatAnySB:
-		msgCats = []SubMsgCat{SubMsgCatAnySBReq, SubMsgCatAnySBResp}
-	case SubMsgCatAnyReq:
-		msgCats = []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnySBReq}
-	case SubMsgCatAnyResp:
-		msgCats = []SubMsgCat{SubMsgCatAnyNBResp, SubMsgCatAnySBResp}
-	default:
-		msgCats = []SubMsgCat{requested}
-	}
-	c := make(chan Message)
+func (o *eidcMessagePager) subscribeByCategory(c chan Message, msgCats []SubMsgCat, filter func(*Message) bool) (<-chan Message, func()) {
 	for _, msgCat := range msgCats { // Loop over subscriber's message categories
 		// Create the map for this type of message if it doesn't already exist
 		chanMapForThisCategory := o.catsToChans[msgCat]
 		if chanMapForThisCategory == nil {
-			chanMapForThisCategory = make(map[chan Message]struct{})
+			chanMapForThisCategory = make(map[chan Message]func(*Message) bool)
 			o.catsToChans[msgCat] = chanMapForThisCategory
 		}
 		// Add the subscriber's channel to the map
-		chanMapForThisCategory[c] = struct{}{}
+		chanMapForThisCategory[c] = filter
 	}
 
 	// Create the unsubscribe function for this subscriber,
@@ -232,3 +317,54 @@ func (o *eidcMessagePager) subscribeByCategory(requested SubMsgCat) (<-chan Mess
 		close(c)
 	}
 }
+
+// expandCategory translates a (possibly broad) subscription category into the
+// narrow categories which are assigned to individual messages.
+func expandCategory(requested SubMsgCat) []SubMsgCat {
+	switch requested {
+	case SubMsgCatAny:
+		return []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnyNBResp,
+			SubMsgCatAnySBReq, SubMsgCatAnySBResp}
+	case SubMsgCatAnyNB:
+		return []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnyNBResp}
+	case SubMsgCatAnySB:
+		return []SubMsgCat{SubMsgCatAnySBReq, SubMsgCatAnySBResp}
+	case SubMsgCatAnyReq:
+		return []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnySBReq}
+	case SubMsgCatAnyResp:
+		return []SubMsgCat{SubMsgCatAnyNBResp, SubMsgCatAnySBResp}
+	default:
+		return []SubMsgCat{requested}
+	}
+}
+
+// msgCategory returns the narrow category (direction + request/response)
+// which matches the message.
+func msgCategory(msg *Message) SubMsgCat {
+	var req, resp bool
+	if msg.Request != nil {
+		req = true
+	}
+	if msg.Response != nil {
+		resp = true
+	}
+	dir := msg.Direction()
+	var thisMsgCategory SubMsgCat
+	switch dir {
+	case Northbound:
+		if req {
+			thisMsgCategory = SubMsgCatAnyNBReq
+		}
+		if resp {
+			thisMsgCategory = SubMsgCatAnyNBResp
+		}
+	case Southbound:
+		if req {
+			thisMsgCategory = SubMsgCatAnySBReq
+		}
+		if resp {
+			thisMsgCategory = SubMsgCatAnySBResp
+		}
+	}
+	return thisMsgCategory
+}
@@ -0,0 +1,212 @@
+package eidc32proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubInfoFilter(t *testing.T) {
+	eventMsg := func(eventID int, cardCode int) *Message {
+		payload := fmt.Sprintf(`{"eventId":%d,"eventType":%d,"siteCode":12,"cardCode":%d}`,
+			eventID, EventAccessGranted, cardCode)
+		raw := fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+			"Host: intellim.example.com\r\n"+
+			"Content-Type: application/json\r\n"+
+			"Content-Length: %d\r\n\r\n%s", EventRequestURI, len(payload), payload)
+		msg, err := ReadMsg([]byte(raw), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	cardCode := 1234
+	pager := NewMessagePager()
+	filtered, unsubFiltered := pager.Subscribe(SubInfo{
+		MsgTypes: []MsgType{MsgTypeEventRequest},
+		Filter: func(msg *Message) bool {
+			er, err := msg.ParseEventRequest()
+			return err == nil && er.CardCode == cardCode
+		},
+	})
+	defer unsubFiltered()
+	unfiltered, unsubUnfiltered := pager.Subscribe(SubInfo{Category: SubMsgCatAnyNBReq})
+	defer unsubUnfiltered()
+
+	// collect whatever each subscriber receives
+	collect := func(c <-chan Message, result chan<- []int) {
+		var ids []int
+		for {
+			select {
+			case msg := <-c:
+				er, err := msg.ParseEventRequest()
+				if err != nil {
+					t.Error(err)
+				}
+				ids = append(ids, er.EventID)
+			case <-time.After(300 * time.Millisecond):
+				result <- ids
+				return
+			}
+		}
+	}
+	filteredResult := make(chan []int)
+	unfilteredResult := make(chan []int)
+	go collect(filtered, filteredResult)
+	go collect(unfiltered, unfilteredResult)
+
+	pager.DistributeMessage(eventMsg(1, 1111))
+	pager.DistributeMessage(eventMsg(2, cardCode))
+	pager.DistributeMessage(eventMsg(3, 5678))
+
+	ids := <-filteredResult
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("filtered subscriber expected only event 2, got %v", ids)
+	}
+
+	ids = <-unfilteredResult
+	if len(ids) != 3 {
+		t.Fatalf("unfiltered subscriber expected 3 events, got %v", ids)
+	}
+}
+
+func TestNewMessagePagerWithHistory(t *testing.T) {
+	readMsg := func(raw string, dir Direction) *Message {
+		msg, err := ReadMsg([]byte(raw), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	connReqBody := `{"serialNumber":"0x000000123456", "firmwareVersion":"3.4.20", "ipAddress":"172.16.50.50", "macAddress":"00:14:E4:12:34:56", "siteKey":"xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxx", "configurationKey":"", "cardFormat":"short"}`
+	connReq := readMsg(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+		"Host: intellim.example.com\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", ConnectedRequestURI, len(connReqBody), connReqBody), Northbound)
+	connResp := readMsg("HTTP/1.1 200 OK\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: 32\r\n\r\n"+
+		`{"serverKey":"xxxxxxxxxxxxxxxx"}`, Southbound)
+	heartbeat := readMsg("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n"+
+		"Host: 192.168.6.40\r\n"+
+		"User-Agent: eIDCListener\r\n\r\n", Southbound)
+
+	expectTypes := func(c <-chan Message, expected ...MsgType) {
+		for _, e := range expected {
+			select {
+			case msg := <-c:
+				if msg.GetType() != e {
+					t.Fatalf("expected %s, got %s", e, msg.GetType())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %s", e)
+			}
+		}
+		select {
+		case msg := <-c:
+			t.Fatalf("unexpected %s", msg.GetType())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	pager := NewMessagePagerWithHistory(10)
+	noHistory := NewMessagePager()
+	for _, p := range []MessagePager{pager, noHistory} {
+		p.DistributeMessage(connReq)
+		p.DistributeMessage(connResp)
+	}
+
+	// late subscribers to the history pager get the handshake
+	all, unsubAll := pager.Subscribe(SubInfo{Category: SubMsgCatAny})
+	defer unsubAll()
+	if cap(all) != 0 {
+		t.Fatalf("expected an unbuffered channel, got capacity %d", cap(all))
+	}
+	expectTypes(all, MsgTypeConnectedRequest, MsgTypeConnectedResponse)
+
+	// replay respects the subscription
+	resp, unsubResp := pager.Subscribe(SubInfo{MsgTypes: []MsgType{MsgTypeConnectedResponse}})
+	defer unsubResp()
+	expectTypes(resp, MsgTypeConnectedResponse)
+
+	// unsubscribing with the replay unread closes the channel
+	unread, unsubUnread := pager.Subscribe(SubInfo{Category: SubMsgCatAny})
+	unsubUnread()
+	for range unread {
+	}
+
+	// new messages follow the replay as usual
+	go pager.DistributeMessage(heartbeat)
+	expectTypes(all, MsgTypeHeartbeatRequest)
+
+	// the default pager doesn't replay anything
+	none, unsubNone := noHistory.Subscribe(SubInfo{Category: SubMsgCatAny})
+	defer unsubNone()
+	expectTypes(none)
+}
+
+func TestSubInfoCategories(t *testing.T) {
+	readMsg := func(raw []byte, dir Direction) *Message {
+		msg, err := ReadMsg(raw, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	nbReq := readMsg(eidcEventBytes(1, EventAccessGranted), Northbound)
+	nbResp := readMsg(eidcResponseBytes(HeartbeatResponseCmd, ""), Northbound)
+	sbReq := readMsg([]byte("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n"+
+		"Host: 192.168.6.40\r\n"+
+		"User-Agent: eIDCListener\r\n\r\n"), Southbound)
+	sbResp := readMsg([]byte("HTTP/1.1 200 OK\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: 32\r\n\r\n"+
+		`{"serverKey":"xxxxxxxxxxxxxxxx"}`), Southbound)
+
+	pager := NewMessagePager()
+	distribute := func() {
+		for _, msg := range []*Message{nbReq, nbResp, sbReq, sbResp} {
+			pager.DistributeMessage(msg)
+		}
+	}
+	expectTypes := func(c <-chan Message, expected ...MsgType) {
+		for _, e := range expected {
+			select {
+			case msg := <-c:
+				if msg.GetType() != e {
+					t.Fatalf("expected %s, got %s", e, msg.GetType())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %s", e)
+			}
+		}
+		select {
+		case msg := <-c:
+			t.Fatalf("unexpected %s", msg.GetType())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// two narrow categories on one channel
+	c, unsub := pager.Subscribe(SubInfo{Categories: []SubMsgCat{SubMsgCatAnyNBReq, SubMsgCatAnySBResp}})
+	go distribute()
+	expectTypes(c, MsgTypeEventRequest, MsgTypeConnectedResponse)
+	unsub()
+
+	// overlapping broad categories deliver each message once
+	c, unsub = pager.Subscribe(SubInfo{Categories: []SubMsgCat{SubMsgCatAnyNB, SubMsgCatAnyReq}})
+	go distribute()
+	expectTypes(c, MsgTypeEventRequest, MsgTypeHeartbeatResponse, MsgTypeHeartbeatRequest)
+	unsub()
+
+	// unsubscribing cleaned up every category
+	p := pager.(*eidcMessagePager)
+	p.mu.Lock()
+	remaining := len(p.catsToChans)
+	p.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("expected no category subscriptions, found %d", remaining)
+	}
+}
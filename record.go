@@ -0,0 +1,133 @@
+package eidc32proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// recordTimeFormat is the timestamp format of recorded message headers.
+const recordTimeFormat = time.RFC3339Nano
+
+// recorder keeps the messages relayed by a Session while recording is
+// enabled, and writes them to the capture file (if any).
+type recorder struct {
+	mu        sync.Mutex
+	recording bool
+	msgs      []recordedMsg
+	capture   io.WriteCloser
+}
+
+// recordedMsg is a message exactly as it was written to one side of a
+// session, along with the time it was written.
+type recordedMsg struct {
+	dir   Direction
+	at    time.Time
+	bytes []byte
+}
+
+// writeTo writes the message in the recording format: a header line holding
+// the direction, the time and the length of the message, followed by the
+// message bytes exactly as written, followed by a newline. The length makes
+// the format safe for messages which contain newlines of their own.
+func (o recordedMsg) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %s %d\n", o.dir, o.at.Format(recordTimeFormat), len(o.bytes))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(o.bytes)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// SetRecording starts (or stops) keeping a copy of every message the session
+// relays or injects, exactly as written to the eIDC32 or IntelliM, for use by
+// Replay(). Dropped messages aren't recorded. Recordings are kept in memory
+// for the life of the session, so only enable it when you intend to replay.
+// Stopping doesn't discard messages already recorded.
+func (o *Session) SetRecording(enable bool) {
+	o.recorder.mu.Lock()
+	o.recorder.recording = enable
+	o.recorder.mu.Unlock()
+}
+
+// captureTo writes every message the session relays or injects from now on
+// to w, in the recording format, independent of SetRecording(). w is closed
+// when the session ends, or after the first write error.
+func (o *Session) captureTo(w io.WriteCloser) {
+	o.recorder.mu.Lock()
+	o.recorder.capture = w
+	o.recorder.mu.Unlock()
+
+	go func() {
+		<-o.tellMeWhenItsOver()
+		o.recorder.mu.Lock()
+		if o.recorder.capture == w {
+			o.recorder.capture = nil
+			w.Close()
+		}
+		o.recorder.mu.Unlock()
+	}()
+}
+
+// record keeps a copy of b, which was just written in direction dir, if the
+// session is recording, and writes it to the capture file, if any.
+func (o *Session) record(dir Direction, b []byte) {
+	o.recorder.mu.Lock()
+	defer o.recorder.mu.Unlock()
+	if !o.recorder.recording && o.recorder.capture == nil {
+		return
+	}
+
+	m := recordedMsg{
+		dir:   dir,
+		at:    time.Now(),
+		bytes: append([]byte{}, b...),
+	}
+	if o.recorder.recording {
+		o.recorder.msgs = append(o.recorder.msgs, m)
+	}
+	if o.recorder.capture != nil {
+		if err := m.writeTo(o.recorder.capture); err != nil {
+			o.recorder.capture.Close()
+			o.recorder.capture = nil
+		}
+	}
+}
+
+// Replay writes the recorded messages (see SetRecording()) which went in
+// direction dir to dst, in order. This lets a captured conversation be
+// reproduced against (say) a test IntelliM: replay the Northbound messages
+// into a connection to it. If preserveTiming is true, the original gaps
+// between messages are reproduced, otherwise messages are written as fast as
+// dst accepts them. Replay doesn't read from dst.
+func (o *Session) Replay(dst net.Conn, dir Direction, preserveTiming bool) error {
+	o.recorder.mu.Lock()
+	var msgs []recordedMsg
+	for _, m := range o.recorder.msgs {
+		if m.dir == dir {
+			msgs = append(msgs, m)
+		}
+	}
+	o.recorder.mu.Unlock()
+
+	var start, firstAt time.Time
+	for i, m := range msgs {
+		if preserveTiming {
+			if i == 0 {
+				start, firstAt = time.Now(), m.at
+			} else {
+				time.Sleep(time.Until(start.Add(m.at.Sub(firstAt))))
+			}
+		}
+		if _, err := dst.Write(m.bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package eidc32proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSession_Replay(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	// not recorded: recording hasn't started yet
+	if _, err := eidc.Write(eidcEventBytes(99, EventAccessGranted)); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	time.Sleep(50 * time.Millisecond) // let the relay finish with it
+
+	session.SetRecording(true)
+
+	gap := 100 * time.Millisecond
+	eventIDs := []int{100, 101, 102}
+	for i, id := range eventIDs {
+		if i > 0 {
+			time.Sleep(gap)
+		}
+		if _, err := eidc.Write(eidcEventBytes(id, EventAccessGranted)); err != nil {
+			t.Fatal(err)
+		}
+		<-toServer
+	}
+
+	// southbound messages are recorded separately
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Inject(*hb, nil)
+	<-fromProxy
+
+	for _, preserveTiming := range []bool{false, true} {
+		replaySrc, replayDst := net.Pipe()
+		replayed := pipeMsgChan(replayDst, Northbound)
+
+		start := time.Now()
+		errChan := make(chan error, 1)
+		go func() { errChan <- session.Replay(replaySrc, Northbound, preserveTiming) }()
+
+		for _, id := range eventIDs {
+			select {
+			case msg := <-replayed:
+				er, err := msg.ParseEventRequest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if er.EventID != id {
+					t.Fatalf("expected event %d, got %d", id, er.EventID)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for replay of event %d", id)
+			}
+		}
+		if err = <-errChan; err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+
+		minimum := time.Duration(len(eventIDs)-1) * gap
+		if preserveTiming && elapsed < minimum {
+			t.Fatalf("timed replay took %s, expected at least %s", elapsed, minimum)
+		}
+		if !preserveTiming && elapsed >= minimum {
+			t.Fatalf("untimed replay took %s, expected less than %s", elapsed, minimum)
+		}
+
+		replaySrc.Close()
+		replayDst.Close()
+		if msg, ok := <-replayed; ok {
+			t.Fatalf("unexpected replay of %s", msg.Type)
+		}
+	}
+}
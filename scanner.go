@@ -0,0 +1,73 @@
+package eidc32proxy
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	scannerInitialBufSize = 1 << 10
+	scannerMaxMsgSize     = 1 << 20
+)
+
+// MessageScanner reads a stream of concatenated HTTP messages (a network
+// connection, a capture file...) and parses them one at a time. Use it like
+// a bufio.Scanner: call Scan() until it returns false, collecting each
+// message with Message(), then check Err().
+//
+// A message which doesn't parse doesn't end the scan: SplitHttpMsg has
+// already found where it ends, so Message() returns the parse error for that
+// one message and the next Scan() carries on with the one after it.
+type MessageScanner struct {
+	scanner *bufio.Scanner
+	dir     Direction
+	raw     []byte
+	msg     *Message
+	err     error
+}
+
+// NewMessageScanner returns a MessageScanner reading messages traveling in
+// direction dir from r.
+func NewMessageScanner(r io.Reader, dir Direction) *MessageScanner {
+	s := bufio.NewScanner(r)
+	s.Split(SplitHttpMsg)
+	s.Buffer(make([]byte, scannerInitialBufSize), scannerMaxMsgSize)
+	return &MessageScanner{
+		scanner: s,
+		dir:     dir,
+	}
+}
+
+// Scan advances to the next message, which is then available from Message()
+// and Bytes(). It returns false at the end of the stream or on a read error,
+// see Err().
+func (o *MessageScanner) Scan() bool {
+	o.raw, o.msg, o.err = nil, nil, nil
+	if !o.scanner.Scan() {
+		return false
+	}
+	// the scanner reuses its buffer, and the message holds onto its
+	// original bytes, so take a copy
+	o.raw = append([]byte(nil), o.scanner.Bytes()...)
+	o.msg, o.err = ReadMsg(o.raw, o.dir)
+	return true
+}
+
+// Message returns the message read by the most recent call to Scan(), along
+// with the error (if any) from parsing it. The message may be partially
+// populated when the error is non-nil, as with ReadMsg().
+func (o *MessageScanner) Message() (*Message, error) {
+	return o.msg, o.err
+}
+
+// Bytes returns the raw bytes of the message read by the most recent call to
+// Scan(), whether or not they parsed.
+func (o *MessageScanner) Bytes() []byte {
+	return o.raw
+}
+
+// Err returns the error, if any, which ended the scan. It's nil if the scan
+// ended at io.EOF.
+func (o *MessageScanner) Err() error {
+	return o.scanner.Err()
+}
@@ -0,0 +1,81 @@
+package eidc32proxy
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMessageScanner(t *testing.T) {
+	f, err := os.Open("test_northbound.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s := NewMessageScanner(f, Northbound)
+	var types []MsgType
+	for s.Scan() {
+		msg, err := s.Message()
+		if err != nil {
+			t.Fatalf("message %d: %s", len(types), err)
+		}
+		if msg.Direction() != Northbound {
+			t.Fatalf("message %d: expected %s, got %s", len(types), Northbound, msg.Direction())
+		}
+		if !bytes.Equal(msg.OrigBytes(), s.Bytes()) {
+			t.Fatalf("message %d: Bytes() doesn't match the message", len(types))
+		}
+		types = append(types, msg.Type)
+	}
+	if err = s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MsgType{
+		MsgTypeConnectedRequest,
+		MsgTypeGetoutboundResponse,
+		MsgTypeSetTimeResponse,
+		MsgTypeSetWebUserResponse,
+		MsgTypeEnableEventsResponse,
+	}
+	if len(types) < len(expected) {
+		t.Fatalf("expected at least %d messages, got %d", len(expected), len(types))
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatalf("message %d: expected %s, got %s", i, expected[i], types[i])
+		}
+	}
+}
+
+func TestMessageScannerBadMessage(t *testing.T) {
+	stream := append([]byte("not\r\nhttp\r\n\r\n"), eidcResponseBytes(HeartbeatResponseCmd, "")...)
+	s := NewMessageScanner(bytes.NewReader(stream), Northbound)
+
+	if !s.Scan() {
+		t.Fatal("expected a message")
+	}
+	_, err := s.Message()
+	if _, ok := err.(*ErrNotHTTP); !ok {
+		t.Fatalf("expected ErrNotHTTP, got %v", err)
+	}
+
+	if !s.Scan() {
+		t.Fatal("expected the scan to continue past the bad message")
+	}
+	msg, err := s.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != MsgTypeHeartbeatResponse {
+		t.Fatalf("expected %s, got %s", MsgTypeHeartbeatResponse, msg.Type)
+	}
+
+	if s.Scan() {
+		t.Fatal("expected the end of the stream")
+	}
+	if s.Err() != nil {
+		t.Fatal(s.Err())
+	}
+}
@@ -6,35 +6,57 @@ LD_LIBRARY_PATH=/opt/openssl-1.1.1/lib/:$LD_LIBRARY_PATH openssl s_client -ciphe
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/chrismarget/terribletls"
-	"io"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	network       = "tcp4"
 	errConnClosed = "use of closed network connection"
-	keyLogFile    = ".eidc32proxy.keys"
+)
+
+// DuplicateSessionPolicy determines what the Server does when a new session
+// turns up with the same eIDC32 serial number as an active session. This can
+// happen when an eIDC32's primary and secondary hosts both point at the proxy,
+// in which case commands from the two IntelliM connections may race.
+type DuplicateSessionPolicy uint8
+
+const (
+	DuplicateSessionAllow    DuplicateSessionPolicy = iota // warn, keep both sessions
+	DuplicateSessionReject                                 // warn, close the new session
+	DuplicateSessionCloseOld                               // warn, close the existing session(s)
 )
 
 type Server struct {
 	tlsConfig   *terribletls.Config
 	nl          net.Listener
 	stop        chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
 	sessions    map[int]*Session
+	sessMutex   *sync.Mutex
+	dupPolicy   DuplicateSessionPolicy
 	err         chan error
 	sessChMap   map[chan *Session]struct{}
 	sessChMutex *sync.Mutex
+	tagger      func(LoginInfo) string
+	autoAck     bool
+	idleTimeout time.Duration
+	captureDir  string
 }
 
 // NewServer returns an eidc32proxy Server object. It takes the TLS details as
@@ -46,7 +68,7 @@ func NewServer(x509Cert *x509.Certificate, privkey *rsa.PrivateKey) (Server, err
 	var tlsConfig *terribletls.Config
 
 	if x509Cert != nil && privkey != nil {
-		keyLog, err := keyLogWriter()
+		keyLog, err := keyLogWriter(true)
 		if err != nil {
 			return Server{}, err
 		}
@@ -87,10 +109,14 @@ func NewServer(x509Cert *x509.Certificate, privkey *rsa.PrivateKey) (Server, err
 		}
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return Server{
+		ctx:         ctx,
+		cancel:      cancel,
 		err:         make(chan error),
 		stop:        make(chan struct{}),
 		sessions:    make(map[int]*Session),
+		sessMutex:   &sync.Mutex{},
 		sessChMap:   make(map[chan *Session]struct{}),
 		sessChMutex: &sync.Mutex{},
 		tlsConfig:   tlsConfig,
@@ -100,20 +126,37 @@ func NewServer(x509Cert *x509.Certificate, privkey *rsa.PrivateKey) (Server, err
 // Serve loops forever handing off new connections to initSession().
 // It returns an error if there's a problem prior to starting the client
 // handling loop. Any errors encountered in the client handling loop
-// are returned on the server's "Err" channel.
-func (o Server) Serve(port int) error {
-	var nl net.Listener
-	var err error
-
-	laddr := ":" + strconv.Itoa(port)
-	if o.tlsConfig != nil {
-		nl, err = terribletls.Listen(network, laddr, o.tlsConfig)
-	} else {
-		nl, err = net.Listen(network, laddr)
-	}
+// are returned on the server's "Err" channel. Serve listens on port on all
+// IPv4 interfaces. Use ServeOn() to pick an interface or IPv6.
+func (o *Server) Serve(port int) error {
+	return o.ServeOn(network, ":"+strconv.Itoa(port))
+}
+
+// ServeOn is like Serve, but listens on any network and address accepted by
+// net.Listen(), e.g. ("tcp6", "[::1]:18800") or ("tcp", "127.0.0.1:0"). Use
+// Addr() to find the port chosen when addr specifies port 0.
+func (o *Server) ServeOn(network string, addr string) error {
+	nl, err := net.Listen(network, addr)
 	if err != nil {
 		return err
 	}
+	return o.ServeListener(nl)
+}
+
+// ServeListener is like Serve, but accepts connections from an existing
+// listener, e.g. one inherited from systemd socket activation, or created by
+// a test. The server takes ownership of nl and closes it on Stop().
+//
+// If the server was created with a certificate, TLS is set up on each
+// accepted connection (rather than by wrapping nl with terribletls.Listen())
+// so that we get a look at the ClientHello, so nl should be a plain listener.
+// A caller which wraps nl in TLS itself should create the server without a
+// certificate; its sessions won't have TLSInfo.
+func (o *Server) ServeListener(nl net.Listener) error {
+	if nl == nil {
+		return errors.New("nil listener")
+	}
+	o.nl = nl
 
 	// loop accepting incoming connections
 	go o.serve(nl)
@@ -129,20 +172,13 @@ func (o Server) Serve(port int) error {
 	return nil
 }
 
-func keyLogWriter() (io.Writer, error) {
-	keyLogDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
-	}
-
-	keyLogFile := filepath.Join(keyLogDir, keyLogFile)
-
-	err = os.MkdirAll(filepath.Dir(keyLogFile), os.FileMode(0644))
-	if err != nil {
-		return nil, err
+// Addr returns the address the server is listening on, or nil if it hasn't
+// been started with Serve(), ServeOn() or ServeListener().
+func (o *Server) Addr() net.Addr {
+	if o.nl == nil {
+		return nil
 	}
-
-	return os.OpenFile(keyLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return o.nl.Addr()
 }
 
 func (o *Server) serve(nl net.Listener) {
@@ -161,26 +197,135 @@ func (o *Server) serve(nl net.Listener) {
 		}
 
 		// connection accepted, init session
-		go func(id int) {
+		go func(id int, conn net.Conn) {
+			var hello *helloRecorder
+			if o.tlsConfig != nil {
+				hello = &helloRecorder{Conn: conn}
+				conn = terribletls.Server(hello, o.tlsConfig)
+			}
+
 			//session, err := newSession(id, conn, o.eventInChan)
-			session, err := newSession(conn)
+			session, err := newSession(o.ctx, conn)
 			if err != nil {
 				o.err <- err
 				return
 			}
 
-			// announce the session to all interested channels
-			o.sessChMutex.Lock()
-			for c := range o.sessChMap {
-				c <- session
+			// newSession() has read from the eIDC, so the handshake is done.
+			if hello != nil {
+				session.TLSInfo, err = hello.tlsInfo()
+				if err != nil { // not fatal, the session just lacks a fingerprint
+					log.Printf("failed to fingerprint TLS client %s - %s",
+						session.Mitm.ClientSide.Client, err)
+				}
 			}
-			o.sessChMutex.Unlock()
 
-		}(sessionID)
+			if !o.registerSession(id, session) {
+				return
+			}
+
+			o.configureSession(session)
+			o.announceSession(session)
+		}(sessionID, conn)
 		sessionID++
 	}
 }
 
+// registerSession adds the session to the registry of active sessions,
+// applying the duplicate session policy if another active session has the
+// same serial number. Duplicates are logged, they're not server errors. It
+// returns false if the new session was rejected (and closed). Sessions
+// remove themselves from the registry when they end.
+func (o *Server) registerSession(id int, session *Session) bool {
+	serial := session.Serial()
+
+	o.sessMutex.Lock()
+	var dups []*Session
+	for _, s := range o.sessions {
+		if s.Serial() == serial {
+			dups = append(dups, s)
+		}
+	}
+
+	if len(dups) > 0 && o.dupPolicy == DuplicateSessionReject {
+		o.sessMutex.Unlock()
+		session.Close()
+		log.Printf("rejected session from %s: serial %s already has an active session",
+			session.Mitm.ClientSide.Client, serial)
+		return false
+	}
+
+	o.sessions[id] = session
+	o.sessMutex.Unlock()
+
+	go func() {
+		<-session.tellMeWhenItsOver()
+		o.sessMutex.Lock()
+		delete(o.sessions, id)
+		o.sessMutex.Unlock()
+	}()
+
+	for _, dup := range dups {
+		switch o.dupPolicy {
+		case DuplicateSessionCloseOld:
+			dup.Close()
+			log.Printf("closed session from %s: serial %s has a new session from %s",
+				dup.Mitm.ClientSide.Client, serial, session.Mitm.ClientSide.Client)
+		default:
+			log.Printf("serial %s has concurrent sessions from %s and %s",
+				serial, dup.Mitm.ClientSide.Client, session.Mitm.ClientSide.Client)
+		}
+	}
+
+	return true
+}
+
+// configureSession applies the server's per-session options (tagging,
+// automatic event acks, idle timeout, capture) to a new session.
+func (o *Server) configureSession(session *Session) {
+	if o.tagger != nil {
+		session.Tag = o.tagger(session.LoginInfo)
+	}
+
+	if o.autoAck {
+		session.AddMangler(AutoAckEidcEvent{Session: session})
+	}
+
+	if o.idleTimeout > 0 {
+		session.SetIdleTimeout(o.idleTimeout)
+	}
+
+	if o.captureDir != "" {
+		f, err := o.createCaptureFile(session)
+		if err != nil { // carry on without capture
+			log.Printf("failed to create capture file for session from %s - %s",
+				session.Mitm.ClientSide.Client, err)
+		} else {
+			session.captureTo(f)
+		}
+	}
+}
+
+// createCaptureFile creates a new file in the capture directory, named for
+// the session's serial number and the current time.
+func (o *Server) createCaptureFile(session *Session) (*os.File, error) {
+	serial := strings.NewReplacer("/", "_", `\`, "_").Replace(session.Serial())
+	if serial == "" {
+		serial = "unknown"
+	}
+	name := fmt.Sprintf("%s-%s.rec", serial, time.Now().Format("20060102-150405.000000"))
+	return os.OpenFile(filepath.Join(o.captureDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// announceSession writes the session to all interested channels.
+func (o *Server) announceSession(session *Session) {
+	o.sessChMutex.Lock()
+	for c := range o.sessChMap {
+		c <- session
+	}
+	o.sessChMutex.Unlock()
+}
+
 func (o *Server) unsubEverybody() {
 	o.sessChMutex.Lock()
 	for c := range o.sessChMap {
@@ -190,8 +335,10 @@ func (o *Server) unsubEverybody() {
 	o.sessChMutex.Unlock()
 }
 
-// Stop stops the server by writing to the stop channel
+// Stop stops the server by writing to the stop channel. It also cancels the
+// server's context, which closes all of its sessions.
 func (o *Server) Stop() {
+	o.cancel()
 	o.stop <- struct{}{}
 }
 
@@ -210,6 +357,63 @@ func (o *Server) SubscribeSessions() chan *Session {
 	return c
 }
 
+// SetSessionTagger configures a function which derives a tag from each new
+// session's LoginInfo. The tag is stored in Session.Tag before the session is
+// announced to subscribers. The idea is to let logs from several proxy
+// instances (say, behind a load balancer) be correlated by deriving the tag
+// from the eIDC32's serial number and a deployment-wide nonce. Call it before
+// Serve().
+func (o *Server) SetSessionTagger(tagger func(LoginInfo) string) {
+	o.tagger = tagger
+}
+
+// SetAutoAckEvents configures the server to acknowledge northbound eIDC32
+// events on IntelliM's behalf (see AutoAckEidcEvent). This is the server-side
+// analog of client.TrueDat(), useful when nothing on the IntelliM side will
+// consume the events. Call it before Serve().
+func (o *Server) SetAutoAckEvents(enable bool) {
+	o.autoAck = enable
+}
+
+// SetIdleTimeout configures each new session to close itself when no
+// messages have been relayed in either direction for timeout (see
+// Session.SetIdleTimeout()). Zero (the default) disables the timeout. Call it
+// before Serve().
+func (o *Server) SetIdleTimeout(timeout time.Duration) {
+	o.idleTimeout = timeout
+}
+
+// SetCaptureDir configures the server to write every message relayed or
+// injected by each new session to a file in dir, for unattended capture
+// without wiring up a display. Each message is written as a header line
+// ("<direction> <time> <length>") followed by the message bytes exactly as
+// they went over the wire, and a newline. Files are named for the eIDC32's
+// serial number and the time the session began, and are closed when the
+// session ends. Errors creating the files are logged, and the session goes
+// on without capture. "" (the default) disables capture. Call it before
+// Serve().
+func (o *Server) SetCaptureDir(dir string) {
+	o.captureDir = dir
+}
+
+// SetContext sets the parent context for the server's sessions. Canceling ctx
+// closes all sessions (and aborts any in the process of connecting to
+// IntelliM), as does Stop(). Values carried by ctx are available from each
+// Session's Context(). Call it before Serve(): it cancels the context it
+// replaces, so any sessions begun before the call are closed rather than
+// left beyond the reach of Stop().
+func (o *Server) SetContext(ctx context.Context) {
+	o.cancel()
+	o.ctx, o.cancel = context.WithCancel(ctx)
+}
+
+// SetDuplicateSessionPolicy determines how the server handles a new session
+// whose serial number matches an already active session. The default is
+// DuplicateSessionAllow. Call it before Serve().
+func (o *Server) SetDuplicateSessionPolicy(policy DuplicateSessionPolicy) {
+	o.dupPolicy = policy
+}
+
 // UnSubscribeSessions allows a subscriber remove its channel from the
 // new session interest list by submitting it to this function.
 func (o *Server) UnSubscribeSessions(c chan *Session) {
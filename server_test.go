@@ -0,0 +1,416 @@
+package eidc32proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_SetSessionTagger(t *testing.T) {
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetSessionTagger(func(li LoginInfo) string {
+		return "nonce-" + li.ConnectedReq.SerialNumber
+	})
+
+	session, eidcPeer, serverPeer := newPipeSession(t)
+	defer eidcPeer.Close()
+	defer serverPeer.Close()
+	session.LoginInfo.ConnectedReq.SerialNumber = "0x000000123456"
+
+	sessChan := server.SubscribeSessions()
+	server.configureSession(session)
+	go server.announceSession(session)
+	announced := <-sessChan
+
+	expected := "nonce-0x000000123456"
+	if announced.Tag != expected {
+		t.Fatalf("expected tag '%s', got '%s'", expected, announced.Tag)
+	}
+
+	export, err := json.Marshal(announced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported struct{ Tag string }
+	err = json.Unmarshal(export, &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Tag != expected {
+		t.Fatalf("expected exported tag '%s', got '%s'", expected, exported.Tag)
+	}
+}
+
+func TestServer_SetContext(t *testing.T) {
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type key struct{}
+	old := server.ctx
+	server.SetContext(context.WithValue(context.Background(), key{}, "value"))
+	if old.Err() == nil {
+		t.Fatal("expected the replaced context to be canceled")
+	}
+	if server.ctx.Value(key{}) != "value" {
+		t.Fatal("expected the server's context to derive from the new one")
+	}
+
+	server.cancel() // as Stop() does
+	if server.ctx.Err() == nil {
+		t.Fatal("expected the new context to be canceled along with the server")
+	}
+}
+
+func TestServer_DuplicateSessionPolicy(t *testing.T) {
+	testData := []struct {
+		policy      DuplicateSessionPolicy
+		accepted    bool
+		firstAlive  bool
+		secondAlive bool
+	}{
+		{policy: DuplicateSessionAllow, accepted: true, firstAlive: true, secondAlive: true},
+		{policy: DuplicateSessionReject, accepted: false, firstAlive: true, secondAlive: false},
+		{policy: DuplicateSessionCloseOld, accepted: true, firstAlive: false, secondAlive: true},
+	}
+
+	// alive returns true if the session hasn't ended within the timeout
+	alive := func(s *Session, timeout time.Duration) bool {
+		select {
+		case <-s.tellMeWhenItsOver():
+			return false
+		case <-time.After(timeout):
+			return true
+		}
+	}
+
+	for _, td := range testData {
+		server, err := NewServer(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SetDuplicateSessionPolicy(td.policy)
+
+		var sessions []*Session
+		for i := 0; i < 2; i++ {
+			session, eidcPeer, serverPeer := newPipeSession(t)
+			defer eidcPeer.Close()
+			defer serverPeer.Close()
+			session.LoginInfo.ConnectedReq.SerialNumber = "0x000000123456"
+			sessions = append(sessions, session)
+		}
+
+		if !server.registerSession(0, sessions[0]) {
+			t.Fatal("first session must not be rejected")
+		}
+
+		logged := &bytes.Buffer{}
+		log.SetOutput(logged)
+		accepted := server.registerSession(1, sessions[1])
+		log.SetOutput(os.Stderr)
+
+		if !strings.Contains(logged.String(), "0x000000123456") {
+			t.Fatalf("policy %d: duplicate session was not logged", td.policy)
+		}
+		select {
+		case err := <-server.ErrChan():
+			t.Fatalf("policy %d: duplicate session reported as a server error: %s", td.policy, err)
+		default:
+		}
+
+		if accepted != td.accepted {
+			t.Fatalf("policy %d: expected accepted %t, got %t", td.policy, td.accepted, accepted)
+		}
+		if a := alive(sessions[0], 100*time.Millisecond); a != td.firstAlive {
+			t.Fatalf("policy %d: expected first session alive %t, got %t", td.policy, td.firstAlive, a)
+		}
+		if a := alive(sessions[1], 100*time.Millisecond); a != td.secondAlive {
+			t.Fatalf("policy %d: expected second session alive %t, got %t", td.policy, td.secondAlive, a)
+		}
+
+		expected := 0
+		if td.firstAlive {
+			expected++
+		}
+		if td.secondAlive {
+			expected++
+		}
+		// ended sessions leave the registry asynchronously
+		var registered int
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+			server.sessMutex.Lock()
+			registered = len(server.sessions)
+			server.sessMutex.Unlock()
+			if registered == expected {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if registered != expected {
+			t.Fatalf("policy %d: expected %d registered sessions, got %d", td.policy, expected, registered)
+		}
+	}
+}
+
+func TestServer_SetAutoAckEvents(t *testing.T) {
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAutoAckEvents(true)
+
+	session, eidcPeer, serverPeer := newPipeSession(t)
+	defer eidcPeer.Close()
+	defer serverPeer.Close()
+	fromProxy := pipeMsgChan(eidcPeer, Southbound)
+	toServer := pipeMsgChan(serverPeer, Northbound)
+	server.configureSession(session)
+
+	_, err = eidcPeer.Write(eidcEventBytes(300, EventAccessGranted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the event still reaches the server side...
+	select {
+	case msg := <-toServer:
+		if msg.Type != MsgTypeEventRequest {
+			t.Fatalf("expected %s, got %s", MsgTypeEventRequest, msg.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for relayed event")
+	}
+
+	// ...and the eIDC32 gets an ack for it.
+	select {
+	case msg := <-fromProxy:
+		ear, err := msg.ParseEventAckRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ear.EventIds) != 1 || ear.EventIds[0] != 300 {
+			t.Fatalf("expected ack for event 300, got %v", ear.EventIds)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event ack")
+	}
+
+	// the eIDC32's response to the synthesized ack must not reach the server
+	_, err = eidcPeer.Write(eidcResponseBytes(EventAckResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-toServer:
+		t.Fatalf("unexpected %s relayed to the server", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServer_ServeOn(t *testing.T) {
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr() != nil {
+		t.Fatal("expected nil Addr() before ServeOn()")
+	}
+
+	err = server.ServeOn("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop() closes the error channel, racing with the accept loop's
+	// report of the closed listener. Close the listener directly instead.
+	defer server.nl.Close()
+
+	addr, ok := server.Addr().(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("expected a *net.TCPAddr, got %T", server.Addr())
+	}
+	if !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("expected to listen on 127.0.0.1, got %s", addr.IP)
+	}
+	if addr.Port == 0 {
+		t.Fatal("expected a port to be chosen")
+	}
+
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// the accepted connection fails to produce a session
+	select {
+	case <-server.ErrChan():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the server to accept the connection")
+	}
+
+	go func() {
+		for range server.ErrChan() {
+		}
+	}()
+}
+
+func TestServer_ServeListener(t *testing.T) {
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.ServeListener(nil); err == nil {
+		t.Fatal("expected an error for a nil listener")
+	}
+
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.ServeListener(nl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// see TestServer_ServeOn
+	defer nl.Close()
+
+	if server.Addr().String() != nl.Addr().String() {
+		t.Fatalf("expected Addr() %s, got %s", nl.Addr(), server.Addr())
+	}
+
+	conn, err := net.DialTimeout("tcp", nl.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// the accepted connection fails to produce a session
+	select {
+	case <-server.ErrChan():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the server to accept the connection")
+	}
+
+	go func() {
+		for range server.ErrChan() {
+		}
+	}()
+}
+
+func TestServer_SetCaptureDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetCaptureDir(dir)
+
+	session, eidcPeer, serverPeer := newPipeSession(t)
+	defer eidcPeer.Close()
+	defer serverPeer.Close()
+	fromProxy := pipeMsgChan(eidcPeer, Southbound)
+	toServer := pipeMsgChan(serverPeer, Northbound)
+	session.LoginInfo.ConnectedReq.SerialNumber = "0x000000123456"
+	server.configureSession(session)
+
+	event := eidcEventBytes(100, EventAccessGranted)
+	if _, err = eidcPeer.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Inject(*hb, nil)
+	<-fromProxy
+	time.Sleep(50 * time.Millisecond) // let the relay record it
+
+	// the file is closed when the session ends
+	session.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		session.recorder.mu.Lock()
+		closed := session.recorder.capture == nil
+		session.recorder.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the capture file to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 capture file, got %d", len(files))
+	}
+	if !strings.HasPrefix(files[0].Name(), "0x000000123456-") {
+		t.Fatalf("expected capture file named for the serial number, got %s", files[0].Name())
+	}
+	capture, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dirs []string
+	r := bufio.NewReader(bytes.NewReader(capture))
+	for {
+		header, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			t.Fatalf("malformed capture header %q", header)
+		}
+		if _, err = time.Parse(recordTimeFormat, fields[1]); err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(fields[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, length+1)
+		if _, err = io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if fields[0] == Northbound.String() && !bytes.Equal(msg[:length], event) {
+			t.Fatalf("expected the captured event to match what the eIDC32 sent, got %q", msg[:length])
+		}
+		dirs = append(dirs, fields[0])
+	}
+
+	expected := []string{Northbound.String(), Southbound.String()}
+	if len(dirs) != len(expected) || dirs[0] != expected[0] || dirs[1] != expected[1] {
+		t.Fatalf("expected captured directions %v, got %v", expected, dirs)
+	}
+}
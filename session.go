@@ -2,16 +2,26 @@ package eidc32proxy
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/chrismarget/terribletls"
 	"net"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// eventHistorySize is the number of events kept for RecentEvents().
+const eventHistorySize = 256
+
+// defaultErrTimeout is how long each error subscriber gets to make room for
+// an error before missing it, see SetErrTimeout().
+const defaultErrTimeout = 100 * time.Millisecond
+
 // LoginInfo contains details from an eIDC32's initial connection to a
 // server. We save this separate from a Message structure because the
 // contents are needed to build the outbound half of the proxy connection
@@ -42,12 +52,67 @@ type Mitm struct {
 	ServerSide CxnDetail
 }
 
+// ClientIP returns the eIDC32's address as observed by the proxy, or an
+// empty string if the address can't be parsed.
+func (o Mitm) ClientIP() string {
+	host, _ := splitHostPort(o.ClientSide.Client)
+	return host
+}
+
+// ClientPort returns the eIDC32's source port as observed by the proxy, or
+// zero if the address can't be parsed.
+func (o Mitm) ClientPort() int {
+	_, port := splitHostPort(o.ClientSide.Client)
+	return port
+}
+
+// ServerIP returns the address of the IntelliM server the proxy connected
+// to, or an empty string if the address can't be parsed.
+func (o Mitm) ServerIP() string {
+	host, _ := splitHostPort(o.ServerSide.Server)
+	return host
+}
+
+// ServerPort returns the port of the IntelliM server the proxy connected
+// to, or zero if the address can't be parsed.
+func (o Mitm) ServerPort() int {
+	_, port := splitHostPort(o.ServerSide.Server)
+	return port
+}
+
+// splitHostPort is net.SplitHostPort() with the port converted to an int.
+// IPv6 addresses come back without their brackets.
+func splitHostPort(hostport string) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return host, 0
+	}
+	return host, port
+}
+
 // newSession handles an eIDC32 client connection (net.Conn), connects it to
 // the intended server. 'msgChan' is used to expose proxied http messages
 // between the eIDC32 and its server.
-func newSession(eidcCxn net.Conn) (*Session, error) {
-	// divine the eIDC32's intended server by peeking into
-	// the incoming socket data
+func newSession(ctx context.Context, eidcCxn net.Conn) (*Session, error) {
+	// todo: it'd be nice if we had the client's TLS parameters,
+	//  could emulate them when connecting to the server.
+	return newSessionDial(ctx, eidcCxn, func(ctx context.Context, host string) (net.Conn, error) {
+		return ConnectUsingTerribleTLSContext(ctx, host, network)
+	})
+}
+
+// newSessionDial is newSession, but uses dial to make the server half of the
+// session.
+func newSessionDial(ctx context.Context, eidcCxn net.Conn, dial func(context.Context, string) (net.Conn, error)) (*Session, error) {
+	// divine the eIDC32's intended server by peeking into the incoming
+	// socket data. Peeking leaves the login request in eidcRdr's buffer,
+	// along with anything the eIDC32 pipelined behind it which arrived in
+	// the same read. The relay scans eidcRdr rather than eidcCxn, so all of
+	// it gets relayed.
 	eidcRdr := bufio.NewReader(eidcCxn)
 	loginInfo, err := peekLoginInfo(eidcRdr)
 	if err != nil {
@@ -55,16 +120,29 @@ func newSession(eidcCxn net.Conn) (*Session, error) {
 	}
 
 	// Make the server half of the session
-	// todo: it'd be nice if we had the client's TLS parameters,
-	//  could emulate them when connecting to the server.
-	serverCxn, err := ConnectUsingTerribleTLS(loginInfo.Host)
+	serverCxn, err := dial(ctx, loginInfo.Host)
 	if err != nil {
 		return nil, err
 	}
+	return startSession(ctx, eidcCxn, eidcRdr, serverCxn, loginInfo), nil
+}
+
+// startSession builds a Session around an already-established pair of
+// connections and starts its relays. eidcRdr must be the reader which was
+// used to peek the login info from eidcCxn so that no buffered bytes are lost.
+// The session's context is derived from ctx. Canceling it closes the session.
+func startSession(ctx context.Context, eidcCxn net.Conn, eidcRdr *bufio.Reader, serverCxn net.Conn, loginInfo *LoginInfo) *Session {
 	serverRdr := bufio.NewReader(serverCxn)
+	ctx, cancel := context.WithCancel(ctx)
 	session := Session{
+		ctx:       ctx,
+		cancel:    cancel,
 		StartTime: time.Now(),
 		over:      &sync.WaitGroup{},
+		endOnce:   &sync.Once{},
+		done:      make(chan struct{}),
+		eidcCxn:   eidcCxn,
+		serverCxn: serverCxn,
 		LoginInfo: *loginInfo,
 		Mitm: Mitm{
 			ClientSide: CxnDetail{
@@ -76,17 +154,31 @@ func newSession(eidcCxn net.Conn) (*Session, error) {
 				Server: serverCxn.RemoteAddr().String(),
 			},
 		},
-		errSubMap:    make(map[chan error]struct{}),
-		errSubMutex:  &sync.Mutex{},
-		manglers:     make(map[int]Mangler),
-		mangleLock:   &sync.Mutex{},
-		sm:           &seqMangler{log: true},
-		relayMutex:   &sync.Mutex{},
-		injectChan:   make(map[Direction]chan *Message),
-		serverKeys:   []string{loginInfo.ServerKey},
-		intelliMhost: loginInfo.Host,
-		pointStatus:  make(map[int]Point),
-		Pager:        NewMessagePager(),
+		errSubMap:       make(map[chan error]struct{}),
+		errSubMutex:     &sync.Mutex{},
+		errTimeout:      defaultErrTimeout,
+		manglers:        make(map[int]Mangler),
+		hooks:           make(map[int]func(*Message)),
+		hookLock:        &sync.Mutex{},
+		mangleLock:      &sync.Mutex{},
+		sm:              &seqMangler{log: true},
+		relayMutex:      &sync.Mutex{},
+		pauseLock:       &sync.Mutex{},
+		injectChan:      make(map[Direction]chan *Message),
+		serverKeys:      []string{loginInfo.ServerKey},
+		serverKeyLock:   &sync.Mutex{},
+		eventLock:       &sync.Mutex{},
+		idleLock:        &sync.Mutex{},
+		recorder:        &recorder{},
+		stats:           &sessionStats{stats: newSessionStats()},
+		impersonateLock: &sync.Mutex{},
+		intelliMhost:    loginInfo.Host,
+		pointStatus:     make(map[int]Point),
+		pointLock:       &sync.Mutex{},
+		statusLock:      &sync.Mutex{},
+		configLock:      &sync.Mutex{},
+		configKey:       loginInfo.ConnectedReq.ConfigurationKey,
+		Pager:           NewMessagePager(),
 	}
 
 	// lock the message relays. This gives us the opportunity to interrupt/mangle
@@ -96,6 +188,13 @@ func newSession(eidcCxn net.Conn) (*Session, error) {
 	// Initialize the waitGroup that indicates when the session has ended
 	session.over.Add(1)
 
+	// Tear down the session when its context is canceled. Closing the
+	// connections unblocks the relays.
+	go func() {
+		<-session.ctx.Done()
+		session.Close()
+	}()
+
 	// Start error distribution. Each error sent on errDistChan
 	// gets relayed to all subscribers (like the display)
 	errDistChan := make(chan error)
@@ -108,23 +207,89 @@ func newSession(eidcCxn net.Conn) (*Session, error) {
 	session.injectChan[Northbound] = session.relayMsg(Northbound, eidcRdr, serverCxn, errDistChan)
 	session.injectChan[Southbound] = session.relayMsg(Southbound, serverRdr, eidcCxn, errDistChan)
 
-	return &session, nil
+	return &session
 }
 
 // Inject sends a message within the session. It also installs any manglers
 // needed along with the injected message. The idea here is that if you're
 // sending a message that provokes a response, you'd want to include with it a
 // mangler that intercepts the responses so that side "A" doesn't see responses
-// from "B" for messages that "A" never sent.
-func (o Session) Inject(msg Message, manglers []Mangler) {
+// from "B" for messages that "A" never sent. If the session has ended (or
+// ends while waiting to send), msg is discarded.
+func (o *Session) Inject(msg Message, manglers []Mangler) {
+	o.inject(msg, manglers)
+}
+
+// inject is Inject(), but returns an error if the session ended before msg
+// could be sent.
+func (o *Session) inject(msg Message, manglers []Mangler) error {
 	localMsg := msg
 	localMsg.Injected = true
 	o.relayMutex.Lock()
-	for _, m := range manglers {
-		o.AddMangler(m)
+	defer o.relayMutex.Unlock()
+	if o.ctx.Err() != nil {
+		return fmt.Errorf("session ended before %s could be sent", localMsg.Type)
+	}
+	o.AddManglers(manglers...)
+	select {
+	case o.injectChan[localMsg.Direction()] <- &localMsg:
+		return nil
+	case <-o.ctx.Done():
+		return fmt.Errorf("session ended before %s could be sent", localMsg.Type)
+	}
+}
+
+// Request injects msg like Inject() and waits for the response of type
+// respType, which is intercepted so that the side which didn't send msg never
+// sees it. It returns an error if no response arrives within timeout, or if
+// the session ends first. If the eIDC32 reports that the command failed, the
+// response is the MsgTypeEIDCError one, see Message.IsEIDCError().
+func (o *Session) Request(msg Message, respType MsgType, timeout time.Duration) (*Message, error) {
+	return o.request(msg, respType, timeout, nil)
+}
+
+// request is Request(), but also installs manglers along with msg, like
+// Inject().
+func (o *Session) request(msg Message, respType MsgType, timeout time.Duration, manglers []Mangler) (*Message, error) {
+	localMsg := msg
+	localMsg.Injected = true
+
+	capture := &captureResponse{
+		direction: !localMsg.Direction(),
+		msgType:   respType,
+		c:         make(chan *Message, 1),
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	o.relayMutex.Lock()
+	if o.ctx.Err() != nil {
+		o.relayMutex.Unlock()
+		return nil, fmt.Errorf("session ended before %s could be sent", localMsg.Type)
+	}
+	// on failure, remove every mangler installed here, not just capture:
+	// the ones which came with msg shouldn't outlive it either.
+	ids := o.AddManglers(append(manglers[:len(manglers):len(manglers)], capture)...)
+	select {
+	case o.injectChan[localMsg.Direction()] <- &localMsg:
+		o.relayMutex.Unlock()
+	case <-o.ctx.Done():
+		o.relayMutex.Unlock()
+		o.DelManglers(ids...)
+		return nil, fmt.Errorf("session ended before %s could be sent", localMsg.Type)
+	}
+
+	select {
+	case resp := <-capture.c:
+		return resp, nil
+	case <-timer.C:
+		o.DelManglers(ids...)
+		return nil, fmt.Errorf("timed out after %s waiting for %s", timeout, respType)
+	case <-o.ctx.Done():
+		o.DelManglers(ids...)
+		return nil, fmt.Errorf("session ended while waiting for %s", respType)
 	}
-	o.injectChan[localMsg.Direction()] <- &localMsg
-	o.relayMutex.Unlock()
 }
 
 // ConnFuncForURL returns a function that, when executed, initiates
@@ -161,13 +326,22 @@ func ConnectUsingTerribleTLS(dest string) (*terribletls.Conn, error) {
 // The 'terribletls' library is a hacked together copy of Go's standard
 // 'crypto/tls' library. It includes support for deprecated ciphers used by
 // Infinias software.
+//
+// The connection goes through the proxy set with SetOutboundProxy(), if any,
+// and its ClientHello carries the SNI and ALPN set with SetOutboundTLS().
 func ConnectUsingTerribleTLSByNetwork(dest string, transportType string) (*terribletls.Conn, error) {
-	//keylog, err := keyLogWriter()
-	//if err != nil {
-	//	return nil, err
-	//}
+	return ConnectUsingTerribleTLSContext(context.Background(), dest, transportType)
+}
+
+// ConnectUsingTerribleTLSContext is like ConnectUsingTerribleTLSByNetwork,
+// but gives up on the dial and TLS handshake if ctx is canceled.
+func ConnectUsingTerribleTLSContext(ctx context.Context, dest string, transportType string) (*terribletls.Conn, error) {
+	keyLog, err := keyLogWriter(false)
+	if err != nil {
+		return nil, err
+	}
 	conf := &terribletls.Config{
-		//KeyLogWriter: keylog,
+		KeyLogWriter:       keyLog,
 		InsecureSkipVerify: true,
 		CipherSuites: []uint16{
 			terribletls.TLS_RSA_WITH_RC4_40_MD5,
@@ -175,7 +349,36 @@ func ConnectUsingTerribleTLSByNetwork(dest string, transportType string) (*terri
 		},
 	}
 
-	return terribletls.Dial(transportType, canonicalizeHost(dest), conf)
+	addr := canonicalizeHost(dest)
+	conf.ServerName, conf.NextProtos = outboundTLSParams(addr)
+
+	rawConn, err := dialOutbound(ctx, transportType, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// terribletls predates HandshakeContext(), so watch ctx ourselves
+	// and close the connection out from under the handshake.
+	conn := terribletls.Client(rawConn, conf)
+	handshakeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			rawConn.Close()
+		case <-handshakeDone:
+		}
+	}()
+	err = conn.Handshake()
+	close(handshakeDone)
+	if err != nil {
+		rawConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return conn, nil
 }
 
 // canonicalizeHost adds ":443" where necessary
@@ -187,12 +390,18 @@ func canonicalizeHost(in string) string {
 	return in + ":443"
 }
 
+// scannerToSliceByteChan runs the scanner in the background, sending each
+// token on the returned channel. The channel is closed when the scanner stops.
+// Tokens are copied, because the scanner reuses its buffer (pipelined messages
+// would otherwise overwrite one another) and Messages keep their original
+// bytes.
 func scannerToSliceByteChan(s *bufio.Scanner) chan []byte {
 	c := make(chan []byte)
 	go func() {
 		for s.Scan() {
-			c <- s.Bytes()
+			c <- append([]byte{}, s.Bytes()...)
 		}
+		close(c)
 	}()
 	return c
 }
@@ -210,6 +419,7 @@ func (o *Session) relayInboundHalf(dir Direction, in *bufio.Reader, errChan chan
 	// Get a channel to tell us if the session's died
 	itsOver := o.tellMeWhenItsOver()
 	var msgBytes []byte
+	var ok bool
 
 	// Get a channel of scanner results
 	scannerChan := scannerToSliceByteChan(s)
@@ -220,15 +430,17 @@ MESSAGE:
 		select {
 		case <-itsOver: // Somebody killed the session by calling Done() on the waitgroup
 			return
-		case msgBytes = <-scannerChan: // The inbound scanner.Scan() returned
-			err := s.Err() // Check for scanner for errors
-			if err != nil {
-				errChan <- err         // Distribute the error.
-				o.EndTime = time.Now() // Mark the session end time.
-				o.over.Done()          // Announce the session's demise.
-				return                 // End this loop.
+		case msgBytes, ok = <-scannerChan: // The inbound scanner.Scan() returned
+			if !ok { // The scanner stopped: EOF or error
+				err := s.Err() // Check the scanner for errors
+				if err != nil {
+					errChan <- err // Distribute the error.
+				}
+				o.end() // Announce the session's demise.
+				return  // End this loop.
 			}
 		}
+		o.resetIdleTimer()
 
 		// lock the relay mutex
 		o.relayMutex.Lock()
@@ -248,21 +460,25 @@ MESSAGE:
 			errChan <- err
 		}
 
-		// run all the manglers (or Drop)
+		// run all the manglers (or Drop), oldest first
 		o.mangleLock.Lock()
-		for i, m := range o.manglers {
-			mr, err := m.Mangle(msg)
+		for _, i := range o.manglerIDs() {
+			mr, err := o.manglers[i].Mangle(msg)
 			if err != nil || mr&ManglerErr == ManglerErr {
 				if err == nil {
 					err = errors.New("unspecified finalMangler error (this should never happen)")
 				}
 				errChan <- err
 			}
+			if mr&ManglerSuccess == ManglerSuccess {
+				msg.modified = true
+			}
 			if mr&ManglerDone == ManglerDone {
 				delete(o.manglers, i)
 			}
 			if mr&ManglerDrop == ManglerDrop {
 				msg.Dropped = true
+				o.countDropped()
 				o.mangleLock.Unlock()
 				o.relayMutex.Unlock()
 				o.Pager.DistributeMessage(msg)
@@ -270,7 +486,12 @@ MESSAGE:
 			}
 		}
 		o.mangleLock.Unlock()
-		xmitChan <- msg
+		select {
+		case xmitChan <- msg:
+		case <-itsOver: // relayOutboundHalf has quit, nobody will take it
+			o.relayMutex.Unlock()
+			return
+		}
 		o.relayMutex.Unlock()
 	}
 }
@@ -280,7 +501,7 @@ MESSAGE:
 // then writes the result to the outbound network socket. Messages handled
 // by this function ordinarily come from relayInboundHalf, but can also be
 // injected into the channel by the session's Inject() method.
-func (o Session) relayOutboundHalf(dir Direction, out net.Conn, errChan chan error, xmitChan chan *Message) {
+func (o *Session) relayOutboundHalf(dir Direction, out net.Conn, errChan chan error, xmitChan chan *Message) {
 	// Get a channel to tell us if the session's died
 	itsOver := o.tellMeWhenItsOver()
 
@@ -295,8 +516,11 @@ func (o Session) relayOutboundHalf(dir Direction, out net.Conn, errChan chan err
 		// any message that survived the mangle loops now needs its sequence
 		// numbers normalized. This is a special "always runs" mangler for
 		// southbound messages.
-		if dir == Southbound && msg.Request != nil {
+		if dir == Southbound && msg.Request != nil && o.SequenceFixup() {
 			mr, err := o.sm.Mangle(msg)
+			if mr&ManglerSuccess == ManglerSuccess {
+				msg.modified = true
+			}
 			if mr&ManglerErr == ManglerErr || err != nil {
 				if err == nil {
 					err = errors.New("unspecified sequence mangler error")
@@ -307,29 +531,43 @@ func (o Session) relayOutboundHalf(dir Direction, out net.Conn, errChan chan err
 
 		o.Pager.DistributeMessage(msg)
 
-		// render the message to bytes
-		payload, err := msg.Marshal()
-		if err != nil {
-			errChan <- errors.New("error marshaling message; passing message unmodified:" + err.Error())
-			// something went terribly wrong. Spit out the original message with no changes.
-			payload = msg.origBytes
-		}
+		// run any hooks registered with OnMessage()
+		o.runHooks(msg)
 
-		// run the impersonation features to get misspellings, etc...
-		impostor, err := impersonate(payload, dir)
-		if err != nil {
-			errChan <- errors.New("error running impersonate; passing message unmodified:" + err.Error())
+		var impostor []byte
+		if o.Verbatim() && !msg.Injected && !msg.modified && len(msg.origBytes) > 0 {
+			// nothing has changed: send it exactly as it arrived
+			impostor = msg.origBytes
+		} else {
+			// render the message to bytes
+			payload, err := msg.Marshal()
+			if err != nil {
+				errChan <- errors.New("error marshaling message; passing message unmodified:" + err.Error())
+				// something went terribly wrong. Spit out the original message with no changes.
+				payload = msg.origBytes
+			}
+
+			// run the impersonation features to get misspellings, etc...
 			impostor = payload
+			if o.Impersonating() {
+				impostor, err = impersonate(payload, dir)
+				if err != nil {
+					errChan <- errors.New("error running impersonate; passing message unmodified:" + err.Error())
+					impostor = payload
+				}
+			}
 		}
 
 		// write the message to the socket
-		_, err = out.Write(impostor)
+		_, err := out.Write(impostor)
 		if err != nil {
-			errChan <- err         // Distribute the error.
-			o.EndTime = time.Now() // Mark the session end time.
-			o.over.Done()          // Announce the session's demise.
-			return                 // End this loop.
+			errChan <- err // Distribute the error.
+			o.end()        // Announce the session's demise.
+			return         // End this loop.
 		}
+		o.resetIdleTimer()
+		o.record(dir, impostor)
+		o.countRelayed(msg, len(impostor))
 	}
 }
 
@@ -340,18 +578,53 @@ type Session struct {
 	StartTime           time.Time                   // StartTime
 	EndTime             time.Time                   // EndTime
 	over                *sync.WaitGroup             // Session over
+	endOnce             *sync.Once                  // Ensures over.Done() is called only once
+	done                chan struct{}               // Closed when the session ends, see Done()
+	ctx                 context.Context             // Canceled when the session ends
+	cancel              context.CancelFunc          // Cancels ctx
+	eidcCxn             net.Conn                    // Connection to the eIDC32
+	serverCxn           net.Conn                    // Connection to IntelliM
 	LoginInfo           LoginInfo                   // Detail from initial eIDC message
+	Tag                 string                      // Optional correlation tag, see Server.SetSessionTagger()
+	TLSInfo             TLSInfo                     // The eIDC32's ClientHello, when the Server is doing TLS
 	manglers            map[int]Mangler             // All messages run through these manglers
-	mangleLock          *sync.Mutex                 // Don't run pass messages during mangler add/remove intervals
+	mangleLock          *sync.Mutex                 // Don't run pass messages during mangler add/remove intervals, protects nextMangler
+	nextMangler         int                         // ID for the next mangler added with AddManglers()
+	hooks               map[int]func(*Message)      // Callbacks registered with OnMessage()
+	hookLock            *sync.Mutex                 // Protects hooks and nextHook
+	nextHook            int                         // ID for the next hook registered with OnMessage()
 	errSubMap           map[chan error]struct{}     // Error subscriber channels
-	errSubMutex         *sync.Mutex                 // Don't send errors during subscriber add/remove intervals
+	errSubMutex         *sync.Mutex                 // Don't send errors during subscriber add/remove intervals, protects errTimeout
+	errTimeout          time.Duration               // Per-subscriber error delivery timeout, see SetErrTimeout()
 	sm                  Mangler                     // Mandatory mangler fixes sequence numbers
 	relayMutex          *sync.Mutex                 // Used to pause relaying while messages are in flight
+	pauseLock           *sync.Mutex                 // Protects pauses
+	pauses              int                         // Outstanding Pause() calls
 	injectChan          map[Direction]chan *Message // Inject fake messages on these Northbound/Southbound channels
+	serverKeyLock       *sync.Mutex                 // Protects serverKeys
+	eventLock           *sync.Mutex                 // Protects lastEventID, lastEventTime and recentEvents
+	lastEventID         int                         // Highest event ID seen or issued by NextEventID()
+	lastEventTime       int                         // Latest event time seen or issued by StampEvent()
+	recentEvents        []EventRequest              // Events seen from the eIDC32, oldest first, see RecentEvents()
+	pointLock           *sync.Mutex                 // Protects pointStatus
+	statusLock          *sync.Mutex                 // Protects eventsEnabled and timeSet
+	configLock          *sync.Mutex                 // Protects configKey, deviceID, apiCreds and ftpCreds
+	idleLock            *sync.Mutex                 // Protects idleTimeout and idleTimer
+	idleTimeout         time.Duration               // Close the session after this long without messages, see SetIdleTimeout()
+	idleTimer           *time.Timer                 // Closes the session when it fires
+	recorder            *recorder                   // Keeps copies of relayed messages, see SetRecording()
+	stats               *sessionStats               // Counters, see Stats()
+	impersonateLock     *sync.Mutex                 // Protects noImpersonate, verbatim and noSeqFixup
+	noImpersonate       bool                        // Write messages as Go renders them, see SetImpersonation()
+	verbatim            bool                        // Write unmodified relayed messages as received, see SetVerbatim()
+	noSeqFixup          bool                        // Leave southbound sequence numbers alone, see SetSequenceFixup()
 	serverKeys          []string
 	intelliMhost        string
 	apiCreds            UsernameAndPassword
 	webCreds            UsernameAndPassword
+	ftpCreds            UsernameAndPassword
+	configKey           string
+	deviceID            int
 	getOutboundResponse GetOutboundResponse
 	eventsEnabled       bool
 	timeSet             bool
@@ -386,53 +659,133 @@ func (o *Session) relayMsg(dir Direction, in *bufio.Reader, out net.Conn, errCha
 }
 
 // AddMangler adds a message mangler object to the session,
-// returns the mangler's ID number.
+// returns the mangler's ID number. Manglers run in the order they were added,
+// so a mangler which inspects a message sees any changes made by the ones
+// added before it (and nothing at all if one of them drops the message).
 func (o *Session) AddMangler(m Mangler) int {
+	return o.AddManglers(m)[0]
+}
+
+// AddManglers adds several manglers to the session at once, so that no
+// message is mangled by some of them but not the others. They run in the
+// order given, after any manglers already in place. It returns their
+// (contiguous) ID numbers, in the same order.
+func (o *Session) AddManglers(ms ...Mangler) []int {
 	o.mangleLock.Lock()
-	// figure out highest mangler number
-	highest := -1
-	for key := range o.manglers {
-		if key > highest {
-			highest = key
-		}
+	// IDs are never reused, so a stale ID can't delete some other mangler
+	ids := make([]int, len(ms))
+	for i, m := range ms {
+		ids[i] = o.nextMangler
+		o.manglers[ids[i]] = m
+		o.nextMangler++
 	}
-	id := highest + 1
-	o.manglers[id] = m
 	o.mangleLock.Unlock()
-	return id
+	return ids
+}
+
+// manglerIDs returns the IDs of the session's manglers in the order they
+// were added. IDs are handed out in increasing order and never reused (see
+// AddManglers()), so that's ascending order. Call it with mangleLock held.
+func (o *Session) manglerIDs() []int {
+	ids := make([]int, 0, len(o.manglers))
+	for id := range o.manglers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
 }
 
 // DelMangler deletes a mangler (by ID) from the session
 func (o *Session) DelMangler(mangler int) {
+	o.DelManglers(mangler)
+}
+
+// DelManglers deletes several manglers (by ID) from the session at once, e.g.
+// a group installed by AddManglers() whose one-shot members may never see the
+// message they're waiting for. IDs which aren't in use are ignored.
+func (o *Session) DelManglers(ids ...int) {
+	o.mangleLock.Lock()
+	for _, id := range ids {
+		delete(o.manglers, id)
+	}
+	o.mangleLock.Unlock()
+}
+
+// ClearManglers deletes all of the session's manglers, including those
+// installed by Inject() and Request(), so a pending Request() will time out.
+// The mandatory sequence number fixup isn't affected.
+func (o *Session) ClearManglers() {
 	o.mangleLock.Lock()
-	delete(o.manglers, mangler)
+	o.manglers = make(map[int]Mangler)
 	o.mangleLock.Unlock()
 }
 
 // distribureErr fires a copy of each error to every subscriber
-func (o Session) distribureErr(errChan chan error) {
+func (o *Session) distribureErr(errChan chan error) {
 	// Loop over session errors channels
 	for err := range errChan {
 		// Lock the error subscriber list (no new subscribers allowed while distributing errors)
 		o.errSubMutex.Lock()
 		for ch := range o.errSubMap {
-			timeOut := time.NewTimer(100 * time.Millisecond)
+			if o.errTimeout <= 0 {
+				select {
+				case ch <- err: // only if there's room
+				default:
+				}
+				continue
+			}
+			timeOut := time.NewTimer(o.errTimeout)
 			select {
 			case ch <- err: // write to the subscriber's channel (buffered 1) if possible
-			case <-timeOut.C: // subscriber had 100ms, never showed up.
-				timeOut.Stop()
+			case <-timeOut.C: // subscriber had errTimeout, never showed up.
 			}
+			timeOut.Stop()
 		}
 		o.errSubMutex.Unlock()
 	}
 }
 
 // UpTime returns the time since a session started
-func (o Session) UpTime() time.Duration {
+func (o *Session) UpTime() time.Duration {
 	return time.Since(o.StartTime)
 }
 
-// SubscribeErr returns a channel on which the subscriber can listen for session errors
+// Serial returns the serial number the eIDC32 reported when it logged in,
+// e.g. "0x000000123456".
+func (o *Session) Serial() string {
+	return o.LoginInfo.ConnectedReq.SerialNumber
+}
+
+// SerialInt returns the eIDC32's serial number as an integer, the way the
+// device's web interface displays it.
+func (o *Session) SerialInt() (int64, error) {
+	return strconv.ParseInt(o.Serial(), 0, 64)
+}
+
+// ClientIP returns the eIDC32's IP address. That's the address it reported
+// when it logged in, followed by the address the proxy observed in
+// parentheses if they differ (say, because of NAT).
+func (o *Session) ClientIP() string {
+	reported := o.LoginInfo.ConnectedReq.IPAddress
+	observed := o.Mitm.ClientIP()
+	if reported == observed {
+		return reported
+	}
+	return fmt.Sprintf("%s (%s)", reported, observed)
+}
+
+// Destination returns the IntelliM host the eIDC32 was trying to reach, as
+// found in its login request.
+func (o *Session) Destination() string {
+	return o.LoginInfo.Host
+}
+
+// SubscribeErr returns a channel on which the subscriber can listen for session
+// errors. The channel buffers one error. Errors are delivered to subscribers
+// one at a time, and a subscriber whose buffer is still full after the error
+// timeout (see SetErrTimeout()) misses that error; it isn't queued for later.
+// Subscribers which mustn't miss the error that ends the session should also
+// watch Done().
 func (o *Session) SubscribeErr() chan error {
 	out := make(chan error, 1)
 	o.errSubMutex.Lock()
@@ -441,6 +794,20 @@ func (o *Session) SubscribeErr() chan error {
 	return out
 }
 
+// SetErrTimeout sets how long each error subscriber (see SubscribeErr()) gets
+// to make room for an error before it's dropped for that subscriber. The
+// default is 100ms. Subscribers are served in turn, so a stalled subscriber
+// delays errors for the others (and the relays reporting them) by up to the
+// timeout per error. Zero or less delivers only to subscribers with room.
+func (o *Session) SetErrTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	o.errSubMutex.Lock()
+	o.errTimeout = timeout
+	o.errSubMutex.Unlock()
+}
+
 // UnSubscribeErr removes the channel from the session's map
 func (o *Session) UnSubscribeErr(c chan error) {
 	o.errSubMutex.Lock()
@@ -453,51 +820,408 @@ func (o *Session) UnSubscribeErr(c chan error) {
 // session relays. The session starts with relays locked, requiring an explicit
 // unlock via this function. This scheme gives time setting up message manglers
 // before the first messages are relayed from eIDC32 to IntelliM.
-func (o Session) BeginRelaying() {
+func (o *Session) BeginRelaying() {
 	o.relayMutex.Unlock()
 }
 
+// Pause stops the session from relaying new messages (in either direction)
+// until a matching call to Resume(). Use it to install a batch of manglers
+// which must all take effect at the same message. Pauses nest: relaying
+// resumes after every Pause() has been matched with a Resume(). Pause waits
+// for any message being mangled to finish, but messages which have already
+// been through the manglers are still marshaled and written. Inject() and
+// Request() block while the session is paused. Don't call Pause() before
+// BeginRelaying().
+func (o *Session) Pause() {
+	o.pauseLock.Lock()
+	if o.pauses == 0 {
+		o.relayMutex.Lock()
+	}
+	o.pauses++
+	o.pauseLock.Unlock()
+}
+
+// Resume undoes one call to Pause(). Calling it without an outstanding
+// Pause() does nothing.
+func (o *Session) Resume() {
+	o.pauseLock.Lock()
+	if o.pauses > 0 {
+		o.pauses--
+		if o.pauses == 0 {
+			o.relayMutex.Unlock()
+		}
+	}
+	o.pauseLock.Unlock()
+}
+
 // SetLockStatus POSTs to eidc/door/lockstatus at the eIDC32 and intercepts the
 // eIDC32 WebServer's 200OK response.
 // Additionally, if stealth is true, it:
 // 1) Intercepts the eIDC32's AccessGranted event this action provokes.
 // 2) POSTs to /eidc/eventack on behalf of the server to acknowledge the event.
 // 3) Intercepts the eIDC32 WebServer's 200OK response.
-func (o Session) SetLockStatus(status lockstatus, stealth bool) error {
-	setLockStatusMsg, err := NewLockStatusMsg(o.apiCreds.username, o.apiCreds.password, status)
+func (o *Session) SetLockStatus(status lockstatus, stealth bool) error {
+	setLockStatusMsg, manglers, err := o.lockStatusMsgAndManglers(status, stealth)
+	if err != nil {
+		return err
+	}
+
+	go o.Inject(*setLockStatusMsg, manglers)
+
+	return nil
+}
+
+// SetLockStatusAndWait is like SetLockStatus(), but waits up to timeout for
+// the eIDC32's response and returns the status it reports, so that callers
+// can tell whether the door actually changed state. The response is still
+// intercepted. Use SetLockStatus() when there's no need to wait.
+func (o *Session) SetLockStatusAndWait(status lockstatus, stealth bool, timeout time.Duration) (string, error) {
+	creds := o.apiCredentials()
+	setLockStatusMsg, err := NewLockStatusMsg(creds.username, creds.password, status)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := o.request(*setLockStatusMsg, MsgTypeDoor0x2fLockStatusResponse, timeout, o.lockStatusStealthManglers(status, stealth))
+	if err != nil {
+		return "", err
+	}
+
+	dlsr, err := resp.ParseDoor0x2fLockStatusResponse()
+	if err != nil {
+		return "", err
+	}
+	return dlsr.Status, nil
+}
+
+// MomentaryUnlock unlocks the door, waits for duration, then locks it again.
+// Both transitions are handled like SetLockStatus(), including the stealth
+// suppression of the events and point status messages each one provokes.
+// Unlike SetLockStatus(), the injections happen synchronously, so the lock
+// message is guaranteed to follow the unlock message. It returns an error if
+// the session ends first.
+func (o *Session) MomentaryUnlock(duration time.Duration, stealth bool) error {
+	unlockMsg, unlockManglers, err := o.lockStatusMsgAndManglers(Unlocked, stealth)
+	if err != nil {
+		return err
+	}
+	lockMsg, lockManglers, err := o.lockStatusMsgAndManglers(Locked, stealth)
+	if err != nil {
+		return err
+	}
+
+	err = o.inject(*unlockMsg, unlockManglers)
 	if err != nil {
 		return err
 	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-o.ctx.Done():
+		return fmt.Errorf("session ended before the door could be relocked")
+	}
+
+	return o.inject(*lockMsg, lockManglers)
+}
+
+// lockStatusMsgAndManglers builds the door/lockstatus message and the
+// manglers required to hide its side effects from the server.
+func (o *Session) lockStatusMsgAndManglers(status lockstatus, stealth bool) (*Message, []Mangler, error) {
+	creds := o.apiCredentials()
+	setLockStatusMsg, err := NewLockStatusMsg(creds.username, creds.password, status)
+	if err != nil {
+		return nil, nil, err
+	}
 	dropLockStatusReply := dropEidcResponse{msgType: MsgTypeDoor0x2fLockStatusResponse}
 
 	manglers := []Mangler{dropLockStatusReply}
+	manglers = append(manglers, o.lockStatusStealthManglers(status, stealth)...)
 
-	if stealth {
-		var suppress EventType
-		switch status {
-		case Locked: suppress = EventAccessRestricted
-		case Unlocked: suppress = EventAccessGranted
-		}
-		manglers = append(manglers,DropEidcEvent{EventType: suppress, Session: &o, OneShot: true})
-		manglers = append(manglers,DropEidcPointStatusRequest{point: 12})
-		manglers = append(manglers,DropEidcPointStatusRequest{point: 38})
-		manglers = append(manglers,DropEidcPointStatusRequest{point: 16})
+	return setLockStatusMsg, manglers, nil
+}
+
+// lockStatusStealthManglers returns the manglers which hide the events and
+// point status messages provoked by changing the lock status, if stealth is
+// true.
+func (o *Session) lockStatusStealthManglers(status lockstatus, stealth bool) []Mangler {
+	if !stealth {
+		return nil
 	}
 
-	go o.Inject(*setLockStatusMsg, manglers)
+	var suppress EventType
+	switch status {
+	case Locked:
+		suppress = EventAccessRestricted
+	case Unlocked:
+		suppress = EventAccessGranted
+	}
+	return []Mangler{
+		DropEidcEvent{EventType: suppress, Session: o, OneShot: true},
+		DropEidcPointStatusRequest{point: 12},
+		DropEidcPointStatusRequest{point: 38},
+		DropEidcPointStatusRequest{point: 16},
+	}
+}
 
-	return nil
+// OnMessage registers a callback which gets called with each message (in
+// either direction) just before it's sent, after all mangling is complete.
+// It's intended for host applications which embed the proxy and want to look
+// at messages without managing a pager subscription. Hooks run synchronously
+// in the relay path, in the order they were registered, so they must be fast.
+// The returned function removes the hook.
+func (o *Session) OnMessage(fn func(*Message)) func() {
+	o.hookLock.Lock()
+	id := o.nextHook
+	o.nextHook++
+	o.hooks[id] = fn
+	o.hookLock.Unlock()
+
+	return func() {
+		o.hookLock.Lock()
+		delete(o.hooks, id)
+		o.hookLock.Unlock()
+	}
 }
 
-// tellMeWhenItsOver returns a channel. The channel will close when the
-// session has died.
-func (o Session) tellMeWhenItsOver() chan struct{} {
+// runHooks calls the functions registered with OnMessage(). The hook lock
+// isn't held while they run, so a hook may remove itself.
+func (o *Session) runHooks(msg *Message) {
+	o.hookLock.Lock()
+	ids := make([]int, 0, len(o.hooks))
+	for id := range o.hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	hooks := make([]func(*Message), len(ids))
+	for i, id := range ids {
+		hooks[i] = o.hooks[id]
+	}
+	o.hookLock.Unlock()
+
+	for _, hook := range hooks {
+		hook(msg)
+	}
+}
+
+// NextEventID returns a new event ID, higher than any the session has seen
+// from the eIDC32 or previously issued. Anything which fabricates eIDC32 events
+// should use it so that IntelliM doesn't reject them as duplicates.
+func (o *Session) NextEventID() int {
+	o.eventLock.Lock()
+	defer o.eventLock.Unlock()
+	o.lastEventID++
+	return o.lastEventID
+}
+
+// RecentEvents returns up to n of the most recent events the eIDC32 has
+// reported during the session, oldest first. n <= 0 returns all of the
+// events the session has kept, which is at most the last 256.
+func (o *Session) RecentEvents(n int) []EventRequest {
+	o.eventLock.Lock()
+	defer o.eventLock.Unlock()
+	if n <= 0 || n > len(o.recentEvents) {
+		n = len(o.recentEvents)
+	}
+	return append([]EventRequest(nil), o.recentEvents[len(o.recentEvents)-n:]...)
+}
+
+// StampEvent sets the event's EventID (using NextEventID()) and its Time. The
+// time is the current time unless the session has already seen (or stamped)
+// a later one, so event times never go backwards.
+func (o *Session) StampEvent(event *EventRequest) {
+	event.EventID = o.NextEventID()
+
+	now := int(time.Now().Unix())
+	o.eventLock.Lock()
+	if now > o.lastEventTime {
+		o.lastEventTime = now
+	}
+	event.Time = o.lastEventTime
+	o.eventLock.Unlock()
+}
+
+// NewEventMsg returns a northbound message reporting the event to the
+// session's IntelliM server. The event is stamped with StampEvent() first.
+func (o *Session) NewEventMsg(event EventRequest) (*Message, error) {
+	o.StampEvent(&event)
+	serverKeys := o.ServerKeys()
+	return NewEventMsg(o.intelliMhost, serverKeys[len(serverKeys)-1], event)
+}
+
+// SetImpersonation controls whether messages written by the session are fixed
+// up (header order, capitalization, etc...) to look like those produced by a
+// real eIDC32 and IntelliM. It's on by default. Turning it off writes messages
+// exactly as rendered by Go's http library, which is handy when debugging the
+// proxy, but easy for either end to spot.
+func (o *Session) SetImpersonation(enable bool) {
+	o.impersonateLock.Lock()
+	o.noImpersonate = !enable
+	o.impersonateLock.Unlock()
+}
+
+// Impersonating returns whether the session is fixing up the messages it
+// writes, see SetImpersonation().
+func (o *Session) Impersonating() bool {
+	o.impersonateLock.Lock()
+	defer o.impersonateLock.Unlock()
+	return !o.noImpersonate
+}
+
+// SetVerbatim controls whether messages relayed without modification are
+// written exactly as they were received, rather than rendered by Go's http
+// library and fixed up by impersonation. That's faithful to whatever the
+// firmware at either end actually sends, and cheaper. Messages which were
+// injected, or which a mangler (or the sequence renumbering) reports having
+// changed by returning ManglerSuccess, are still rendered and impersonated.
+// It's off by default.
+func (o *Session) SetVerbatim(enable bool) {
+	o.impersonateLock.Lock()
+	o.verbatim = enable
+	o.impersonateLock.Unlock()
+}
+
+// SetSequenceFixup controls whether the session renumbers the sequence ("seq"
+// query parameter) of southbound requests so that the eIDC32 sees an unbroken
+// sequence despite injected messages. It's on by default. Turning it off
+// passes IntelliM's sequence numbers through untouched, which is handy when
+// observing exactly what IntelliM sent, but injected messages then go out
+// with whatever sequence number they were built with.
+func (o *Session) SetSequenceFixup(enable bool) {
+	o.impersonateLock.Lock()
+	o.noSeqFixup = !enable
+	o.impersonateLock.Unlock()
+}
+
+// SequenceFixup returns whether the session renumbers southbound requests,
+// see SetSequenceFixup().
+func (o *Session) SequenceFixup() bool {
+	o.impersonateLock.Lock()
+	defer o.impersonateLock.Unlock()
+	return !o.noSeqFixup
+}
+
+// Verbatim returns whether the session writes unmodified relayed messages as
+// received, see SetVerbatim().
+func (o *Session) Verbatim() bool {
+	o.impersonateLock.Lock()
+	defer o.impersonateLock.Unlock()
+	return o.verbatim
+}
+
+// StartKeepAlive injects a heartbeat request toward the eIDC32 every interval,
+// using the API credentials IntelliM has been seen to use, and intercepts the
+// eIDC32's responses. It carries on until the returned stop function is called
+// or the session ends.
+func (o *Session) StartKeepAlive(interval time.Duration) (stop func()) {
 	done := make(chan struct{})
+	stopOnce := &sync.Once{}
+
 	go func() {
-		o.over.Wait()
-		close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-o.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			creds := o.apiCredentials()
+			hb, err := NewHeartbeatMsg(creds.username, creds.password)
+			if err != nil {
+				continue
+			}
+			err = o.inject(*hb, []Mangler{dropEidcResponse{msgType: MsgTypeHeartbeatResponse}})
+			if err != nil {
+				return // the session has ended
+			}
+		}
 	}()
-	return done
+
+	return func() {
+		stopOnce.Do(func() { close(done) })
+	}
+}
+
+// SetIdleTimeout closes the session if no message arrives from, or is sent
+// to, either side for timeout. It protects against half-open connections from
+// dead controllers, which would otherwise keep the session alive forever. The
+// timer starts over with each call. Zero (the default) disables it.
+func (o *Session) SetIdleTimeout(timeout time.Duration) {
+	o.idleLock.Lock()
+	defer o.idleLock.Unlock()
+	if o.idleTimer != nil {
+		o.idleTimer.Stop()
+		o.idleTimer = nil
+	}
+	o.idleTimeout = timeout
+	if timeout > 0 {
+		o.idleTimer = time.AfterFunc(timeout, func() { o.Close() })
+	}
+}
+
+// resetIdleTimer restarts the idle timeout, if there is one.
+func (o *Session) resetIdleTimer() {
+	o.idleLock.Lock()
+	if o.idleTimer != nil {
+		o.idleTimer.Reset(o.idleTimeout)
+	}
+	o.idleLock.Unlock()
+}
+
+// end marks the session end time and announces the session's demise. Only
+// the first call has any effect.
+func (o *Session) end() {
+	o.endOnce.Do(func() {
+		o.SetIdleTimeout(0)
+		o.EndTime = time.Now()
+		o.cancel()
+		o.over.Done()
+		close(o.done)
+	})
+}
+
+// GetTag returns the session's Tag, for consumers (like the metrics package)
+// which need an interface.
+func (o *Session) GetTag() string {
+	return o.Tag
+}
+
+// Done returns a channel which is closed when the session ends, for whatever
+// reason (see Context()). Unlike SubscribeErr(), it doesn't depend on the
+// session's demise being announced by an error.
+func (o *Session) Done() <-chan struct{} {
+	return o.done
+}
+
+// Context returns the session's context. It's derived from the Server's
+// context (see Server.SetContext()) and is canceled when the session ends,
+// whether by Close(), Server.Stop(), cancellation of the parent context, or
+// either side hanging up. Canceling the parent context closes the session.
+func (o *Session) Context() context.Context {
+	return o.ctx
+}
+
+// Close ends the session and closes the connections to both the eIDC32 and
+// the IntelliM server.
+func (o *Session) Close() error {
+	o.end()
+	eidcErr := o.eidcCxn.Close()
+	serverErr := o.serverCxn.Close()
+	if eidcErr != nil {
+		return eidcErr
+	}
+	return serverErr
+}
+
+// tellMeWhenItsOver returns a channel. The channel will close when the
+// session has died.
+func (o *Session) tellMeWhenItsOver() <-chan struct{} {
+	return o.done
 }
 
 func (o LoginInfo) String() string {
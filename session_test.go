@@ -1,7 +1,17 @@
 package eidc32proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCanonicalizeHost(t *testing.T) {
@@ -33,3 +43,1581 @@ func TestCanonicalizeHost(t *testing.T) {
 	}
 
 }
+
+// newPipeSession returns a relaying Session whose eIDC32 and IntelliM sides
+// are in-memory pipes. The returned connections are the far ends of those
+// pipes: write to eidc to play the part of the eIDC32, and to server to play
+// the part of IntelliM. Callers should close both when they're done.
+func newPipeSession(t *testing.T) (session *Session, eidc net.Conn, server net.Conn) {
+	return newPipeSessionContext(t, context.Background())
+}
+
+// newPipeSessionContext is newPipeSession with a parent context.
+func newPipeSessionContext(t *testing.T, ctx context.Context) (session *Session, eidc net.Conn, server net.Conn) {
+	eidcCxn, eidc := net.Pipe()
+	serverCxn, server := net.Pipe()
+
+	loginInfo := &LoginInfo{
+		Host:      "intellim.example.com",
+		ServerKey: "serverkey",
+	}
+	session = startSession(ctx, eidcCxn, bufio.NewReader(eidcCxn), serverCxn, loginInfo)
+	session.apiCreds = UsernameAndPassword{username: "admin", password: "admin"}
+	session.BeginRelaying()
+	return session, eidc, server
+}
+
+// pipeMsgChan reads messages arriving at one of the far ends of a
+// newPipeSession() pipe, parses them, and sends them on the returned channel.
+func pipeMsgChan(c net.Conn, dir Direction) <-chan *Message {
+	out := make(chan *Message, 10)
+	go func() {
+		s := bufio.NewScanner(c)
+		s.Split(SplitHttpMsg)
+		for s.Scan() {
+			msg, err := ReadMsg(append([]byte{}, s.Bytes()...), dir)
+			if err != nil {
+				continue
+			}
+			out <- msg
+		}
+		close(out)
+	}()
+	return out
+}
+
+func eidcResponseBytes(cmd string, body string) []byte {
+	payload := fmt.Sprintf(`{"result":true, "cmd":"%s"%s}`, cmd, body)
+	return []byte(fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+		"Server: eIDC32 WebServer\r\n"+
+		"Content-type: application/json\r\n"+
+		"Content-Length: %d\r\n"+
+		"Cache-Control: no-cache\r\n\r\n%s", len(payload), payload))
+}
+
+func eidcEventBytes(eventID int, eventType EventType) []byte {
+	payload := fmt.Sprintf(`{"eventId":%d,"eventType":%d,"time":0,"pointId":0}`, eventID, eventType)
+	return []byte(fmt.Sprintf("POST %s HTTP/1.1\r\n"+
+		"Host: intellim.example.com\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", EventRequestURI, len(payload), payload))
+}
+
+func TestMomentaryUnlock(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	duration := 200 * time.Millisecond
+	errChan := make(chan error, 1)
+	start := time.Now()
+	go func() { errChan <- session.MomentaryUnlock(duration, true) }()
+
+	expectMsg := func(expected MsgType) *Message {
+		select {
+		case msg := <-fromProxy:
+			if msg.Type != expected {
+				t.Fatalf("expected %s, got %s", expected, msg.Type)
+			}
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+		return nil
+	}
+
+	expectAck := func(eventID int) {
+		ear, err := expectMsg(MsgTypeEventAckRequest).ParseEventAckRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ear.EventIds) != 1 || ear.EventIds[0] != eventID {
+			t.Fatalf("expected ack for event %d, got %v", eventID, ear.EventIds)
+		}
+	}
+
+	transitions := []struct {
+		status  lockstatus
+		event   EventType
+		eventID int
+	}{
+		{status: Unlocked, event: EventAccessGranted, eventID: 100},
+		{status: Locked, event: EventAccessRestricted, eventID: 101},
+	}
+
+	for i, tr := range transitions {
+		dlsr, err := expectMsg(MsgTypeDoor0x2fLockStatusRequest).ParseDoor0x2fLockStatusRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dlsr.Status != tr.status.String() {
+			t.Fatalf("expected lock status %s, got %s", tr.status, dlsr.Status)
+		}
+		if i > 0 {
+			if elapsed := time.Since(start); elapsed < duration {
+				t.Fatalf("relocked after %s, expected at least %s", elapsed, duration)
+			}
+		}
+
+		// play the part of the eIDC32: acknowledge the command, then
+		// report the event it provoked.
+		body := fmt.Sprintf(`, "body":{"status":"%s"}`, tr.status)
+		if _, err = eidc.Write(eidcResponseBytes(Door0x2fLockStatusResponseCmd, body)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = eidc.Write(eidcEventBytes(tr.eventID, tr.event)); err != nil {
+			t.Fatal(err)
+		}
+		expectAck(tr.eventID)
+		if _, err = eidc.Write(eidcResponseBytes(EventAckResponseCmd, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-toServer:
+		t.Fatalf("stealth unlock leaked a %s message to the server", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMomentaryUnlock_SessionEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	session, eidc, server := newPipeSessionContext(t, ctx)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- session.MomentaryUnlock(time.Minute, true) }()
+
+	select {
+	case <-fromProxy: // the unlock
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the unlock")
+	}
+	cancel()
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("expected an error when the session ends before relocking")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("MomentaryUnlock didn't return when the session ended")
+	}
+
+	// injecting into the ended session doesn't hang either
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		session.Inject(*hb, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Inject hung after the session ended")
+	}
+}
+
+func TestMitmAddresses(t *testing.T) {
+	testData := []struct {
+		mitm       Mitm
+		clientIP   string
+		clientPort int
+		serverIP   string
+		serverPort int
+	}{
+		{
+			mitm: Mitm{
+				ClientSide: CxnDetail{Client: "192.168.1.10:49152", Server: "192.168.1.2:443"},
+				ServerSide: CxnDetail{Client: "10.0.0.5:50000", Server: "203.0.113.7:18800"},
+			},
+			clientIP:   "192.168.1.10",
+			clientPort: 49152,
+			serverIP:   "203.0.113.7",
+			serverPort: 18800,
+		},
+		{
+			mitm: Mitm{
+				ClientSide: CxnDetail{Client: "[fe80::1%eth0]:49152", Server: "[fe80::2]:443"},
+				ServerSide: CxnDetail{Client: "[2001:db8::5]:50000", Server: "[2001:db8::7]:18800"},
+			},
+			clientIP:   "fe80::1%eth0",
+			clientPort: 49152,
+			serverIP:   "2001:db8::7",
+			serverPort: 18800,
+		},
+		{
+			mitm:       Mitm{},
+			clientIP:   "",
+			clientPort: 0,
+			serverIP:   "",
+			serverPort: 0,
+		},
+	}
+
+	for _, td := range testData {
+		if result := td.mitm.ClientIP(); result != td.clientIP {
+			t.Fatalf("expected client IP '%s', got '%s'", td.clientIP, result)
+		}
+		if result := td.mitm.ClientPort(); result != td.clientPort {
+			t.Fatalf("expected client port %d, got %d", td.clientPort, result)
+		}
+		if result := td.mitm.ServerIP(); result != td.serverIP {
+			t.Fatalf("expected server IP '%s', got '%s'", td.serverIP, result)
+		}
+		if result := td.mitm.ServerPort(); result != td.serverPort {
+			t.Fatalf("expected server port %d, got %d", td.serverPort, result)
+		}
+	}
+}
+
+func TestSession_OnMessage(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	seen := make(chan *Message, 10)
+	remove := session.OnMessage(func(msg *Message) {
+		seen <- msg
+	})
+
+	expectHook := func(dir Direction, msgType MsgType) {
+		select {
+		case msg := <-seen:
+			if msg.Direction() != dir || msg.Type != msgType {
+				t.Fatalf("expected %s %s, hook saw %s %s", dir, msgType, msg.Direction(), msg.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("hook didn't fire for %s %s", dir, msgType)
+		}
+	}
+
+	// southbound
+	_, err := server.Write([]byte("GET /eidc/heartbeat?username=admin&password=admin&seq=1 HTTP/1.1\r\n" +
+		"Host: 192.168.6.40\r\n" +
+		"User-Agent: eIDCListener\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHook(Southbound, MsgTypeHeartbeatRequest)
+	<-fromProxy
+
+	// northbound
+	_, err = eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHook(Northbound, MsgTypeHeartbeatResponse)
+	<-toServer
+
+	// removed hooks don't fire
+	remove()
+	_, err = eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	select {
+	case msg := <-seen:
+		t.Fatalf("removed hook saw %s", msg.Type)
+	default:
+	}
+}
+
+func TestSession_Context(t *testing.T) {
+	for _, useClose := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		session, eidc, server := newPipeSessionContext(t, ctx)
+
+		if session.Context().Err() != nil {
+			t.Fatal("new session's context is already done")
+		}
+
+		if useClose {
+			session.Close()
+		} else {
+			cancel()
+		}
+
+		select {
+		case <-session.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("session context not canceled")
+		}
+		select {
+		case <-session.tellMeWhenItsOver():
+		case <-time.After(time.Second):
+			t.Fatal("session didn't end")
+		}
+
+		// both connections should have been closed
+		for _, c := range []net.Conn{eidc, server} {
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("expected EOF from closed session connection, got %v", err)
+			}
+		}
+
+		cancel()
+		eidc.Close()
+		server.Close()
+	}
+}
+
+func TestSession_ServerKeys(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	// read the keys continuously while they're being updated, so that
+	// -race can spot unprotected access.
+	stop := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				session.ServerKeys()
+			}
+		}
+	}()
+
+	keys := []string{"key1", "key2", "key2", "key3"}
+	for _, key := range keys {
+		payload := fmt.Sprintf(`{"serverKey":"%s"}`, key)
+		_, err := server.Write([]byte(fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"Content-Type: application/json\r\n"+
+			"Content-Length: %d\r\n\r\n%s", len(payload), payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-fromProxy:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for ConnectedResponse")
+		}
+	}
+	close(stop)
+	<-readerDone
+
+	result := session.ServerKeys()
+	expected := []string{"serverkey", "key1", "key2", "key3"}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, result)
+		}
+	}
+
+	// the result must be a copy
+	result[0] = "modified"
+	if session.ServerKeys()[0] != "serverkey" {
+		t.Fatal("ServerKeys() returned the session's own slice")
+	}
+}
+
+func TestSession_NewEventMsg(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	expectEvent := func() EventRequest {
+		select {
+		case msg := <-toServer:
+			if msg.Type != MsgTypeEventRequest {
+				t.Fatalf("expected %s, got %s", MsgTypeEventRequest, msg.Type)
+			}
+			er, err := msg.ParseEventRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return er
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		return EventRequest{}
+	}
+
+	// a real event from the eIDC32 sets the starting point
+	if _, err := eidc.Write(eidcEventBytes(500, EventAccessGranted)); err != nil {
+		t.Fatal(err)
+	}
+	previous := expectEvent()
+
+	for i := 0; i < 5; i++ {
+		msg, err := session.NewEventMsg(EventRequest{EventType: EventAccessGranted, PointID: 12})
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Inject(*msg, nil)
+
+		er := expectEvent()
+		if er.EventID <= previous.EventID {
+			t.Fatalf("event ID %d doesn't follow %d", er.EventID, previous.EventID)
+		}
+		if er.Time < previous.Time {
+			t.Fatalf("event time %d is earlier than %d", er.Time, previous.Time)
+		}
+		if er.PointID != 12 {
+			t.Fatalf("expected point 12, got %d", er.PointID)
+		}
+		previous = er
+	}
+
+	if previous.EventID != 505 {
+		t.Fatalf("expected final event ID 505, got %d", previous.EventID)
+	}
+}
+
+func TestSession_Request(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	// play the part of the eIDC32: echo the requested lock status back.
+	go func() {
+		for msg := range fromProxy {
+			if msg.Type != MsgTypeDoor0x2fLockStatusRequest {
+				continue
+			}
+			dlsr, err := msg.ParseDoor0x2fLockStatusRequest()
+			if err != nil {
+				continue
+			}
+			body := fmt.Sprintf(`, "body":{"status":"%s"}`, dlsr.Status)
+			eidc.Write(eidcResponseBytes(Door0x2fLockStatusResponseCmd, body))
+		}
+	}()
+
+	msg, err := NewLockStatusMsg("admin", "admin", Unlocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := session.Request(*msg, MsgTypeDoor0x2fLockStatusResponse, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dlsr, err := resp.ParseDoor0x2fLockStatusResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dlsr.Status != Unlocked.String() {
+		t.Fatalf("expected status %s, got %s", Unlocked, dlsr.Status)
+	}
+
+	select {
+	case msg := <-toServer:
+		t.Fatalf("intercepted response leaked to the server as %s", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// nothing answers heartbeats, so this one times out and cleans up,
+	// including the manglers which came with it.
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ran []string
+	extra := []Mangler{orderMangler{name: "extra", ran: &ran}}
+	_, err = session.request(*hb, MsgTypeHeartbeatResponse, 100*time.Millisecond, extra)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	expectNoManglers := func() {
+		session.mangleLock.Lock()
+		manglers := len(session.manglers)
+		session.mangleLock.Unlock()
+		if manglers != 0 {
+			t.Fatalf("expected no manglers left behind, got %d", manglers)
+		}
+	}
+	expectNoManglers()
+
+	// an ended session doesn't get as far as installing anything
+	session.Close()
+	<-session.Done()
+	_, err = session.request(*hb, MsgTypeHeartbeatResponse, time.Second, extra)
+	if err == nil {
+		t.Fatal("expected an error from the ended session")
+	}
+	expectNoManglers()
+}
+
+func TestSession_SetIdleTimeout(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	timeout := 200 * time.Millisecond
+	session.SetIdleTimeout(timeout)
+
+	// keep the session busy for a while longer than the timeout
+	var last time.Time
+	for i := 0; i < 4; i++ {
+		time.Sleep(timeout / 2)
+		last = time.Now()
+		if _, err := eidc.Write(eidcEventBytes(100+i, EventAccessGranted)); err != nil {
+			t.Fatal(err)
+		}
+		<-toServer
+		select {
+		case <-session.Context().Done():
+			t.Fatal("busy session timed out")
+		default:
+		}
+	}
+
+	// now the eIDC32 goes silent
+	select {
+	case <-session.Context().Done():
+	case <-time.After(5 * timeout):
+		t.Fatal("idle session didn't end")
+	}
+
+	if session.EndTime.Sub(last) < timeout {
+		t.Fatalf("session ended %s after the last message, expected at least %s",
+			session.EndTime.Sub(last), timeout)
+	}
+}
+
+func TestSession_UpdateFtpCreds(t *testing.T) {
+	msg, err := ReadMsg([]byte(setFtpUserRequestTestData), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := Session{configLock: &sync.Mutex{}}
+	err = session.updateSessionData(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, password := session.FtpCredentials()
+	if username != "ftpuser" || password != "ftppass" {
+		t.Fatalf("expected ftpuser/ftppass, got %s/%s", username, password)
+	}
+}
+
+func TestSession_UpdateConfigKeyAndDeviceID(t *testing.T) {
+	session := Session{configLock: &sync.Mutex{}}
+
+	southbound := func(uri string, body string) *Message {
+		msg, err := ReadMsg([]byte(fmt.Sprintf(""+
+			"POST %s?username=admin&password=admin&seq=5 HTTP/1.1\r\n"+
+			"Host: 192.168.6.40\r\n"+
+			"User-Agent: eIDCListener\r\n"+
+			"Content-Type: application/json\r\n"+
+			"Content-Length: %d\r\n\r\n%s", uri, len(body), body)), Southbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	northbound := func(cmd string, body string) *Message {
+		msg, err := ReadMsg(eidcResponseBytes(cmd, body), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	testData := []struct {
+		msg       *Message
+		msgType   MsgType
+		configKey string
+		deviceID  int
+	}{
+		{
+			msg:       northbound(GetConfigKeyResponseCmd, `, "body":{"configurationKey":"abc123"}`),
+			msgType:   MsgTypeGetConfigKeyResponse,
+			configKey: "abc123",
+		},
+		{
+			msg:       southbound(setDeviceIDRequestURI, `{"deviceID":7}`),
+			msgType:   MsgTypeSetDeviceIDRequest,
+			configKey: "abc123",
+			deviceID:  7,
+		},
+		{
+			msg:       northbound(SetDeviceIDResponseCmd, ""),
+			msgType:   MsgTypeSetDeviceIDResponse,
+			configKey: "abc123",
+			deviceID:  7,
+		},
+		{
+			msg:       northbound(GetDeviceIDResponseCmd, `, "body":{"deviceID":9}`),
+			msgType:   MsgTypeGetDeviceIDResponse,
+			configKey: "abc123",
+			deviceID:  9,
+		},
+	}
+
+	for _, td := range testData {
+		if td.msg.GetType() != td.msgType {
+			t.Fatalf("expected %s, got %s", td.msgType, td.msg.GetType())
+		}
+		err := session.updateSessionData(td.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.ConfigurationKey() != td.configKey || session.DeviceID() != td.deviceID {
+			t.Fatalf("after %s expected config key %q and device ID %d, got %q and %d",
+				td.msgType, td.configKey, td.deviceID, session.ConfigurationKey(), session.DeviceID())
+		}
+	}
+}
+
+func TestSession_SetLockStatusAndWait(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+	toServer := pipeMsgChan(server, Northbound)
+
+	type result struct {
+		status string
+		err    error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		status, err := session.SetLockStatusAndWait(Unlocked, true, time.Second)
+		resultChan <- result{status: status, err: err}
+	}()
+
+	select {
+	case msg := <-fromProxy:
+		if msg.Type != MsgTypeDoor0x2fLockStatusRequest {
+			t.Fatalf("expected %s, got %s", MsgTypeDoor0x2fLockStatusRequest, msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for lock status request")
+	}
+
+	// the eIDC32 says the door is still locked, then reports the event
+	body := fmt.Sprintf(`, "body":{"status":"%s"}`, Locked)
+	if _, err := eidc.Write(eidcResponseBytes(Door0x2fLockStatusResponseCmd, body)); err != nil {
+		t.Fatal(err)
+	}
+	r := <-resultChan
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.status != Locked.String() {
+		t.Fatalf("expected status %s, got %s", Locked, r.status)
+	}
+
+	// the stealth manglers were installed too
+	if _, err := eidc.Write(eidcEventBytes(100, EventAccessGranted)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-fromProxy:
+		if msg.Type != MsgTypeEventAckRequest {
+			t.Fatalf("expected %s, got %s", MsgTypeEventAckRequest, msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event ack")
+	}
+	if _, err := eidc.Write(eidcResponseBytes(EventAckResponseCmd, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-toServer:
+		t.Fatalf("stealth lock status change leaked a %s message to the server", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// no response
+	go func() {
+		<-fromProxy
+	}()
+	_, err := session.SetLockStatusAndWait(Locked, false, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
+
+// orderMangler records its name in *ran each time it sees a message.
+type orderMangler struct {
+	name string
+	ran  *[]string
+}
+
+func (o orderMangler) Mangle(msg *Message) (MangleResult, error) {
+	*o.ran = append(*o.ran, o.name)
+	return ManglerSuccess, nil
+}
+
+// stallMangler blocks in Mangle() (holding the session's relay lock) until
+// release is closed, after telling the test on entered.
+type stallMangler struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (o stallMangler) Mangle(msg *Message) (MangleResult, error) {
+	close(o.entered)
+	<-o.release
+	return ManglerDone, nil
+}
+
+func TestSession_EndsWithMessageInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	session, eidc, server := newPipeSessionContext(t, ctx)
+	defer eidc.Close()
+	defer server.Close()
+
+	stall := stallMangler{entered: make(chan struct{}), release: make(chan struct{})}
+	session.AddMangler(stall)
+	go eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+
+	select {
+	case <-stall.entered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the message to reach the mangler")
+	}
+
+	// end the session while the message is being relayed
+	cancel()
+	<-session.Done()
+	time.Sleep(50 * time.Millisecond) // let the outbound relay quit
+	close(stall.release)
+
+	hb, err := NewHeartbeatMsg("admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		session.Inject(*hb, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Inject hung after the session ended with a message in flight")
+	}
+}
+
+func TestSession_ManglerOrder(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	// enough manglers that map iteration order would be caught out
+	var ran, expected []string
+	var ids []int
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("mangler %d", i)
+		expected = append(expected, name)
+		ids = append(ids, session.AddMangler(orderMangler{name: name, ran: &ran}))
+	}
+
+	// a deleted mangler's replacement runs last
+	session.DelMangler(ids[3])
+	expected = append(expected[:3], expected[4:]...)
+	expected = append(expected, "late mangler")
+	session.AddMangler(orderMangler{name: "late mangler", ran: &ran})
+
+	_, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+
+	if len(ran) != len(expected) {
+		t.Fatalf("expected %d manglers to run, got %d", len(expected), len(ran))
+	}
+	for i := range expected {
+		if ran[i] != expected[i] {
+			t.Fatalf("expected manglers to run in order %v, got %v", expected, ran)
+		}
+	}
+}
+
+func TestSession_AddManglers(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	var ran []string
+	first := session.AddMangler(orderMangler{name: "first", ran: &ran})
+	ids := session.AddManglers(
+		orderMangler{name: "a", ran: &ran},
+		orderMangler{name: "b", ran: &ran},
+		orderMangler{name: "c", ran: &ran},
+	)
+
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 ids, got %v", ids)
+	}
+	for i, id := range ids {
+		if id != first+1+i {
+			t.Fatalf("expected contiguous ids following %d, got %v", first, ids)
+		}
+		session.mangleLock.Lock()
+		_, ok := session.manglers[id]
+		session.mangleLock.Unlock()
+		if !ok {
+			t.Fatalf("mangler %d not installed", id)
+		}
+	}
+
+	_, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+
+	expected := []string{"first", "a", "b", "c"}
+	if strings.Join(ran, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected manglers to run in order %v, got %v", expected, ran)
+	}
+
+	if ids = session.AddManglers(); len(ids) != 0 {
+		t.Fatalf("expected no ids, got %v", ids)
+	}
+}
+
+func TestSession_DelManglersClearManglers(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	var ran []string
+	relay := func() {
+		ran = nil
+		_, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-toServer
+	}
+
+	ids := session.AddManglers(
+		orderMangler{name: "a", ran: &ran},
+		orderMangler{name: "b", ran: &ran},
+		orderMangler{name: "c", ran: &ran},
+		orderMangler{name: "d", ran: &ran},
+	)
+
+	session.DelManglers(ids[1], ids[2], 1000)
+	relay()
+	if strings.Join(ran, ",") != "a,d" {
+		t.Fatalf("expected manglers a and d to run, got %v", ran)
+	}
+
+	session.AddMangler(orderMangler{name: "e", ran: &ran})
+	session.ClearManglers()
+	relay()
+	if len(ran) != 0 {
+		t.Fatalf("expected no manglers to run after ClearManglers(), got %v", ran)
+	}
+
+	// new manglers can be added afterward
+	session.AddMangler(orderMangler{name: "f", ran: &ran})
+	relay()
+	if strings.Join(ran, ",") != "f" {
+		t.Fatalf("expected mangler f to run, got %v", ran)
+	}
+}
+
+func TestSession_DelManglerStaleID(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	var ran []string
+	session.AddMangler(orderMangler{name: "a", ran: &ran})
+	b := session.AddMangler(orderMangler{name: "b", ran: &ran})
+	session.DelMangler(b)
+	c := session.AddMangler(orderMangler{name: "c", ran: &ran})
+	if c == b {
+		t.Fatalf("mangler ID %d was reused", b)
+	}
+
+	// deleting b again mustn't take c with it
+	session.DelMangler(b)
+	_, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	if strings.Join(ran, ",") != "a,c" {
+		t.Fatalf("expected manglers a and c to run, got %v", ran)
+	}
+}
+
+func TestSession_SetImpersonation(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+
+	// collect the raw bytes arriving at the eIDC32
+	fromProxy := make(chan []byte, 10)
+	go func() {
+		s := bufio.NewScanner(eidc)
+		s.Split(SplitHttpMsg)
+		for s.Scan() {
+			fromProxy <- append([]byte{}, s.Bytes()...)
+		}
+		close(fromProxy)
+	}()
+
+	relay := func() string {
+		if _, err := server.Write([]byte(setFtpUserRequestTestData)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case b := <-fromProxy:
+			return string(b)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for relayed message")
+		}
+		return ""
+	}
+
+	if !session.Impersonating() {
+		t.Fatal("impersonation should be on by default")
+	}
+	impersonated := relay()
+
+	session.SetImpersonation(false)
+	if session.Impersonating() {
+		t.Fatal("impersonation should be off")
+	}
+	raw := relay()
+
+	// Go writes Content-Length ahead of the other headers; IntelliM doesn't.
+	if strings.Index(impersonated, "Content-Type:") > strings.Index(impersonated, "Content-Length:") {
+		t.Fatalf("impersonated message has Go's header order:\n%s", impersonated)
+	}
+	if strings.Index(raw, "Content-Type:") < strings.Index(raw, "Content-Length:") {
+		t.Fatalf("raw message doesn't have Go's header order:\n%s", raw)
+	}
+
+	// the raw message is exactly what RawString() renders
+	msg, err := ReadMsg([]byte(raw), Southbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := msg.RawString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs != raw {
+		t.Fatalf("expected RawString() to render\n%q\ngot\n%q", raw, rs)
+	}
+	s, err := msg.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == raw {
+		t.Fatal("String() should impersonate")
+	}
+}
+
+// dropAllMangler drops every message.
+type dropAllMangler struct{}
+
+func (o dropAllMangler) Mangle(msg *Message) (MangleResult, error) {
+	return ManglerDrop, nil
+}
+
+// setBodyMangler replaces the body of every message.
+type setBodyMangler struct {
+	body []byte
+}
+
+func (o setBodyMangler) Mangle(msg *Message) (MangleResult, error) {
+	msg.SetBody(o.body)
+	return ManglerSuccess, nil
+}
+
+func TestSession_Stats(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	msg := eidcResponseBytes(HeartbeatResponseCmd, "")
+	if _, err := eidc.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	relayed := <-toServer
+
+	session.AddMangler(dropAllMangler{})
+	if _, err := eidc.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the relay drop it
+
+	stats := session.Stats()
+	if stats.MsgsRelayed[MsgTypeHeartbeatResponse] != 1 {
+		t.Fatalf("expected 1 relayed %s, got %d", MsgTypeHeartbeatResponse, stats.MsgsRelayed[MsgTypeHeartbeatResponse])
+	}
+	if stats.BytesRelayed[Northbound] != uint64(len(relayed.OrigBytes())) {
+		t.Fatalf("expected %d bytes relayed northbound, got %d", len(relayed.OrigBytes()), stats.BytesRelayed[Northbound])
+	}
+	if stats.BytesRelayed[Southbound] != 0 {
+		t.Fatalf("expected no bytes relayed southbound, got %d", stats.BytesRelayed[Southbound])
+	}
+	if stats.MsgsDropped != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", stats.MsgsDropped)
+	}
+}
+
+func TestSession_Summary(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	session.LoginInfo.ConnectedReq.SerialNumber = "0x000000123456"
+	session.Tag = "site-a/0x000000123456"
+	session.Mitm = Mitm{
+		ClientSide: CxnDetail{Client: "192.168.1.50:1234", Server: "192.168.1.10:18800"},
+		ServerSide: CxnDetail{Client: "192.168.1.10:5678", Server: "203.0.113.7:18800"},
+	}
+
+	if _, err := eidc.Write(eidcResponseBytes(HeartbeatResponseCmd, "")); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	time.Sleep(50 * time.Millisecond) // let the relay count it
+	session.Close()
+
+	summary := session.Summary()
+	for _, expected := range []string{
+		"0x000000123456",
+		"tag:            site-a/0x000000123456",
+		"192.168.1.50:1234 -> 192.168.1.10:18800",
+		"192.168.1.10:5678 -> 203.0.113.7:18800",
+		"heartbeats:     1",
+		"1 relayed, 0 dropped",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Fatalf("expected summary to contain %q, got:\n%s", expected, summary)
+		}
+	}
+}
+
+func TestSession_EventsEnabledTimeSet(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	if session.EventsEnabled() || session.TimeSet() {
+		t.Fatal("expected a new session to have neither events enabled nor time set")
+	}
+
+	if _, err := eidc.Write(eidcResponseBytes(EnableEventsResponseCmd, "")); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	if !session.EventsEnabled() {
+		t.Fatal("expected events enabled after an enableevents response")
+	}
+	if session.TimeSet() {
+		t.Fatal("expected time not set before a settime response")
+	}
+
+	if _, err := eidc.Write(eidcResponseBytes(SetTimeResponseCmd, "")); err != nil {
+		t.Fatal(err)
+	}
+	<-toServer
+	if !session.TimeSet() {
+		t.Fatal("expected time set after a settime response")
+	}
+}
+
+func TestSession_ConfigurationKey(t *testing.T) {
+	eidcCxn, eidc := net.Pipe()
+	serverCxn, server := net.Pipe()
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	loginInfo := &LoginInfo{
+		Host:         "intellim.example.com",
+		ServerKey:    "serverkey",
+		ConnectedReq: ConnectedRequest{ConfigurationKey: "fromlogin"},
+	}
+	session := startSession(context.Background(), eidcCxn, bufio.NewReader(eidcCxn), serverCxn, loginInfo)
+	session.BeginRelaying()
+
+	if session.ConfigurationKey() != "fromlogin" {
+		t.Fatalf("expected the config key from the login, got %q", session.ConfigurationKey())
+	}
+
+	body := `{"ConfigurationKey":"fromserver"}`
+	_, err := fmt.Fprintf(server, "POST %s HTTP/1.1\r\n"+
+		"Host: 192.168.6.10\r\n"+
+		"User-Agent: eIDCListener\r\n"+
+		"Content-Type: application/json\r\n"+
+		"Content-Length: %d\r\n\r\n%s", setConfigKeyRequestURI, len(body), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-fromProxy
+	if msg.GetType() != MsgTypeSetConfigKeyRequest {
+		t.Fatalf("expected %s, got %s", MsgTypeSetConfigKeyRequest, msg.GetType())
+	}
+	if session.ConfigurationKey() != "fromserver" {
+		t.Fatalf("expected the config key from setConfigKey, got %q", session.ConfigurationKey())
+	}
+}
+
+func TestSession_Done(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer server.Close()
+
+	select {
+	case <-session.Done():
+		t.Fatal("expected Done() to stay open while the session is up")
+	default:
+	}
+
+	// the eIDC32 hangs up
+	eidc.Close()
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected Done() to close when the session ended")
+	}
+	if session.EndTime.IsZero() {
+		t.Fatal("expected the session end time to be set")
+	}
+}
+
+func TestSession_SetErrTimeout(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+
+	timeout := 300 * time.Millisecond
+	session.SetErrTimeout(timeout)
+	slow := session.SubscribeErr()
+
+	errChan := make(chan error)
+	go session.distribureErr(errChan)
+	defer close(errChan)
+
+	err1 := errors.New("one")
+	err2 := errors.New("two")
+	err3 := errors.New("three")
+
+	// err1 fills the subscriber's buffer, so delivery of err2 waits out the
+	// timeout before the distributor is ready for err3
+	errChan <- err1
+	errChan <- err2
+	start := time.Now()
+	errChan <- err3
+	elapsed := time.Since(start)
+	if elapsed < timeout || elapsed > 3*timeout {
+		t.Fatalf("expected err2 delivery to give up after %s, took %s", timeout, elapsed)
+	}
+
+	// err2 was dropped, err3 arrives once there's room
+	if err := <-slow; err != err1 {
+		t.Fatalf("expected %v, got %v", err1, err)
+	}
+	select {
+	case err := <-slow:
+		if err != err3 {
+			t.Fatalf("expected %v, got %v", err3, err)
+		}
+	case <-time.After(2 * timeout):
+		t.Fatal("expected err3 to be delivered")
+	}
+
+	// no timeout: errors are delivered only when there's room
+	session.SetErrTimeout(0)
+	errChan <- err1
+	start = time.Now()
+	errChan <- err2
+	errChan <- err3
+	if elapsed := time.Since(start); elapsed > timeout {
+		t.Fatalf("expected no waiting without a timeout, took %s", elapsed)
+	}
+	if err := <-slow; err != err1 {
+		t.Fatalf("expected %v, got %v", err1, err)
+	}
+}
+
+func TestSession_RecentEvents(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	ids := []int{100, 101, 102}
+	for _, id := range ids {
+		if _, err := eidc.Write(eidcEventBytes(id, EventAccessGranted)); err != nil {
+			t.Fatal(err)
+		}
+		<-toServer
+	}
+
+	all := session.RecentEvents(0)
+	if len(all) != len(ids) {
+		t.Fatalf("expected %d events, got %d", len(ids), len(all))
+	}
+	for i := range ids {
+		if all[i].EventID != ids[i] || all[i].EventType != EventAccessGranted {
+			t.Fatalf("event %d: expected ID %d type %d, got %+v", i, ids[i], EventAccessGranted, all[i])
+		}
+	}
+
+	last := session.RecentEvents(2)
+	if len(last) != 2 || last[0].EventID != 101 || last[1].EventID != 102 {
+		t.Fatalf("expected the last two events, got %+v", last)
+	}
+
+	// the history is capped
+	for id := 200; id < 200+eventHistorySize; id++ {
+		msg, err := ReadMsg(eidcEventBytes(id, EventAccessGranted), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = session.updateSessionData(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all = session.RecentEvents(0)
+	if len(all) != eventHistorySize {
+		t.Fatalf("expected history capped at %d events, got %d", eventHistorySize, len(all))
+	}
+	if all[0].EventID != 200 || all[len(all)-1].EventID != 200+eventHistorySize-1 {
+		t.Fatalf("expected events 200 through %d, got %d through %d",
+			200+eventHistorySize-1, all[0].EventID, all[len(all)-1].EventID)
+	}
+}
+
+func TestSession_Serial(t *testing.T) {
+	testData := []struct {
+		serial   string
+		expected int64
+		err      bool
+	}{
+		{serial: "0x000000123456", expected: 0x123456},
+		{serial: "0x00000000FFFF", expected: 65535},
+		{serial: "1193046", expected: 1193046},
+		{serial: "", err: true},
+		{serial: "0x00000012345G", err: true},
+	}
+
+	for _, td := range testData {
+		session := &Session{LoginInfo: LoginInfo{ConnectedReq: ConnectedRequest{SerialNumber: td.serial}}}
+		if session.Serial() != td.serial {
+			t.Fatalf("expected serial %q, got %q", td.serial, session.Serial())
+		}
+		result, err := session.SerialInt()
+		if td.err {
+			if err == nil {
+				t.Fatalf("%q: expected an error, got %d", td.serial, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", td.serial, err)
+		}
+		if result != td.expected {
+			t.Fatalf("%q: expected %d, got %d", td.serial, td.expected, result)
+		}
+	}
+}
+
+func TestSession_ClientIPDestination(t *testing.T) {
+	session := &Session{
+		LoginInfo: LoginInfo{
+			Host:         "intellim.example.com:18800",
+			ConnectedReq: ConnectedRequest{IPAddress: "192.168.1.50"},
+		},
+		Mitm: Mitm{ClientSide: CxnDetail{Client: "192.168.1.50:1234"}},
+	}
+	if session.ClientIP() != "192.168.1.50" {
+		t.Fatalf("expected 192.168.1.50, got %s", session.ClientIP())
+	}
+	if session.Destination() != "intellim.example.com:18800" {
+		t.Fatalf("expected intellim.example.com:18800, got %s", session.Destination())
+	}
+
+	// behind NAT
+	session.Mitm.ClientSide.Client = "203.0.113.9:1234"
+	if session.ClientIP() != "192.168.1.50 (203.0.113.9)" {
+		t.Fatalf("expected reported and observed addresses, got %s", session.ClientIP())
+	}
+}
+
+func TestSession_StartKeepAlive(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	interval := 20 * time.Millisecond
+	stop := session.StartKeepAlive(interval)
+
+	var count int
+	window := time.After(110 * time.Millisecond)
+COUNT:
+	for {
+		select {
+		case msg := <-fromProxy:
+			if msg.Type != MsgTypeHeartbeatRequest {
+				t.Fatalf("expected %s, got %s", MsgTypeHeartbeatRequest, msg.Type)
+			}
+			count++
+		case <-window:
+			break COUNT
+		}
+	}
+	if count < 3 || count > 6 {
+		t.Fatalf("expected about 5 heartbeats in 110ms at %s intervals, got %d", interval, count)
+	}
+
+	stop()
+	stop() // harmless
+	select {
+	case <-fromProxy: // one may have been in flight
+	case <-time.After(3 * interval):
+	}
+	select {
+	case msg := <-fromProxy:
+		t.Fatalf("expected no heartbeats after stop(), got %s", msg.Type)
+	case <-time.After(3 * interval):
+	}
+}
+
+func TestSession_SetVerbatim(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	// odd header casing, order and spacing which impersonation wouldn't
+	// reproduce
+	payload := `{"result":true, "cmd":"HEARTBEAT"}`
+	original := []byte(fmt.Sprintf("HTTP/1.0 200 OK\r\n"+
+		"cache-control: no-cache\r\n"+
+		"X-Firmware:   3.4.20\r\n"+
+		"content-length:  %d\r\n"+
+		"Content-type: application/json\r\n\r\n%s", len(payload), payload))
+
+	relay := func() []byte {
+		if _, err := eidc.Write(original); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-toServer:
+			return msg.OrigBytes()
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the relayed message")
+		}
+		return nil
+	}
+
+	if result := relay(); bytes.Equal(result, original) {
+		t.Fatal("expected the message to be rendered and impersonated without SetVerbatim()")
+	}
+
+	session.SetVerbatim(true)
+	if !session.Verbatim() {
+		t.Fatal("expected Verbatim() after SetVerbatim(true)")
+	}
+	if result := relay(); !bytes.Equal(result, original) {
+		t.Fatalf("expected the unmodified message byte-for-byte:\n%q\ngot:\n%q", original, result)
+	}
+
+	// modified messages are rendered
+	session.AddMangler(setBodyMangler{body: []byte(`{"result":true, "cmd":"HEARTBEAT", "x":1}`)})
+	if result := relay(); bytes.Equal(result, original) {
+		t.Fatal("expected a modified message to be rendered")
+	}
+}
+
+func TestSession_SetSequenceFixup(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	fromProxy := pipeMsgChan(eidc, Southbound)
+
+	relaySeq := func(seq int) string {
+		_, err := fmt.Fprintf(server, "GET %s?username=admin&password=admin&seq=%d HTTP/1.1\r\n"+
+			"Host: 192.168.6.10\r\n"+
+			"User-Agent: eIDCListener\r\n\r\n", heartbeatRequestURI, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-fromProxy:
+			return msg.Request.URL.Query().Get(serverRequestSequenceParam)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the relayed message")
+		}
+		return ""
+	}
+
+	if !session.SequenceFixup() {
+		t.Fatal("expected sequence fixup to be on by default")
+	}
+
+	// out of order, and left alone
+	session.SetSequenceFixup(false)
+	if seq := relaySeq(7); seq != "7" {
+		t.Fatalf("expected seq 7 to pass through without fixup, got %s", seq)
+	}
+
+	// out of order, and renumbered
+	session.SetSequenceFixup(true)
+	if seq := relaySeq(5); seq != "1" {
+		t.Fatalf("expected seq 5 to be renumbered to 1 with fixup, got %s", seq)
+	}
+}
+
+func TestSession_PauseResume(t *testing.T) {
+	session, eidc, server := newPipeSession(t)
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	expectRelay := func(relayed bool) {
+		select {
+		case msg := <-toServer:
+			if !relayed {
+				t.Fatalf("unexpected relay of %s", msg.Type)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if relayed {
+				t.Fatal("message wasn't relayed")
+			}
+		}
+	}
+	send := func(eventID int) {
+		if _, err := eidc.Write(eidcEventBytes(eventID, EventAccessGranted)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(1)
+	expectRelay(true)
+
+	// nested pauses hold the message until the last Resume()
+	session.Pause()
+	session.Pause()
+	send(2)
+	expectRelay(false)
+	session.Resume()
+	expectRelay(false)
+	session.Resume()
+	expectRelay(true)
+
+	// manglers added while paused apply to messages which arrived meanwhile
+	session.Pause()
+	send(3)
+	session.AddMangler(dropAllMangler{})
+	session.Resume()
+	expectRelay(false)
+
+	// an unmatched Resume() is harmless
+	session.Resume()
+}
+
+func TestNewSession_Pipelined(t *testing.T) {
+	eidcCxn, eidc := net.Pipe()
+	serverCxn, server := net.Pipe()
+	defer eidc.Close()
+	defer server.Close()
+	toServer := pipeMsgChan(server, Northbound)
+
+	// the login and two events arrive in a single read
+	pipelined := []byte(loginInfoTestData)
+	pipelined = append(pipelined, eidcEventBytes(1, EventAccessGranted)...)
+	pipelined = append(pipelined, eidcEventBytes(2, EventAccessDenied_InsufficientPrivileges)...)
+	go eidc.Write(pipelined)
+
+	var dialed string
+	session, err := newSessionDial(context.Background(), eidcCxn, func(ctx context.Context, host string) (net.Conn, error) {
+		dialed = host
+		return serverCxn, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if dialed != session.LoginInfo.Host {
+		t.Fatalf("expected to dial %s, dialed %s", session.LoginInfo.Host, dialed)
+	}
+
+	seen := make(chan *Message, 10)
+	session.OnMessage(func(msg *Message) { seen <- msg })
+	session.BeginRelaying()
+
+	next := func() *Message {
+		select {
+		case msg := <-toServer:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for relayed message")
+		}
+		return nil
+	}
+
+	if msg := next(); msg.Type != MsgTypeConnectedRequest {
+		t.Fatalf("expected %s, got %s", MsgTypeConnectedRequest, msg.Type)
+	}
+	// and another arrives later
+	go eidc.Write(eidcEventBytes(3, EventAccessGranted))
+	for _, id := range []int{1, 2, 3} {
+		msg := next()
+		er, err := msg.ParseEventRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if er.EventID != id {
+			t.Fatalf("expected event %d, got %d", id, er.EventID)
+		}
+	}
+
+	// each message kept its own original bytes
+	expected := []MsgType{MsgTypeConnectedRequest, MsgTypeEventRequest, MsgTypeEventRequest, MsgTypeEventRequest}
+	for i, e := range expected {
+		msg := <-seen
+		orig, err := ReadMsg(msg.OrigBytes(), Northbound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orig.Type != e {
+			t.Fatalf("message %d: expected original bytes of %s, got %s", i, e, orig.Type)
+		}
+	}
+}
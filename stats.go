@@ -0,0 +1,103 @@
+package eidc32proxy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionStats is a snapshot of a session's counters, see Session.Stats().
+type SessionStats struct {
+	BytesRelayed  map[Direction]uint64 // Bytes written toward the IntelliM (Northbound) and eIDC32 (Southbound)
+	MsgsRelayed   map[MsgType]uint64   // Messages written (relayed or injected), by type
+	MsgsDropped   uint64               // Messages dropped by manglers
+	PagerTimeouts uint64               // Messages the pager gave up delivering to slow subscribers
+}
+
+func newSessionStats() SessionStats {
+	return SessionStats{
+		BytesRelayed: make(map[Direction]uint64),
+		MsgsRelayed:  make(map[MsgType]uint64),
+	}
+}
+
+// sessionStats holds a session's counters.
+type sessionStats struct {
+	mu    sync.Mutex
+	stats SessionStats
+}
+
+// pagerTimeoutCounter is implemented by MessagePagers which count the
+// messages they failed to deliver.
+type pagerTimeoutCounter interface {
+	Timeouts() uint64
+}
+
+// Stats returns a snapshot of the session's counters.
+func (o *Session) Stats() SessionStats {
+	result := newSessionStats()
+
+	o.stats.mu.Lock()
+	for dir, n := range o.stats.stats.BytesRelayed {
+		result.BytesRelayed[dir] = n
+	}
+	for t, n := range o.stats.stats.MsgsRelayed {
+		result.MsgsRelayed[t] = n
+	}
+	result.MsgsDropped = o.stats.stats.MsgsDropped
+	o.stats.mu.Unlock()
+
+	if p, ok := o.Pager.(pagerTimeoutCounter); ok {
+		result.PagerTimeouts = p.Timeouts()
+	}
+	return result
+}
+
+// countRelayed notes that msg was written as n bytes.
+func (o *Session) countRelayed(msg *Message, n int) {
+	o.stats.mu.Lock()
+	o.stats.stats.BytesRelayed[msg.Direction()] += uint64(n)
+	o.stats.stats.MsgsRelayed[msg.Type]++
+	o.stats.mu.Unlock()
+}
+
+// countDropped notes that a mangler dropped a message.
+func (o *Session) countDropped() {
+	o.stats.mu.Lock()
+	o.stats.stats.MsgsDropped++
+	o.stats.mu.Unlock()
+}
+
+// Summary returns a multi-line description of the session, suitable for
+// logging when the session ends: the eIDC32's serial number, the session's
+// tag (if any, see Server.SetSessionTagger()), both sides of the proxied
+// connection, uptime, heartbeats, whether events were enabled
+// and the message counters from Stats().
+func (o *Session) Summary() string {
+	end := o.EndTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	stats := o.Stats()
+	var relayed uint64
+	for _, n := range stats.MsgsRelayed {
+		relayed += n
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "session summary for eIDC32 %s\n", o.Serial())
+	if o.Tag != "" {
+		fmt.Fprintf(b, "  tag:            %s\n", o.Tag)
+	}
+	fmt.Fprintf(b, "  eIDC32 side:    %s -> %s\n", o.Mitm.ClientSide.Client, o.Mitm.ClientSide.Server)
+	fmt.Fprintf(b, "  IntelliM side:  %s -> %s\n", o.Mitm.ServerSide.Client, o.Mitm.ServerSide.Server)
+	fmt.Fprintf(b, "  uptime:         %s\n", end.Sub(o.StartTime).Round(time.Second))
+	fmt.Fprintf(b, "  heartbeats:     %d\n", o.HeartBeats())
+	fmt.Fprintf(b, "  events enabled: %t\n", o.EventsEnabled())
+	fmt.Fprintf(b, "  messages:       %d relayed, %d dropped\n", relayed, stats.MsgsDropped)
+	fmt.Fprintf(b, "  bytes:          %d northbound, %d southbound\n",
+		stats.BytesRelayed[Northbound], stats.BytesRelayed[Southbound])
+	return b.String()
+}
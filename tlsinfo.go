@@ -0,0 +1,225 @@
+package eidc32proxy
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	tlsRecordHeaderLen      = 5
+	tlsRecordTypeHandshake  = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtSupportedGroups   = 0x000a
+	tlsExtECPointFormats    = 0x000b
+	tlsHandshakeHeaderLen   = 4
+	tlsClientHelloRandomLen = 32
+)
+
+// TLSInfo describes the TLS ClientHello sent by the eIDC32 when it connected
+// to the proxy. terribletls doesn't expose the ClientHello, so the Server
+// records the first TLS record read from each accepted connection before
+// handing it to the TLS handshake.
+type TLSInfo struct {
+	ClientHello []byte // The raw TLS record containing the ClientHello
+	JA3         string // JA3 fingerprint string
+	JA3Hash     string // MD5 of the JA3 string, hex encoded
+}
+
+// helloRecorder wraps a net.Conn and keeps a copy of the first complete TLS
+// record read from it. When the connection is handed to a TLS server, that
+// record is the client's ClientHello.
+type helloRecorder struct {
+	net.Conn
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+}
+
+func (o *helloRecorder) Read(b []byte) (int, error) {
+	n, err := o.Conn.Read(b)
+	o.mu.Lock()
+	if !o.done && n > 0 {
+		o.buf = append(o.buf, b[:n]...)
+		if len(o.buf) >= tlsRecordHeaderLen {
+			recordLen := tlsRecordHeaderLen + int(binary.BigEndian.Uint16(o.buf[3:5]))
+			if len(o.buf) >= recordLen {
+				o.buf = o.buf[:recordLen]
+				o.done = true
+			}
+		}
+	}
+	o.mu.Unlock()
+	return n, err
+}
+
+// clientHello returns a copy of the recorded TLS record, or nil if a complete
+// record hasn't been read yet.
+func (o *helloRecorder) clientHello() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.done {
+		return nil
+	}
+	return append([]byte{}, o.buf...)
+}
+
+// tlsInfo returns TLSInfo describing the recorded ClientHello.
+func (o *helloRecorder) tlsInfo() (TLSInfo, error) {
+	record := o.clientHello()
+	if record == nil {
+		return TLSInfo{}, errors.New("no TLS ClientHello recorded")
+	}
+	return newTLSInfo(record)
+}
+
+// newTLSInfo parses a TLS record containing a ClientHello and returns its
+// TLSInfo.
+func newTLSInfo(record []byte) (TLSInfo, error) {
+	ja3, err := ja3String(record)
+	if err != nil {
+		return TLSInfo{}, err
+	}
+	sum := md5.Sum([]byte(ja3))
+	return TLSInfo{
+		ClientHello: record,
+		JA3:         ja3,
+		JA3Hash:     hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// ja3String builds the JA3 fingerprint string from a TLS record containing a
+// ClientHello: SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+// with GREASE values omitted. See https://github.com/salesforce/ja3
+func ja3String(record []byte) (string, error) {
+	if len(record) < tlsRecordHeaderLen || record[0] != tlsRecordTypeHandshake {
+		return "", errors.New("not a TLS handshake record")
+	}
+	hs := record[tlsRecordHeaderLen:]
+	if len(hs) < tlsHandshakeHeaderLen || hs[0] != tlsHandshakeClientHello {
+		return "", errors.New("TLS handshake record doesn't contain a ClientHello")
+	}
+	helloLen := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+	hello := hs[tlsHandshakeHeaderLen:]
+	if len(hello) < helloLen {
+		return "", fmt.Errorf("ClientHello truncated: have %d bytes, expected %d", len(hello), helloLen)
+	}
+	r := helloReader{b: hello[:helloLen]}
+
+	version := r.uint16()
+	r.skip(tlsClientHelloRandomLen)
+	r.skip(int(r.uint8()))              // session ID
+	ciphers := r.bytes(int(r.uint16())) // cipher suites
+	r.skip(int(r.uint8()))              // compression methods
+
+	var extensions, curves, pointFormats []uint16
+	if !r.empty() {
+		ext := helloReader{b: r.bytes(int(r.uint16()))}
+		for !ext.empty() && ext.err == nil {
+			extType := ext.uint16()
+			extData := helloReader{b: ext.bytes(int(ext.uint16()))}
+			if isGREASE(extType) {
+				continue
+			}
+			extensions = append(extensions, extType)
+			switch extType {
+			case tlsExtSupportedGroups:
+				curves = uint16List(extData.bytes(int(extData.uint16())))
+			case tlsExtECPointFormats:
+				for _, f := range extData.bytes(int(extData.uint8())) {
+					pointFormats = append(pointFormats, uint16(f))
+				}
+			}
+		}
+		if ext.err != nil {
+			return "", ext.err
+		}
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinUint16(uint16List(ciphers)),
+		joinUint16(extensions),
+		joinUint16(curves),
+		joinUint16(pointFormats),
+	}, ","), nil
+}
+
+// helloReader consumes big-endian fields from a ClientHello. The first
+// out-of-bounds read sets err, after which all reads return zero values.
+type helloReader struct {
+	b   []byte
+	err error
+}
+
+func (o *helloReader) bytes(n int) []byte {
+	if o.err != nil {
+		return nil
+	}
+	if n > len(o.b) {
+		o.err = errors.New("ClientHello truncated")
+		return nil
+	}
+	result := o.b[:n]
+	o.b = o.b[n:]
+	return result
+}
+
+func (o *helloReader) skip(n int) {
+	o.bytes(n)
+}
+
+func (o *helloReader) uint8() uint8 {
+	b := o.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (o *helloReader) uint16() uint16 {
+	b := o.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (o *helloReader) empty() bool {
+	return len(o.b) == 0
+}
+
+// uint16List decodes a byte slice into big-endian uint16s, dropping GREASE
+// values.
+func uint16List(b []byte) []uint16 {
+	var result []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		v := binary.BigEndian.Uint16(b[i:])
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// isGREASE returns true for the reserved values (RFC 8701) which JA3 ignores.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func joinUint16(in []uint16) string {
+	s := make([]string, len(in))
+	for i, v := range in {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
@@ -0,0 +1,91 @@
+package eidc32proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/chrismarget/terribletls"
+)
+
+func TestHelloRecorder(t *testing.T) {
+	nl, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+
+	// The client's handshake fails when we hang up without answering,
+	// but not before it has sent the ClientHello.
+	go func() {
+		c, err := net.Dial(network, nl.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		terribletls.Client(c, &terribletls.Config{
+			ServerName:         "intellim.example.com",
+			InsecureSkipVerify: true,
+		}).Handshake()
+	}()
+
+	conn, err := nl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := &helloRecorder{Conn: conn}
+	buf := make([]byte, 64)
+	for hello.clientHello() == nil {
+		if _, err = hello.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	info, err := hello.tlsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.ClientHello) == 0 {
+		t.Fatal("empty ClientHello")
+	}
+	if len(strings.Split(info.JA3, ",")) != 5 {
+		t.Fatalf("malformed JA3 string '%s'", info.JA3)
+	}
+	if len(info.JA3Hash) != 32 {
+		t.Fatalf("malformed JA3 hash '%s'", info.JA3Hash)
+	}
+}
+
+func TestJA3String(t *testing.T) {
+	// Minimal TLS 1.2 ClientHello: two cipher suites (one GREASE), no
+	// compression, and supported_groups/ec_point_formats extensions.
+	hello := []byte{
+		0x03, 0x03, // client version
+	}
+	hello = append(hello, make([]byte, 32)...) // random
+	hello = append(hello,
+		0x00,                               // session ID length
+		0x00, 0x04, 0x0a, 0x0a, 0x00, 0x04, // cipher suites: GREASE, TLS_RSA_WITH_RC4_128_MD5
+		0x01, 0x00, // compression methods: null
+		0x00, 0x10, // extensions length
+		0x00, 0x0a, 0x00, 0x06, 0x00, 0x04, 0x00, 0x17, 0x00, 0x18, // supported_groups: 23, 24
+		0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, // ec_point_formats: 0
+	)
+	hs := append([]byte{0x01, 0x00, 0x00, byte(len(hello))}, hello...)
+	record := append([]byte{0x16, 0x03, 0x01, 0x00, byte(len(hs))}, hs...)
+
+	result, err := ja3String(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "771,4,10-11,23-24,0"
+	if result != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, result)
+	}
+
+	_, err = ja3String(record[:len(record)-3])
+	if err == nil {
+		t.Fatal("truncated ClientHello should have produced an error")
+	}
+}
@@ -15,12 +15,26 @@ func (o *Session) updateSessionData(msg *Message) error {
 		return o.updateSessionDataWithGetoutboundResponse(msg)
 	case MsgTypeSetWebUserRequest:
 		return o.updateSessionDataWithSetWebUserRequest(msg)
+	case MsgTypeSetFtpUserRequest:
+		return o.updateSessionDataWithSetFtpUserRequest(msg)
+	case MsgTypeSetConfigKeyRequest:
+		return o.updateSessionDataWithSetConfigKeyRequest(msg)
+	case MsgTypeGetConfigKeyResponse:
+		return o.updateSessionDataWithGetConfigKeyResponse(msg)
+	case MsgTypeSetDeviceIDRequest:
+		return o.updateSessionDataWithSetDeviceIDRequest(msg)
+	case MsgTypeGetDeviceIDResponse:
+		return o.updateSessionDataWithGetDeviceIDResponse(msg)
 	case MsgTypeEnableEventsResponse:
 		return o.updateSessionDataWithEnableEventsResponse(msg)
+	case MsgTypeSetTimeResponse:
+		return o.updateSessionDataWithSetTimeResponse(msg)
 	case MsgTypePointStatusRequest:
 		return o.updateSessionDataWithPointStatusRequest(msg)
 	case MsgTypeHeartbeatResponse:
 		return o.updateSessionDataWithHeartbeatResponse(msg)
+	case MsgTypeEventRequest:
+		return o.updateSessionDataWithEventRequest(msg)
 	default:
 		return nil
 	}
@@ -31,10 +45,12 @@ func (o *Session) updateSessionDataWithConnectedResponse(msg *Message) error {
 	if err != nil {
 		return err
 	}
+	o.serverKeyLock.Lock()
 	if o.serverKeys[len(o.serverKeys)-1] != r.ServerKey {
 		o.serverKeys = append(o.serverKeys, r.ServerKey)
 
 	}
+	o.serverKeyLock.Unlock()
 	return nil
 }
 
@@ -49,10 +65,12 @@ func (o *Session) updateSessionDataApiCredentials(msg *Message) error {
 		return err
 	}
 
+	o.configLock.Lock()
 	o.apiCreds = UsernameAndPassword{
 		username: values.Get(queryParamUsername),
 		password: values.Get(queryParamPassword),
 	}
+	o.configLock.Unlock()
 
 	return nil
 }
@@ -78,13 +96,87 @@ func (o *Session) updateSessionDataWithSetWebUserRequest(msg *Message) error {
 	return nil
 }
 
+func (o *Session) updateSessionDataWithSetFtpUserRequest(msg *Message) error {
+	r, err := msg.ParseSetFtpUserRequest()
+	if err != nil {
+		return err
+	}
+	o.configLock.Lock()
+	o.ftpCreds = UsernameAndPassword{
+		username: r.User,
+		password: r.Password,
+	}
+	o.configLock.Unlock()
+	return nil
+}
+
+func (o *Session) updateSessionDataWithGetConfigKeyResponse(msg *Message) error {
+	r, err := msg.ParseGetConfigKeyResponse()
+	if err != nil {
+		return err
+	}
+	o.configLock.Lock()
+	o.configKey = r.ConfigurationKey
+	o.configLock.Unlock()
+	return nil
+}
+
+func (o *Session) updateSessionDataWithSetConfigKeyRequest(msg *Message) error {
+	r, err := msg.ParseSetConfigKeyRequest()
+	if err != nil {
+		return err
+	}
+	o.configLock.Lock()
+	o.configKey = r.ConfigurationKey
+	o.configLock.Unlock()
+	return nil
+}
+
+func (o *Session) updateSessionDataWithSetDeviceIDRequest(msg *Message) error {
+	r, err := msg.ParseSetDeviceIDRequest()
+	if err != nil {
+		return err
+	}
+	o.configLock.Lock()
+	o.deviceID = r.DeviceID
+	o.configLock.Unlock()
+	return nil
+}
+
+func (o *Session) updateSessionDataWithGetDeviceIDResponse(msg *Message) error {
+	r, err := msg.ParseGetDeviceIDResponse()
+	if err != nil {
+		return err
+	}
+	o.configLock.Lock()
+	o.deviceID = r.DeviceID
+	o.configLock.Unlock()
+	return nil
+}
+
 func (o *Session) updateSessionDataWithEnableEventsResponse(msg *Message) error {
 	eventsEnabled, err := msg.ParseEnableEventsResponse()
 	if err != nil {
 		return err
 	}
 
+	o.statusLock.Lock()
 	o.eventsEnabled = eventsEnabled
+	o.statusLock.Unlock()
+	return nil
+}
+
+func (o *Session) updateSessionDataWithSetTimeResponse(msg *Message) error {
+	ok, err := msg.ParseSetTimeResponse()
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		o.statusLock.Lock()
+		o.timeSet = true
+		o.statusLock.Unlock()
+	}
 	return nil
 }
 
@@ -93,9 +185,11 @@ func (o *Session) updateSessionDataWithPointStatusRequest(msg *Message) error {
 	if err != nil {
 		return err
 	}
+	o.pointLock.Lock()
 	for _, p := range ps.Points {
 		o.pointStatus[p.PointID] = p
 	}
+	o.pointLock.Unlock()
 	return nil
 }
 
@@ -104,6 +198,100 @@ func (o *Session) updateSessionDataWithHeartbeatResponse(msg *Message) error {
 	return nil
 }
 
+// updateSessionDataWithEventRequest keeps track of the eIDC32's event IDs and
+// times so that injected events can follow on from them, and keeps a history
+// of recent events for RecentEvents().
+func (o *Session) updateSessionDataWithEventRequest(msg *Message) error {
+	er, err := msg.ParseEventRequest()
+	if err != nil {
+		return err
+	}
+	o.eventLock.Lock()
+	if er.EventID > o.lastEventID {
+		o.lastEventID = er.EventID
+	}
+	if er.Time > o.lastEventTime {
+		o.lastEventTime = er.Time
+	}
+	if len(o.recentEvents) >= eventHistorySize {
+		o.recentEvents = o.recentEvents[1:]
+	}
+	o.recentEvents = append(o.recentEvents, er)
+	o.eventLock.Unlock()
+	return nil
+}
+
+// EventsEnabled returns true if the eIDC32's most recent response to an
+// enableevents request says events are enabled.
+func (o *Session) EventsEnabled() bool {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	return o.eventsEnabled
+}
+
+// TimeSet returns true once the eIDC32 has acknowledged a settime request
+// from IntelliM (or the proxy) during the session.
+func (o *Session) TimeSet() bool {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+	return o.timeSet
+}
+
+// ConfigurationKey returns the eIDC32's current configuration key: the one it
+// reported when it connected, or the latest one set (setConfigKey) or read
+// back (getConfigKey) by IntelliM since.
+func (o *Session) ConfigurationKey() string {
+	o.configLock.Lock()
+	defer o.configLock.Unlock()
+	return o.configKey
+}
+
+// FtpCredentials returns the FTP username and password IntelliM most
+// recently set on the eIDC32 (setFtpUser) during the session, if any.
+func (o *Session) FtpCredentials() (username string, password string) {
+	o.configLock.Lock()
+	defer o.configLock.Unlock()
+	return o.ftpCreds.username, o.ftpCreds.password
+}
+
+// apiCredentials returns the API credentials IntelliM has been seen to use,
+// for messages the proxy injects.
+func (o *Session) apiCredentials() UsernameAndPassword {
+	o.configLock.Lock()
+	defer o.configLock.Unlock()
+	return o.apiCreds
+}
+
+// DeviceID returns the eIDC32's device ID, as most recently set (setDeviceID)
+// or read back (getDeviceID) by IntelliM during the session. It's 0 until
+// one of those has been seen.
+func (o *Session) DeviceID() int {
+	o.configLock.Lock()
+	defer o.configLock.Unlock()
+	return o.deviceID
+}
+
 func (o *Session) HeartBeats() uint32 {
 	return o.heartbeats
 }
+
+// ServerKeys returns a copy of the server keys seen in the session, oldest
+// first. The first one comes from the eIDC32's ConnectedRequest; the rest
+// appear each time IntelliM's ConnectedResponse assigns a new key.
+func (o *Session) ServerKeys() []string {
+	o.serverKeyLock.Lock()
+	defer o.serverKeyLock.Unlock()
+	return append([]string{}, o.serverKeys...)
+}
+
+// PointStatus returns a copy of the most recent status reported by the
+// eIDC32 for each point, keyed by point ID.
+func (o *Session) PointStatus() map[int]Point {
+	o.pointLock.Lock()
+	defer o.pointLock.Unlock()
+	result := make(map[int]Point, len(o.pointStatus))
+	for id, p := range o.pointStatus {
+		result[id] = p
+	}
+	return result
+}